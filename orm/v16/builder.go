@@ -1,7 +1,7 @@
 package v16
 
 import (
-	"github.com/go-tour/orm/internal/errs"
+	"github.com/go-tour/orm/v16/internal/errs"
 	"github.com/go-tour/orm/v16/model"
 	"strings"
 )
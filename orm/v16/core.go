@@ -17,7 +17,7 @@ package v16
 import (
 	"context"
 	"database/sql"
-	"github.com/go-tour/orm/internal/valuer"
+	"github.com/go-tour/orm/v16/internal/valuer"
 	"github.com/go-tour/orm/v16/model"
 )
 
@@ -44,6 +44,8 @@ func getHandler[T any](ctx context.Context,
 			Err: err,
 		}
 	}
+	// 不关闭的话连接就不会被放回连接池
+	defer rows.Close()
 
 	if !rows.Next() {
 		return &QueryResult{
@@ -77,6 +79,59 @@ func get[T any](ctx context.Context, c core, sess session, qc *QueryContext) *Qu
 	return handler(ctx, qc)
 }
 
+// getMultiHandler 和 getHandler 类似，只是会读取所有的行
+// 没有数据的时候返回空切片，而不是 ErrNoRows
+func getMultiHandler[T any](ctx context.Context,
+	sess session,
+	c core,
+	qc *QueryContext) *QueryResult {
+	q, err := qc.Builder.Build()
+	if err != nil {
+		return &QueryResult{
+			Err: err,
+		}
+	}
+	rows, err := sess.queryContext(ctx, q.SQL, q.Args...)
+	if err != nil {
+		return &QueryResult{
+			Err: err,
+		}
+	}
+	defer rows.Close()
+
+	meta, err := c.r.Get(new(T))
+	if err != nil {
+		return &QueryResult{
+			Err: err,
+		}
+	}
+	res := make([]*T, 0, 8)
+	for rows.Next() {
+		tp := new(T)
+		if err = c.valCreator(tp, meta).SetColumns(rows); err != nil {
+			return &QueryResult{
+				Err: err,
+			}
+		}
+		res = append(res, tp)
+	}
+	return &QueryResult{
+		Result: res,
+		Err:    rows.Err(),
+	}
+}
+
+func getMulti[T any](ctx context.Context, c core, sess session, qc *QueryContext) *QueryResult {
+	var handler HandleFunc = func(ctx context.Context, qc *QueryContext) *QueryResult {
+		return getMultiHandler[T](ctx, sess, c, qc)
+	}
+	ms := c.ms
+	for i := len(ms) - 1; i >= 0; i-- {
+		handler = ms[i](handler)
+	}
+	return handler(ctx, qc)
+}
+
 func exec(ctx context.Context, sess session, c core, qc *QueryContext) Result {
 	var handler HandleFunc = func(ctx context.Context, qc *QueryContext) *QueryResult {
 		q, err := qc.Builder.Build()
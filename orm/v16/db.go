@@ -4,8 +4,8 @@ import (
 	"context"
 	"database/sql"
 	"database/sql/driver"
-	"github.com/go-tour/orm/internal/errs"
-	"github.com/go-tour/orm/internal/valuer"
+	"github.com/go-tour/orm/v16/internal/errs"
+	"github.com/go-tour/orm/v16/internal/valuer"
 	"github.com/go-tour/orm/v16/model"
 	"log"
 	"time"
@@ -154,6 +154,11 @@ func (db *DB) Close() error {
 	return db.db.Close()
 }
 
+// PingContext 检查数据库连接是否可用，例如用于健康检查
+func (db *DB) PingContext(ctx context.Context) error {
+	return db.db.PingContext(ctx)
+}
+
 func (db *DB) getCore() core {
 	return db.core
 }
@@ -1,6 +1,6 @@
 package v16
 
-import "github.com/go-tour/orm/internal/errs"
+import "github.com/go-tour/orm/v16/internal/errs"
 
 // 将内部的 sentinel error 暴露出去
 var (
@@ -2,8 +2,8 @@ package integration
 
 import (
 	"context"
-	"github.com/go-tour/orm"
-	"github.com/go-tour/orm/internal/test"
+	orm "github.com/go-tour/orm/v16"
+	"github.com/go-tour/orm/v16/internal/test"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
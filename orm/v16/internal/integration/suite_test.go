@@ -1,7 +1,7 @@
 package integration
 
 import (
-	"github.com/go-tour/orm"
+	orm "github.com/go-tour/orm/v16"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 )
@@ -2,7 +2,7 @@ package valuer
 
 import (
 	"database/sql"
-	"github.com/go-tour/orm/internal/errs"
+	"github.com/go-tour/orm/v16/internal/errs"
 	"github.com/go-tour/orm/v16/model"
 	"reflect"
 )
@@ -17,8 +17,8 @@ package valuer
 import (
 	"database/sql/driver"
 	"github.com/DATA-DOG/go-sqlmock"
-	"github.com/go-tour/orm/internal/errs"
-	"github.com/go-tour/orm/internal/test"
+	"github.com/go-tour/orm/v16/internal/errs"
+	"github.com/go-tour/orm/v16/internal/test"
 	"github.com/go-tour/orm/v16/model"
 	"github.com/stretchr/testify/assert"
 	"testing"
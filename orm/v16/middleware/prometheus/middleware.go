@@ -2,7 +2,7 @@ package prometheus
 
 import (
 	"context"
-	"github.com/go-tour/orm"
+	orm "github.com/go-tour/orm/v16"
 	"github.com/prometheus/client_golang/prometheus"
 	"time"
 )
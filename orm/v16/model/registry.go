@@ -1,7 +1,7 @@
 package model
 
 import (
-	"github.com/go-tour/orm/internal/errs"
+	"github.com/go-tour/orm/v16/internal/errs"
 	"reflect"
 	"strings"
 	"sync"
@@ -3,7 +3,7 @@ package model
 import (
 	"database/sql"
 	"errors"
-	"github.com/go-tour/orm/internal/errs"
+	"github.com/go-tour/orm/v16/internal/errs"
 	"github.com/stretchr/testify/assert"
 	"reflect"
 	"testing"
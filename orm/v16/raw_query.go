@@ -33,8 +33,14 @@ func (r *RawQuerier[T]) Get(ctx context.Context) (*T, error) {
 }
 
 func (r *RawQuerier[T]) GetMulti(ctx context.Context) ([]*T, error) {
-	// TODO implement me
-	panic("implement me")
+	res := getMulti[T](ctx, r.core, r.sess, &QueryContext{
+		Builder: r,
+		Type:    "RAW",
+	})
+	if res.Result != nil {
+		return res.Result.([]*T), res.Err
+	}
+	return nil, res.Err
 }
 
 func (r *RawQuerier[T]) Build() (*Query, error) {
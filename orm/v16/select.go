@@ -2,8 +2,7 @@ package v16
 
 import (
 	"context"
-	"database/sql"
-	"github.com/go-tour/orm/internal/errs"
+	"github.com/go-tour/orm/v16/internal/errs"
 )
 
 // Selector 用于构造 SELECT 语句
@@ -238,20 +237,14 @@ func (s *Selector[T]) Get(ctx context.Context) (*T, error) {
 }
 
 func (s *Selector[T]) GetMulti(ctx context.Context) ([]*T, error) {
-	var db sql.DB
-	q, err := s.Build()
-	if err != nil {
-		return nil, err
-	}
-	rows, err := db.QueryContext(ctx, q.SQL, q.Args...)
-	if err != nil {
-		return nil, err
-	}
-
-	for rows.Next() {
-		// 在这里构造 []*T
+	res := getMulti[T](ctx, s.core, s.sess, &QueryContext{
+		Builder: s,
+		Type:    "SELECT",
+	})
+	if res.Result != nil {
+		return res.Result.([]*T), res.Err
 	}
-	panic("implement me")
+	return nil, res.Err
 }
 
 func NewSelector[T any](sess session) *Selector[T] {
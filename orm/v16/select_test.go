@@ -6,8 +6,8 @@ import (
 	"errors"
 	"fmt"
 	"github.com/DATA-DOG/go-sqlmock"
-	"github.com/go-tour/orm/internal/errs"
-	"github.com/go-tour/orm/internal/valuer"
+	"github.com/go-tour/orm/v16/internal/errs"
+	"github.com/go-tour/orm/v16/internal/valuer"
 	"github.com/stretchr/testify/assert"
 	"testing"
 )
@@ -751,6 +751,95 @@ func TestSelector_Get(t *testing.T) {
 	}
 }
 
+func TestSelector_GetMulti(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = mockDB.Close() }()
+	db, err := OpenDB(mockDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name     string
+		query    string
+		mockErr  error
+		mockRows *sqlmock.Rows
+		wantErr  error
+		wantVal  []*TestModel
+	}{
+		{
+			name:    "query error",
+			mockErr: errors.New("invalid query"),
+			wantErr: errors.New("invalid query"),
+			query:   "SELECT .*",
+		},
+		{
+			// 没有数据不是错误
+			name:     "no row",
+			query:    "SELECT .*",
+			mockRows: sqlmock.NewRows([]string{"id"}),
+			wantVal:  []*TestModel{},
+		},
+		{
+			name:    "too many column",
+			wantErr: errs.ErrTooManyReturnedColumns,
+			query:   "SELECT .*",
+			mockRows: func() *sqlmock.Rows {
+				res := sqlmock.NewRows([]string{"id", "first_name", "age", "last_name", "extra_column"})
+				res.AddRow([]byte("1"), []byte("Da"), []byte("18"), []byte("Ming"), []byte("nothing"))
+				return res
+			}(),
+		},
+		{
+			name:  "get data",
+			query: "SELECT .*",
+			mockRows: func() *sqlmock.Rows {
+				res := sqlmock.NewRows([]string{"id", "first_name", "age", "last_name"})
+				res.AddRow([]byte("1"), []byte("Da"), []byte("18"), []byte("Ming"))
+				res.AddRow([]byte("2"), []byte("Xiao"), []byte("16"), []byte("Hong"))
+				return res
+			}(),
+			wantVal: []*TestModel{
+				{
+					Id:        1,
+					FirstName: "Da",
+					Age:       18,
+					LastName:  &sql.NullString{String: "Ming", Valid: true},
+				},
+				{
+					Id:        2,
+					FirstName: "Xiao",
+					Age:       16,
+					LastName:  &sql.NullString{String: "Hong", Valid: true},
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		exp := mock.ExpectQuery(tc.query)
+		if tc.mockErr != nil {
+			exp.WillReturnError(tc.mockErr)
+		} else {
+			exp.WillReturnRows(tc.mockRows)
+		}
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := NewSelector[TestModel](db).GetMulti(context.Background())
+			assert.Equal(t, tc.wantErr, err)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, tc.wantVal, res)
+		})
+	}
+}
+
 // 在 orm 目录下执行
 // go test -bench=BenchmarkQuerier_Get -benchmem -benchtime=10000x
 // 我的输出结果
@@ -2,7 +2,7 @@ package v16
 
 import (
 	"context"
-	"github.com/go-tour/orm/internal/errs"
+	"github.com/go-tour/orm/v16/internal/errs"
 )
 
 type Updater[T any] struct {
@@ -0,0 +1,114 @@
+package v9
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// WrapHandler 将 http.Handler 转换为 HandleFunc，例如 pprof 的 handler
+// handler 写入的响应会被缓存到 RespStatusCode 和 RespData 里面，
+// 所以后面的 Middleware 依旧可以修改响应。代价是不支持流式的响应
+func WrapHandler(h http.Handler) HandleFunc {
+	return func(ctx *Context) {
+		buf := newResponseBuffer(ctx.Resp)
+		h.ServeHTTP(buf, ctx.Req)
+		buf.flushTo(ctx)
+	}
+}
+
+// WrapMiddleware 将 net/http 风格的 Middleware 转换为 Middleware
+//  1. mdl 修改过的 *http.Request 会被设置到 ctx.Req 上，例如往 context 里面放了数据
+//  2. mdl 包装过的 http.ResponseWriter 会被设置到 ctx.Resp 上，
+//     后续的 handler 设置的 RespData 也会写入这个 ResponseWriter，例如压缩
+//  3. mdl 直接写入的响应，例如鉴权失败返回 401，同样会被缓存到 RespStatusCode 和 RespData 里面
+func WrapMiddleware(mdl func(http.Handler) http.Handler) Middleware {
+	return func(next HandleFunc) HandleFunc {
+		return func(ctx *Context) {
+			resp := ctx.Resp
+			buf := newResponseBuffer(resp)
+			h := mdl(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ctx.Req = r
+				ctx.Resp = w
+				next(ctx)
+				// 让 mdl 看到后续 handler 的响应
+				if ctx.RespStatusCode > 0 {
+					w.WriteHeader(ctx.RespStatusCode)
+				}
+				if len(ctx.RespData) > 0 {
+					_, _ = w.Write(ctx.RespData)
+				}
+				ctx.RespStatusCode = 0
+				ctx.RespData = nil
+			}))
+			h.ServeHTTP(buf, ctx.Req)
+			ctx.Resp = resp
+			buf.flushTo(ctx)
+		}
+	}
+}
+
+// SubTree 将前缀 prefix 下面的路由暴露为 http.Handler，用于挂载到别的 http.ServeMux 上面。
+// handler 收到的路径是相对于挂载点的，匹配之前会加上 prefix，
+// 所以配合 http.StripPrefix 可以挂载在任意的路径下面，例如：
+//
+//	mux.Handle("/v2/", http.StripPrefix("/v2", s.SubTree("/api")))
+//
+// 那么 /v2/users 会命中 /api/users，并且 prefix 以外的路由都是访问不到的
+func (s *HTTPServer) SubTree(prefix string) http.Handler {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = prefix + path
+		if r.URL.RawPath != "" {
+			r2.URL.RawPath = prefix + r.URL.RawPath
+		}
+		s.ServeHTTP(w, r2)
+	})
+}
+
+// responseBuffer 缓存写入的响应，响应头直接使用原本的
+type responseBuffer struct {
+	header     http.Header
+	statusCode int
+	data       bytes.Buffer
+}
+
+func newResponseBuffer(w http.ResponseWriter) *responseBuffer {
+	return &responseBuffer{header: w.Header()}
+}
+
+func (r *responseBuffer) Header() http.Header {
+	return r.header
+}
+
+func (r *responseBuffer) Write(data []byte) (int, error) {
+	if r.statusCode == 0 {
+		r.statusCode = http.StatusOK
+	}
+	return r.data.Write(data)
+}
+
+func (r *responseBuffer) WriteHeader(statusCode int) {
+	// 和 net/http 一样，只有第一次生效
+	if r.statusCode == 0 {
+		r.statusCode = statusCode
+	}
+}
+
+// flushTo 将缓存的响应设置到 ctx 上，没有写入任何东西的时候保持 ctx 不变
+func (r *responseBuffer) flushTo(ctx *Context) {
+	if r.statusCode == 0 {
+		return
+	}
+	ctx.RespStatusCode = r.statusCode
+	ctx.RespData = r.data.Bytes()
+}
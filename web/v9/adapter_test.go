@@ -0,0 +1,244 @@
+package v9
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/pprof"
+	"testing"
+)
+
+func TestWrapHandler(t *testing.T) {
+	s := NewHTTPServer()
+	// 后面的 Middleware 依旧可以修改 handler 写入的响应
+	s.Use(func(next HandleFunc) HandleFunc {
+		return func(ctx *Context) {
+			next(ctx)
+			if ctx.RespStatusCode == http.StatusTeapot {
+				ctx.RespData = append(ctx.RespData, []byte(" (modified)")...)
+			}
+		}
+	})
+	s.Get("/teapot", WrapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Handler", "net/http")
+		w.WriteHeader(http.StatusTeapot)
+		// 第二次不生效
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte("I'm a teapot"))
+	})))
+	s.Get("/empty", WrapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	s.Get("/implicit", WrapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})))
+	s.Get("/debug/pprof/cmdline", WrapHandler(http.HandlerFunc(pprof.Cmdline)))
+
+	testCases := []struct {
+		name       string
+		path       string
+		wantCode   int
+		wantBody   string
+		wantHeader string
+	}{
+		{
+			name:       "status and header",
+			path:       "/teapot",
+			wantCode:   http.StatusTeapot,
+			wantBody:   "I'm a teapot (modified)",
+			wantHeader: "net/http",
+		},
+		{
+			name:     "empty",
+			path:     "/empty",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "implicit status",
+			path:     "/implicit",
+			wantCode: http.StatusOK,
+			wantBody: "ok",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, tc.path, nil))
+			assert.Equal(t, tc.wantCode, recorder.Code)
+			assert.Equal(t, tc.wantBody, recorder.Body.String())
+			assert.Equal(t, tc.wantHeader, recorder.Header().Get("X-Handler"))
+		})
+	}
+
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug/pprof/cmdline", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.NotEmpty(t, recorder.Body.String())
+}
+
+type userCtxKey struct{}
+
+// auth 模拟 net/http 风格的鉴权 Middleware
+func auth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := r.Header.Get("X-User")
+		if user == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte("unauthorized"))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userCtxKey{}, user)))
+	})
+}
+
+// upper 模拟包装了 ResponseWriter 的 Middleware，例如压缩
+func upper(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Upper", "true")
+		next.ServeHTTP(&upperWriter{ResponseWriter: w}, r)
+	})
+}
+
+type upperWriter struct {
+	http.ResponseWriter
+}
+
+func (u *upperWriter) Write(data []byte) (int, error) {
+	return u.ResponseWriter.Write(bytes.ToUpper(data))
+}
+
+func TestWrapMiddleware(t *testing.T) {
+	s := NewHTTPServer()
+	var seen []byte
+	s.Use(func(next HandleFunc) HandleFunc {
+		return func(ctx *Context) {
+			next(ctx)
+			// 外面的 Middleware 看到的依旧是缓存的响应
+			seen = ctx.RespData
+		}
+	}, WrapMiddleware(auth), WrapMiddleware(upper))
+	s.Get("/profile", func(ctx *Context) {
+		user, _ := ctx.Req.Context().Value(userCtxKey{}).(string)
+		ctx.RespStatusCode = http.StatusOK
+		ctx.RespData = []byte("hello, " + user)
+	})
+	s.Get("/direct", func(ctx *Context) {
+		// 直接写入 Resp 也会经过 upper
+		ctx.Resp.WriteHeader(http.StatusAccepted)
+		_, _ = ctx.Resp.Write([]byte("direct"))
+	})
+
+	testCases := []struct {
+		name      string
+		path      string
+		user      string
+		wantCode  int
+		wantBody  string
+		wantUpper string
+	}{
+		{
+			name:      "pass",
+			path:      "/profile",
+			user:      "tom",
+			wantCode:  http.StatusOK,
+			wantBody:  "HELLO, TOM",
+			wantUpper: "true",
+		},
+		{
+			name:     "unauthorized",
+			path:     "/profile",
+			wantCode: http.StatusUnauthorized,
+			wantBody: "unauthorized",
+		},
+		{
+			name:      "direct",
+			path:      "/direct",
+			user:      "tom",
+			wantCode:  http.StatusAccepted,
+			wantBody:  "DIRECT",
+			wantUpper: "true",
+		},
+		{
+			name:      "not found",
+			path:      "/missing",
+			user:      "tom",
+			wantCode:  http.StatusNotFound,
+			wantUpper: "true",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			seen = nil
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.user != "" {
+				req.Header.Set("X-User", tc.user)
+			}
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, req)
+			assert.Equal(t, tc.wantCode, recorder.Code)
+			assert.Equal(t, tc.wantBody, recorder.Body.String())
+			assert.Equal(t, tc.wantBody, string(seen))
+			assert.Equal(t, tc.wantUpper, recorder.Header().Get("X-Upper"))
+		})
+	}
+}
+
+func TestHTTPServer_SubTree(t *testing.T) {
+	s := NewHTTPServer()
+	s.Get("/api", func(ctx *Context) {
+		ctx.RespData = []byte("api")
+	})
+	s.Get("/api/users", func(ctx *Context) {
+		ctx.RespData = []byte("users")
+	})
+	s.Get("/admin", func(ctx *Context) {
+		ctx.RespData = []byte("admin")
+	})
+
+	mux := http.NewServeMux()
+	// 挂载在同样的路径下面
+	mux.Handle("/api/", http.StripPrefix("/api", s.SubTree("/api/")))
+	// 挂载在别的路径下面
+	v2 := http.StripPrefix("/v2", s.SubTree("/api"))
+	mux.Handle("/v2", v2)
+	mux.Handle("/v2/", v2)
+	mux.HandleFunc("/legacy", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("legacy"))
+	})
+
+	testCases := []struct {
+		path     string
+		wantCode int
+		wantBody string
+	}{
+		{path: "/api/", wantCode: http.StatusOK, wantBody: "api"},
+		{path: "/api/users", wantCode: http.StatusOK, wantBody: "users"},
+		{path: "/api/missing", wantCode: http.StatusNotFound},
+		{path: "/v2", wantCode: http.StatusOK, wantBody: "api"},
+		{path: "/v2/users", wantCode: http.StatusOK, wantBody: "users"},
+		// /api 以外的路由是访问不到的
+		{path: "/v2/admin", wantCode: http.StatusNotFound},
+		{path: "/admin", wantCode: http.StatusNotFound, wantBody: "404 page not found\n"},
+		{path: "/legacy", wantCode: http.StatusOK, wantBody: "legacy"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, tc.path, nil))
+			assert.Equal(t, tc.wantCode, recorder.Code)
+			assert.Equal(t, tc.wantBody, recorder.Body.String())
+		})
+	}
+
+	// 整个服务器
+	server := httptest.NewServer(s.SubTree(""))
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/admin")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "admin", string(body))
+}
@@ -0,0 +1,282 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	web "github.com/go-tour/web/v9"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Checker 检查某个依赖是否可用，例如数据库，返回 error 代表不可用
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+type CheckerFunc func(ctx context.Context) error
+
+func (f CheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+// Pinger *sql.DB 和 orm 的 *DB 都实现了这个接口
+type Pinger interface {
+	PingContext(ctx context.Context) error
+}
+
+// PingChecker 通过 PingContext 检查数据库连接，例如：
+//
+//	db, _ := orm.Open("mysql", dsn)
+//	admin.New(auth, admin.WithReadinessChecker("db", admin.PingChecker(db)))
+func PingChecker(p Pinger) Checker {
+	return CheckerFunc(p.PingContext)
+}
+
+type Option func(a *Admin)
+
+// Admin 运维相关的路由，所有的路由都需要经过鉴权：
+//  1. prefix/healthz 存活检查，只要进程还能处理请求就返回 200，
+//     除非注册了 liveness 的 Checker
+//  2. prefix/readyz 就绪检查，所有的 Checker 都通过才返回 200，否则返回 503
+//  3. prefix/debug/pprof/ net/http/pprof 的路由
+//  4. prefix/metrics prometheus 的指标
+//
+// 可以通过 Register 挂载到业务的 HTTPServer 上面，
+// 也可以通过 Server 创建一个独立的 HTTPServer，监听在另外一个端口上
+type Admin struct {
+	prefix       string
+	auth         web.Middleware
+	publicProbes bool
+	liveness     map[string]Checker
+	readiness    map[string]Checker
+	checkTimeout time.Duration
+	pprof        bool
+	metrics      http.Handler
+}
+
+// New auth 是鉴权的 Middleware，不能为 nil，例如 BasicAuth
+func New(auth web.Middleware, opts ...Option) *Admin {
+	if auth == nil {
+		panic("admin: 必须提供鉴权的 Middleware")
+	}
+	res := &Admin{
+		auth:         auth,
+		liveness:     make(map[string]Checker, 2),
+		readiness:    make(map[string]Checker, 4),
+		checkTimeout: 5 * time.Second,
+		pprof:        true,
+		metrics:      promhttp.Handler(),
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res
+}
+
+// WithPrefix 所有路由的前缀，例如 /admin，默认没有前缀
+func WithPrefix(prefix string) Option {
+	return func(a *Admin) {
+		a.prefix = strings.TrimSuffix(prefix, "/")
+	}
+}
+
+// WithPublicProbes healthz 和 readyz 不需要鉴权，
+// 例如 kubernetes 的探针没办法带上凭证
+func WithPublicProbes() Option {
+	return func(a *Admin) {
+		a.publicProbes = true
+	}
+}
+
+// WithLivenessChecker 注册 healthz 的检查，
+// 只应该检查进程自身的状态，依赖不可用的时候重启进程是没有用的
+func WithLivenessChecker(name string, c Checker) Option {
+	return func(a *Admin) {
+		a.liveness[name] = c
+	}
+}
+
+// WithReadinessChecker 注册 readyz 的检查，例如 PingChecker
+func WithReadinessChecker(name string, c Checker) Option {
+	return func(a *Admin) {
+		a.readiness[name] = c
+	}
+}
+
+// WithCheckTimeout 每一次检查的超时时间，默认是 5 秒
+func WithCheckTimeout(timeout time.Duration) Option {
+	return func(a *Admin) {
+		a.checkTimeout = timeout
+	}
+}
+
+// WithoutPprof 不注册 pprof 的路由
+func WithoutPprof() Option {
+	return func(a *Admin) {
+		a.pprof = false
+	}
+}
+
+// WithMetricsHandler 替换默认的 promhttp.Handler()，例如使用自己的 Registry
+// 传入 nil 就不注册 metrics 的路由
+func WithMetricsHandler(h http.Handler) Option {
+	return func(a *Admin) {
+		a.metrics = h
+	}
+}
+
+// Register 将路由注册到 s 上面
+func (a *Admin) Register(s *web.HTTPServer) {
+	probe := a.auth
+	if a.publicProbes {
+		probe = func(next web.HandleFunc) web.HandleFunc { return next }
+	}
+	s.Get(a.prefix+"/healthz", probe(a.check(a.liveness)))
+	s.Get(a.prefix+"/readyz", probe(a.check(a.readiness)))
+
+	if a.metrics != nil {
+		s.Get(a.prefix+"/metrics", a.auth(web.WrapHandler(a.metrics)))
+	}
+
+	if a.pprof {
+		index := a.auth(a.pprofIndex)
+		profile := a.auth(pprofProfile)
+		s.Get(a.prefix+"/debug/pprof", index)
+		s.Get(a.prefix+"/debug/pprof/:name", profile)
+		// symbol 支持 POST
+		s.Post(a.prefix+"/debug/pprof/:name", profile)
+	}
+}
+
+// Server 创建一个只有这些路由的 HTTPServer，用于监听在单独的端口上，例如：
+//
+//	go admin.New(auth).Server().Start(":9090")
+func (a *Admin) Server(opts ...web.ServerOption) *web.HTTPServer {
+	s := web.NewHTTPServer(opts...)
+	a.Register(s)
+	return s
+}
+
+type checkResult struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// check 并发执行所有的 Checker
+func (a *Admin) check(checkers map[string]Checker) web.HandleFunc {
+	return func(ctx *web.Context) {
+		res := checkResult{Status: "ok"}
+		if len(checkers) > 0 {
+			res.Checks = make(map[string]string, len(checkers))
+		}
+		var (
+			wg    sync.WaitGroup
+			mutex sync.Mutex
+		)
+		for name, c := range checkers {
+			wg.Add(1)
+			go func(name string, c Checker) {
+				defer wg.Done()
+				checkCtx, cancel := context.WithTimeout(ctx.Req.Context(), a.checkTimeout)
+				defer cancel()
+				msg := "ok"
+				if err := c.Check(checkCtx); err != nil {
+					msg = err.Error()
+				}
+				mutex.Lock()
+				res.Checks[name] = msg
+				mutex.Unlock()
+			}(name, c)
+		}
+		wg.Wait()
+
+		code := http.StatusOK
+		for _, msg := range res.Checks {
+			if msg != "ok" {
+				res.Status = "unavailable"
+				code = http.StatusServiceUnavailable
+				break
+			}
+		}
+		// 探针不应该被缓存
+		ctx.Resp.Header().Set("Cache-Control", "no-store")
+		ctx.Resp.Header().Set("Content-Type", "application/json")
+		if err := ctx.RespJSON(code, res); err != nil {
+			ctx.RespStatusCode = http.StatusInternalServerError
+		}
+	}
+}
+
+// pprofIndex pprof 首页里面的链接都是相对路径，所以必须以 / 结尾
+func (a *Admin) pprofIndex(ctx *web.Context) {
+	if !strings.HasSuffix(ctx.Req.URL.Path, "/") {
+		ctx.Resp.Header().Set("Location", ctx.Req.URL.Path+"/")
+		ctx.RespStatusCode = http.StatusMovedPermanently
+		return
+	}
+	web.WrapHandler(http.HandlerFunc(pprof.Index))(ctx)
+}
+
+func pprofProfile(ctx *web.Context) {
+	name, _ := ctx.PathValue("name").String()
+	var h http.Handler
+	switch name {
+	case "cmdline":
+		h = http.HandlerFunc(pprof.Cmdline)
+	case "profile":
+		h = http.HandlerFunc(pprof.Profile)
+	case "symbol":
+		h = http.HandlerFunc(pprof.Symbol)
+	case "trace":
+		h = http.HandlerFunc(pprof.Trace)
+	default:
+		// pprof.Index 按照固定的 /debug/pprof/ 前缀解析名字，
+		// 挂载在别的前缀下面的时候会失效，所以直接使用 pprof.Handler
+		h = pprof.Handler(name)
+	}
+	web.WrapHandler(h)(ctx)
+}
+
+// BasicAuth 使用 HTTP Basic 认证的 Middleware，
+// accounts 是用户名到密码的映射
+func BasicAuth(realm string, accounts map[string]string) web.Middleware {
+	return func(next web.HandleFunc) web.HandleFunc {
+		return func(ctx *web.Context) {
+			user, password, ok := ctx.Req.BasicAuth()
+			if ok {
+				expected, found := accounts[user]
+				// 用户不存在的时候也比较一次，避免通过时间差猜测用户名
+				if !found {
+					expected = password + "\x00"
+				}
+				if subtle.ConstantTimeCompare([]byte(password), []byte(expected)) == 1 {
+					next(ctx)
+					return
+				}
+			}
+			ctx.Resp.Header().Set("WWW-Authenticate", `Basic realm="`+realm+`", charset="UTF-8"`)
+			ctx.RespStatusCode = http.StatusUnauthorized
+			ctx.RespData = []byte(http.StatusText(http.StatusUnauthorized))
+		}
+	}
+}
+
+// TokenAuth 校验 Authorization: Bearer <token>，适合 prometheus 之类的机器调用
+func TokenAuth(token string) web.Middleware {
+	return func(next web.HandleFunc) web.HandleFunc {
+		return func(ctx *web.Context) {
+			got := ctx.Req.Header.Get("Authorization")
+			if strings.HasPrefix(got, "Bearer ") &&
+				subtle.ConstantTimeCompare([]byte(got[len("Bearer "):]), []byte(token)) == 1 {
+				next(ctx)
+				return
+			}
+			ctx.RespStatusCode = http.StatusUnauthorized
+			ctx.RespData = []byte(http.StatusText(http.StatusUnauthorized))
+		}
+	}
+}
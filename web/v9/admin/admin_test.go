@@ -0,0 +1,279 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	orm "github.com/go-tour/orm/v16"
+	web "github.com/go-tour/web/v9"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAdmin(t *testing.T) {
+	db, err := orm.Open("sqlite3", "file:admin_test.db?cache=shared&mode=memory")
+	require.NoError(t, err)
+	closedDB, err := orm.Open("sqlite3", "file:admin_closed.db?cache=shared&mode=memory")
+	require.NoError(t, err)
+	require.NoError(t, closedDB.Close())
+
+	reg := prometheus.NewRegistry()
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "admin_test_total"})
+	reg.MustRegister(counter)
+	counter.Inc()
+
+	auth := BasicAuth("admin", map[string]string{"root": "123456"})
+	slow := CheckerFunc(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	testCases := []struct {
+		name   string
+		admin  *Admin
+		method string
+		path   string
+		noAuth bool
+
+		wantCode     int
+		wantBody     string
+		wantContains string
+		wantHeader   http.Header
+	}{
+		{
+			name:     "healthz",
+			admin:    New(auth),
+			path:     "/healthz",
+			wantCode: http.StatusOK,
+			wantBody: `{"status":"ok"}`,
+		},
+		{
+			name:     "unauthorized",
+			admin:    New(auth),
+			path:     "/healthz",
+			noAuth:   true,
+			wantCode: http.StatusUnauthorized,
+			wantHeader: http.Header{
+				"Www-Authenticate": {`Basic realm="admin", charset="UTF-8"`},
+			},
+		},
+		{
+			name:     "public probes",
+			admin:    New(auth, WithPublicProbes()),
+			path:     "/readyz",
+			noAuth:   true,
+			wantCode: http.StatusOK,
+			wantBody: `{"status":"ok"}`,
+		},
+		{
+			name:     "public probes metrics",
+			admin:    New(auth, WithPublicProbes()),
+			path:     "/metrics",
+			noAuth:   true,
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "readyz db",
+			admin:    New(auth, WithReadinessChecker("db", PingChecker(db))),
+			path:     "/readyz",
+			wantCode: http.StatusOK,
+			wantBody: `{"status":"ok","checks":{"db":"ok"}}`,
+		},
+		{
+			name: "readyz db closed",
+			admin: New(auth,
+				WithReadinessChecker("db", PingChecker(db)),
+				WithReadinessChecker("closed", PingChecker(closedDB))),
+			path:     "/readyz",
+			wantCode: http.StatusServiceUnavailable,
+			wantBody: `{"status":"unavailable","checks":{"closed":"sql: database is closed","db":"ok"}}`,
+		},
+		{
+			name: "readyz timeout",
+			admin: New(auth, WithReadinessChecker("slow", slow),
+				WithCheckTimeout(time.Millisecond)),
+			path:     "/readyz",
+			wantCode: http.StatusServiceUnavailable,
+			wantBody: `{"status":"unavailable","checks":{"slow":"context deadline exceeded"}}`,
+		},
+		{
+			name: "healthz liveness",
+			admin: New(auth, WithLivenessChecker("deadlock", CheckerFunc(func(ctx context.Context) error {
+				return errors.New("deadlock")
+			}))),
+			path:     "/healthz",
+			wantCode: http.StatusServiceUnavailable,
+			wantBody: `{"status":"unavailable","checks":{"deadlock":"deadlock"}}`,
+		},
+		{
+			name:     "prefix",
+			admin:    New(auth, WithPrefix("/admin/")),
+			path:     "/admin/healthz",
+			wantCode: http.StatusOK,
+			wantBody: `{"status":"ok"}`,
+		},
+		{
+			name:         "metrics",
+			admin:        New(auth, WithMetricsHandler(promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))),
+			path:         "/metrics",
+			wantCode:     http.StatusOK,
+			wantContains: "admin_test_total 1",
+		},
+		{
+			name:     "without metrics",
+			admin:    New(auth, WithMetricsHandler(nil)),
+			path:     "/metrics",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "pprof redirect",
+			admin:    New(auth, WithPrefix("/admin")),
+			path:     "/admin/debug/pprof",
+			wantCode: http.StatusMovedPermanently,
+			wantHeader: http.Header{
+				"Location": {"/admin/debug/pprof/"},
+			},
+		},
+		{
+			name:         "pprof index",
+			admin:        New(auth, WithPrefix("/admin")),
+			path:         "/admin/debug/pprof/",
+			wantCode:     http.StatusOK,
+			wantContains: "goroutine?debug=1",
+		},
+		{
+			name:         "pprof profile",
+			admin:        New(auth, WithPrefix("/admin")),
+			path:         "/admin/debug/pprof/goroutine?debug=1",
+			wantCode:     http.StatusOK,
+			wantContains: "goroutine profile:",
+		},
+		{
+			name:         "pprof symbol post",
+			admin:        New(auth),
+			method:       http.MethodPost,
+			path:         "/debug/pprof/symbol",
+			wantCode:     http.StatusOK,
+			wantContains: "num_symbols",
+		},
+		{
+			name:     "pprof unauthorized",
+			admin:    New(auth),
+			path:     "/debug/pprof/cmdline",
+			noAuth:   true,
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "without pprof",
+			admin:    New(auth, WithoutPprof()),
+			path:     "/debug/pprof/cmdline",
+			wantCode: http.StatusNotFound,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			method := tc.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, tc.path, nil)
+			if !tc.noAuth {
+				req.SetBasicAuth("root", "123456")
+			}
+			recorder := httptest.NewRecorder()
+			tc.admin.Server().ServeHTTP(recorder, req)
+			assert.Equal(t, tc.wantCode, recorder.Code)
+			if tc.wantBody != "" {
+				assert.Equal(t, tc.wantBody, recorder.Body.String())
+			}
+			assert.Contains(t, recorder.Body.String(), tc.wantContains)
+			for key, val := range tc.wantHeader {
+				assert.Equal(t, val, recorder.Header()[key])
+			}
+		})
+	}
+}
+
+func TestAdmin_Register(t *testing.T) {
+	s := web.NewHTTPServer()
+	s.Get("/user", func(ctx *web.Context) {
+		ctx.RespStatusCode = http.StatusOK
+	})
+	New(TokenAuth("secret"), WithPrefix("/admin")).Register(s)
+
+	testCases := []struct {
+		name  string
+		path  string
+		token string
+
+		wantCode int
+	}{
+		{
+			name:     "business route",
+			path:     "/user",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "admin route",
+			path:     "/admin/healthz",
+			token:    "Bearer secret",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "wrong token",
+			path:     "/admin/healthz",
+			token:    "Bearer guess",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "not bearer",
+			path:     "/admin/healthz",
+			token:    "secret",
+			wantCode: http.StatusUnauthorized,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.token != "" {
+				req.Header.Set("Authorization", tc.token)
+			}
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, req)
+			assert.Equal(t, tc.wantCode, recorder.Code)
+		})
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	mdl := BasicAuth("admin", map[string]string{"root": "123456"})
+	testCases := []struct {
+		name     string
+		user     string
+		password string
+
+		wantCode int
+	}{
+		{name: "ok", user: "root", password: "123456", wantCode: http.StatusOK},
+		{name: "wrong password", user: "root", password: "654321", wantCode: http.StatusUnauthorized},
+		{name: "unknown user", user: "guest", password: "123456", wantCode: http.StatusUnauthorized},
+		{name: "empty password", user: "guest", password: "", wantCode: http.StatusUnauthorized},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.SetBasicAuth(tc.user, tc.password)
+			ctx := &web.Context{Req: req, Resp: httptest.NewRecorder()}
+			mdl(func(ctx *web.Context) {
+				ctx.RespStatusCode = http.StatusOK
+			})(ctx)
+			assert.Equal(t, tc.wantCode, ctx.RespStatusCode)
+		})
+	}
+}
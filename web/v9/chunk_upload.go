@@ -0,0 +1,463 @@
+package v9
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultChunkExpiration  = 24 * time.Hour
+	defaultChunkGCInterval  = time.Minute
+	defaultMaxChunkSize     = 8 << 20
+	defaultMaxUploadLength  = 1 << 30
+	defaultMaxUploads       = 1024
+	headerUploadLength      = "Upload-Length"
+	headerUploadOffset      = "Upload-Offset"
+	headerUploadMetadata    = "Upload-Metadata"
+	headerUploadChecksum    = "Upload-Checksum"
+	headerUploadExpires     = "Upload-Expires"
+	chunkUploadTempFileGlob = ".chunk-upload-*"
+)
+
+// StatusChecksumMismatch 分片校验失败，和 tus 保持一致
+const StatusChecksumMismatch = 460
+
+// ChunkUploader 分片上传，支持断点续传。协议参考了 tus，但是做了简化：
+//
+//	POST   /prefix      创建上传。Upload-Length 是文件大小，
+//	                    Upload-Metadata 是 filename <base64 编码的文件名>，
+//	                    返回 201，Location 是后续操作的地址
+//	HEAD   /prefix/:id  查询已经上传了多少，返回在 Upload-Offset 里面
+//	PATCH  /prefix/:id  上传一个分片。Upload-Offset 必须等于已经上传的大小，否则返回 409；
+//	                    Upload-Checksum 是可选的 sha256 <base64 编码的摘要>，校验失败返回 460，
+//	                    并且这个分片会被丢弃
+//	POST   /prefix/:id  完成上传，文件会被交给 FileStorage 保存
+//	DELETE /prefix/:id  放弃上传
+//
+// 上传中的数据保存在本地的临时目录，长时间没有动静的上传会被清理掉
+type ChunkUploader struct {
+	storage    FileStorage
+	tempDir    string
+	expiration time.Duration
+	gcInterval time.Duration
+	maxChunk   int64
+	maxLength  int64
+	maxUploads int
+	onComplete func(ctx *Context, file UploadedFile) error
+	mutex      sync.Mutex
+	uploads    map[string]*chunkUpload
+	closeOnce  sync.Once
+	closeCh    chan struct{}
+}
+
+type ChunkUploaderOption func(c *ChunkUploader)
+
+// chunkUpload 一个进行中的上传
+type chunkUpload struct {
+	mutex    sync.Mutex
+	id       string
+	name     string
+	length   int64
+	offset   int64
+	path     string
+	expireAt time.Time
+	// 已经完成或者放弃了
+	done bool
+}
+
+// NewChunkUploader 创建一个分片上传的 handler，完成之后文件保存在 storage 里面
+// 如果开启了后台清理，那么不再使用的时候需要调用 Close
+func NewChunkUploader(storage FileStorage, opts ...ChunkUploaderOption) *ChunkUploader {
+	res := &ChunkUploader{
+		storage:    storage,
+		tempDir:    os.TempDir(),
+		expiration: defaultChunkExpiration,
+		gcInterval: defaultChunkGCInterval,
+		maxChunk:   defaultMaxChunkSize,
+		maxLength:  defaultMaxUploadLength,
+		maxUploads: defaultMaxUploads,
+		uploads:    make(map[string]*chunkUpload, 16),
+		closeCh:    make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	if res.gcInterval > 0 {
+		go res.gcLoop()
+	}
+	return res
+}
+
+// WithChunkTempDir 上传中的数据保存在哪个目录，默认是 os.TempDir()
+func WithChunkTempDir(dir string) ChunkUploaderOption {
+	return func(c *ChunkUploader) {
+		c.tempDir = dir
+	}
+}
+
+// WithChunkExpiration 上传多久没有动静就会被清理掉，默认是 24 小时
+func WithChunkExpiration(expiration time.Duration) ChunkUploaderOption {
+	return func(c *ChunkUploader) {
+		c.expiration = expiration
+	}
+}
+
+// WithChunkGCInterval 设置清理的间隔，默认是一分钟
+// 小于等于 0 代表不在后台清理，那么需要用户自己定时调用 GC
+func WithChunkGCInterval(interval time.Duration) ChunkUploaderOption {
+	return func(c *ChunkUploader) {
+		c.gcInterval = interval
+	}
+}
+
+// WithMaxChunkSize 单个分片的大小上限，默认是 8MB
+func WithMaxChunkSize(size int64) ChunkUploaderOption {
+	return func(c *ChunkUploader) {
+		c.maxChunk = size
+	}
+}
+
+// WithMaxUploadLength 文件的大小上限，默认是 1GB
+func WithMaxUploadLength(length int64) ChunkUploaderOption {
+	return func(c *ChunkUploader) {
+		c.maxLength = length
+	}
+}
+
+// WithMaxUploads 同时进行中的上传的数量上限，超过之后创建上传返回 429，默认是 1024
+// 小于等于 0 代表不限制
+func WithMaxUploads(n int) ChunkUploaderOption {
+	return func(c *ChunkUploader) {
+		c.maxUploads = n
+	}
+}
+
+// WithUploadCompleteCallback 文件保存之后调用，返回 error 的话响应 500，
+// 这个时候上传依旧保留着，客户端可以重新完成上传
+func WithUploadCompleteCallback(fn func(ctx *Context, file UploadedFile) error) ChunkUploaderOption {
+	return func(c *ChunkUploader) {
+		c.onComplete = fn
+	}
+}
+
+// Register 在 prefix 下面注册所有的路由
+func (c *ChunkUploader) Register(s *HTTPServer, prefix string) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	s.Post(prefix, c.Create)
+	s.Head(prefix+"/:id", c.Offset)
+	s.Patch(prefix+"/:id", c.Patch)
+	s.Post(prefix+"/:id", c.Finalize)
+	s.Delete(prefix+"/:id", c.Abort)
+}
+
+// Create 创建上传
+func (c *ChunkUploader) Create(ctx *Context) {
+	length, err := strconv.ParseInt(ctx.Req.Header.Get(headerUploadLength), 10, 64)
+	if err != nil || length < 0 {
+		respUploadError(ctx, http.StatusBadRequest, "上传失败，非法的 Upload-Length")
+		return
+	}
+	if length > c.maxLength {
+		respUploadError(ctx, http.StatusRequestEntityTooLarge, "上传失败，文件太大")
+		return
+	}
+	id := uuid.New().String()
+	name, err := parseUploadFileName(ctx.Req.Header.Get(headerUploadMetadata))
+	if err != nil {
+		respUploadError(ctx, http.StatusBadRequest, "上传失败，非法的 Upload-Metadata")
+		return
+	}
+	if name == "" {
+		name = id
+	}
+	f, err := os.CreateTemp(c.tempDir, chunkUploadTempFileGlob)
+	if err != nil {
+		respUploadError(ctx, http.StatusInternalServerError, "上传失败")
+		return
+	}
+	_ = f.Close()
+	u := &chunkUpload{
+		id:       id,
+		name:     name,
+		length:   length,
+		path:     f.Name(),
+		expireAt: time.Now().Add(c.expiration),
+	}
+	c.mutex.Lock()
+	if c.maxUploads > 0 && len(c.uploads) >= c.maxUploads {
+		c.mutex.Unlock()
+		_ = os.Remove(u.path)
+		respUploadError(ctx, http.StatusTooManyRequests, "上传失败，进行中的上传太多")
+		return
+	}
+	c.uploads[id] = u
+	c.mutex.Unlock()
+
+	header := ctx.Resp.Header()
+	header.Set("Location", strings.TrimSuffix(ctx.Req.URL.Path, "/")+"/"+id)
+	header.Set(headerUploadOffset, "0")
+	header.Set(headerUploadExpires, u.expireAt.UTC().Format(http.TimeFormat))
+	ctx.RespStatusCode = http.StatusCreated
+}
+
+// Offset 查询已经上传了多少，HEAD 请求没有响应体，所以出错的时候只返回响应码
+func (c *ChunkUploader) Offset(ctx *Context) {
+	u, ok := c.lock(ctx)
+	if !ok {
+		ctx.RespStatusCode = http.StatusNotFound
+		return
+	}
+	defer u.mutex.Unlock()
+	header := ctx.Resp.Header()
+	header.Set(headerUploadOffset, strconv.FormatInt(u.offset, 10))
+	header.Set(headerUploadLength, strconv.FormatInt(u.length, 10))
+	header.Set(headerUploadExpires, u.expireAt.UTC().Format(http.TimeFormat))
+	// 偏移量随时在变，不能被缓存
+	header.Set("Cache-Control", "no-store")
+	ctx.RespStatusCode = http.StatusOK
+}
+
+// Patch 上传一个分片
+func (c *ChunkUploader) Patch(ctx *Context) {
+	u, ok := c.lock(ctx)
+	if !ok {
+		respUploadError(ctx, http.StatusNotFound, "上传不存在")
+		return
+	}
+	defer u.mutex.Unlock()
+	offset, err := strconv.ParseInt(ctx.Req.Header.Get(headerUploadOffset), 10, 64)
+	if err != nil {
+		respUploadError(ctx, http.StatusBadRequest, "上传失败，非法的 Upload-Offset")
+		return
+	}
+	if offset != u.offset {
+		ctx.Resp.Header().Set(headerUploadOffset, strconv.FormatInt(u.offset, 10))
+		respUploadError(ctx, http.StatusConflict, "上传失败，Upload-Offset 不匹配")
+		return
+	}
+	h, expected, err := parseUploadChecksum(ctx.Req.Header.Get(headerUploadChecksum))
+	if err != nil {
+		respUploadError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	limit := u.length - u.offset
+	if limit > c.maxChunk {
+		limit = c.maxChunk
+	}
+	var src io.Reader = http.MaxBytesReader(ctx.Resp, ctx.Req.Body, limit)
+	if h != nil {
+		src = io.TeeReader(src, h)
+	}
+	n, code, err := u.write(src)
+	if err == nil && h != nil && string(h.Sum(nil)) != string(expected) {
+		code, err = StatusChecksumMismatch, errors.New("上传失败，分片校验失败")
+	}
+	if err != nil {
+		// 丢弃这个分片，客户端可以从原来的偏移量重新上传
+		_ = os.Truncate(u.path, u.offset)
+		respUploadError(ctx, code, err.Error())
+		return
+	}
+	u.offset += n
+	u.expireAt = time.Now().Add(c.expiration)
+	header := ctx.Resp.Header()
+	header.Set(headerUploadOffset, strconv.FormatInt(u.offset, 10))
+	header.Set(headerUploadExpires, u.expireAt.UTC().Format(http.TimeFormat))
+	ctx.RespStatusCode = http.StatusNoContent
+}
+
+// Finalize 完成上传，将文件交给 FileStorage 保存
+func (c *ChunkUploader) Finalize(ctx *Context) {
+	u, ok := c.lock(ctx)
+	if !ok {
+		respUploadError(ctx, http.StatusNotFound, "上传不存在")
+		return
+	}
+	defer u.mutex.Unlock()
+	if u.offset != u.length {
+		ctx.Resp.Header().Set(headerUploadOffset, strconv.FormatInt(u.offset, 10))
+		respUploadError(ctx, http.StatusConflict, "上传失败，文件还没有上传完")
+		return
+	}
+	file, err := u.save(ctx.Req.Context(), c.storage)
+	if err != nil {
+		respUploadError(ctx, http.StatusInternalServerError, "上传失败")
+		return
+	}
+	// 回调成功之后才删除上传，失败了客户端还可以重试
+	if c.onComplete != nil {
+		if err = c.onComplete(ctx, file); err != nil {
+			respUploadError(ctx, http.StatusInternalServerError, "上传失败")
+			return
+		}
+	}
+	c.remove(u)
+	respUploadJSON(ctx, http.StatusOK, uploadResult{Files: []UploadedFile{file}})
+}
+
+// Abort 放弃上传
+func (c *ChunkUploader) Abort(ctx *Context) {
+	u, ok := c.lock(ctx)
+	if !ok {
+		respUploadError(ctx, http.StatusNotFound, "上传不存在")
+		return
+	}
+	defer u.mutex.Unlock()
+	c.remove(u)
+	ctx.RespStatusCode = http.StatusNoContent
+}
+
+// GC 清理所有过期的上传
+func (c *ChunkUploader) GC() {
+	now := time.Now()
+	c.mutex.Lock()
+	uploads := make([]*chunkUpload, 0, len(c.uploads))
+	for _, u := range c.uploads {
+		uploads = append(uploads, u)
+	}
+	c.mutex.Unlock()
+	for _, u := range uploads {
+		u.mutex.Lock()
+		if !u.done && u.expireAt.Before(now) {
+			c.remove(u)
+		}
+		u.mutex.Unlock()
+	}
+}
+
+// Close 停止后台清理
+func (c *ChunkUploader) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closeCh)
+	})
+	return nil
+}
+
+func (c *ChunkUploader) gcLoop() {
+	ticker := time.NewTicker(c.gcInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.GC()
+		case <-c.closeCh:
+			return
+		}
+	}
+}
+
+// lock 找到对应的上传并且加锁，返回 true 的时候调用者需要解锁
+func (c *ChunkUploader) lock(ctx *Context) (*chunkUpload, bool) {
+	id, err := ctx.PathValue("id").String()
+	if err != nil {
+		return nil, false
+	}
+	c.mutex.Lock()
+	u, ok := c.uploads[id]
+	c.mutex.Unlock()
+	if !ok {
+		return nil, false
+	}
+	u.mutex.Lock()
+	// 在等锁的时候被别人处理掉了，或者过期了但是还没有被清理
+	if u.done || u.expireAt.Before(time.Now()) {
+		u.mutex.Unlock()
+		return nil, false
+	}
+	return u, true
+}
+
+// remove 调用者需要持有 u 的锁
+func (c *ChunkUploader) remove(u *chunkUpload) {
+	u.done = true
+	_ = os.Remove(u.path)
+	c.mutex.Lock()
+	delete(c.uploads, u.id)
+	c.mutex.Unlock()
+}
+
+// write 将分片追加到临时文件的末尾
+func (u *chunkUpload) write(src io.Reader) (int64, int, error) {
+	f, err := os.OpenFile(u.path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return 0, http.StatusInternalServerError, errors.New("上传失败")
+	}
+	n, err := io.Copy(f, src)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return 0, http.StatusRequestEntityTooLarge, errors.New("上传失败，分片太大")
+		}
+		return 0, http.StatusInternalServerError, errors.New("上传失败")
+	}
+	return n, 0, nil
+}
+
+func (u *chunkUpload) save(ctx context.Context, storage FileStorage) (UploadedFile, error) {
+	f, err := os.Open(u.path)
+	if err != nil {
+		return UploadedFile{}, err
+	}
+	defer f.Close()
+	_, contentType, err := sniffContentType(f)
+	if err != nil {
+		return UploadedFile{}, err
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return UploadedFile{}, err
+	}
+	size, err := storage.Save(ctx, u.name, f)
+	if err != nil {
+		return UploadedFile{}, err
+	}
+	return UploadedFile{Name: u.name, Size: size, ContentType: contentType}, nil
+}
+
+// parseUploadFileName 从 Upload-Metadata 里面解析出文件名
+// 格式是逗号分隔的 key <base64 编码的 value>，我们只关心 filename
+func parseUploadFileName(metadata string) (string, error) {
+	for _, pair := range strings.Split(metadata, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key != "filename" {
+			continue
+		}
+		name, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return "", err
+		}
+		return sanitizeFileName(string(name)), nil
+	}
+	return "", nil
+}
+
+// parseUploadChecksum 解析 Upload-Checksum，没有的话返回 nil
+func parseUploadChecksum(checksum string) (hash.Hash, []byte, error) {
+	if checksum == "" {
+		return nil, nil, nil
+	}
+	algo, value, _ := strings.Cut(checksum, " ")
+	if algo != "sha256" {
+		return nil, nil, fmt.Errorf("上传失败，不支持的校验算法 %s", algo)
+	}
+	expected, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, nil, errors.New("上传失败，非法的 Upload-Checksum")
+	}
+	return sha256.New(), expected, nil
+}
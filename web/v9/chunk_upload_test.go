@@ -0,0 +1,274 @@
+package v9
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestChunkUploader(t *testing.T) {
+	storage := NewMemoryFileStorage()
+	tempDir := t.TempDir()
+	var completed []UploadedFile
+	c := NewChunkUploader(storage,
+		WithChunkTempDir(tempDir),
+		WithChunkGCInterval(0),
+		WithMaxChunkSize(100),
+		WithMaxUploadLength(1000),
+		WithUploadCompleteCallback(func(ctx *Context, file UploadedFile) error {
+			completed = append(completed, file)
+			return nil
+		}))
+	defer c.Close()
+	s := NewHTTPServer()
+	c.Register(s, "/uploads")
+
+	data := append([]byte("%PDF-1.4\n"), bytes.Repeat([]byte("a"), 241)...)
+
+	// 创建上传
+	req := httptest.NewRequest(http.MethodPost, "/uploads", nil)
+	req.Header.Set("Upload-Length", strconv.Itoa(len(data)))
+	req.Header.Set("Upload-Metadata", "filename "+base64.StdEncoding.EncodeToString([]byte("../report.pdf")))
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusCreated, recorder.Code)
+	location := recorder.Header().Get("Location")
+	require.NotEmpty(t, location)
+	assert.Equal(t, "0", recorder.Header().Get("Upload-Offset"))
+
+	patch := func(offset int, chunk []byte, checksum string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPatch, location, bytes.NewReader(chunk))
+		req.Header.Set("Upload-Offset", strconv.Itoa(offset))
+		if checksum != "" {
+			req.Header.Set("Upload-Checksum", checksum)
+		}
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, req)
+		return recorder
+	}
+	offset := func() string {
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(http.MethodHead, location, nil))
+		require.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, strconv.Itoa(len(data)), recorder.Header().Get("Upload-Length"))
+		return recorder.Header().Get("Upload-Offset")
+	}
+	finalize := func() *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, location, nil))
+		return recorder
+	}
+
+	recorder = patch(0, data[:100], sha256Checksum(data[:100]))
+	require.Equal(t, http.StatusNoContent, recorder.Code)
+	assert.Equal(t, "100", recorder.Header().Get("Upload-Offset"))
+	assert.Equal(t, "100", offset())
+
+	// 偏移量不对
+	recorder = patch(50, data[50:150], "")
+	assert.Equal(t, http.StatusConflict, recorder.Code)
+	assert.Equal(t, "100", recorder.Header().Get("Upload-Offset"))
+
+	// 分片太大
+	recorder = patch(100, data[100:201], "")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, recorder.Code)
+	assert.Equal(t, "100", offset())
+
+	// 校验失败，分片被丢弃
+	recorder = patch(100, data[100:200], sha256Checksum(data[:100]))
+	assert.Equal(t, StatusChecksumMismatch, recorder.Code)
+	assert.Equal(t, "100", offset())
+
+	// 不支持的算法
+	recorder = patch(100, data[100:200], "md5 xxx")
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	// 还没有传完
+	recorder = finalize()
+	assert.Equal(t, http.StatusConflict, recorder.Code)
+
+	// 断点续传
+	require.Equal(t, http.StatusNoContent, patch(100, data[100:200], sha256Checksum(data[100:200])).Code)
+	require.Equal(t, http.StatusNoContent, patch(200, data[200:], "").Code)
+	assert.Equal(t, strconv.Itoa(len(data)), offset())
+
+	recorder = finalize()
+	require.Equal(t, http.StatusOK, recorder.Code)
+	var res uploadResult
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &res))
+	want := UploadedFile{Name: "report.pdf", Size: int64(len(data)), ContentType: "application/pdf"}
+	assert.Equal(t, uploadResult{Files: []UploadedFile{want}}, res)
+	assert.Equal(t, []UploadedFile{want}, completed)
+	saved, ok := storage.Get("report.pdf")
+	require.True(t, ok)
+	assert.Equal(t, data, saved)
+
+	// 完成之后就没有了，临时文件也被删掉了
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodHead, location, nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+	entries, err := os.ReadDir(tempDir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 0)
+}
+
+func TestChunkUploader_Create(t *testing.T) {
+	testCases := []struct {
+		name     string
+		length   string
+		metadata string
+		wantCode int
+	}{
+		{
+			name:     "no length",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "negative length",
+			length:   "-1",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "too large",
+			length:   "1001",
+			wantCode: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:     "invalid metadata",
+			length:   "10",
+			metadata: "filename !!!",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "no metadata",
+			length:   "10",
+			wantCode: http.StatusCreated,
+		},
+	}
+	c := NewChunkUploader(NewMemoryFileStorage(),
+		WithChunkTempDir(t.TempDir()), WithChunkGCInterval(0), WithMaxUploadLength(1000))
+	s := NewHTTPServer()
+	c.Register(s, "/uploads/")
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/uploads", nil)
+			req.Header.Set("Upload-Length", tc.length)
+			req.Header.Set("Upload-Metadata", tc.metadata)
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, req)
+			assert.Equal(t, tc.wantCode, recorder.Code)
+		})
+	}
+}
+
+func TestChunkUploader_Expiration(t *testing.T) {
+	tempDir := t.TempDir()
+	c := NewChunkUploader(NewMemoryFileStorage(), WithChunkTempDir(tempDir),
+		WithChunkExpiration(time.Millisecond*10), WithChunkGCInterval(time.Millisecond*20))
+	defer c.Close()
+	s := NewHTTPServer()
+	c.Register(s, "/uploads")
+
+	create := func() string {
+		req := httptest.NewRequest(http.MethodPost, "/uploads", nil)
+		req.Header.Set("Upload-Length", "10")
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, req)
+		require.Equal(t, http.StatusCreated, recorder.Code)
+		return recorder.Header().Get("Location")
+	}
+	abandoned := create()
+	aborted := create()
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodDelete, aborted, nil))
+	assert.Equal(t, http.StatusNoContent, recorder.Code)
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodHead, aborted, nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+
+	time.Sleep(time.Millisecond * 100)
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodHead, abandoned, nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+	// 临时文件也被清理掉了
+	entries, err := os.ReadDir(tempDir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 0)
+}
+
+func TestChunkUploader_CompleteCallbackError(t *testing.T) {
+	cbErr := errors.New("mock error")
+	c := NewChunkUploader(NewMemoryFileStorage(), WithChunkTempDir(t.TempDir()), WithChunkGCInterval(0),
+		WithUploadCompleteCallback(func(ctx *Context, file UploadedFile) error {
+			return cbErr
+		}))
+	s := NewHTTPServer()
+	c.Register(s, "/uploads")
+
+	req := httptest.NewRequest(http.MethodPost, "/uploads", nil)
+	req.Header.Set("Upload-Length", "0")
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusCreated, recorder.Code)
+	location := recorder.Header().Get("Location")
+
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, location, nil))
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+
+	// 上传依旧保留着，可以重新完成
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodHead, location, nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	cbErr = nil
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, location, nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodHead, location, nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
+
+func TestChunkUploader_MaxUploads(t *testing.T) {
+	tempDir := t.TempDir()
+	c := NewChunkUploader(NewMemoryFileStorage(), WithChunkTempDir(tempDir), WithChunkGCInterval(0),
+		WithMaxUploads(1))
+	s := NewHTTPServer()
+	c.Register(s, "/uploads")
+	create := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/uploads", nil)
+		req.Header.Set("Upload-Length", "10")
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	first := create()
+	require.Equal(t, http.StatusCreated, first.Code)
+	assert.Equal(t, http.StatusTooManyRequests, create().Code)
+	// 被拒绝的上传不会留下临时文件
+	entries, err := os.ReadDir(tempDir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	// 放弃之后就可以创建新的上传了
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodDelete, first.Header().Get("Location"), nil))
+	require.Equal(t, http.StatusNoContent, recorder.Code)
+	assert.Equal(t, http.StatusCreated, create().Code)
+}
+
+func sha256Checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256 " + base64.StdEncoding.EncodeToString(sum[:])
+}
@@ -24,6 +24,12 @@ type Context struct {
 	PathParams map[string]string
 	// 命中的路由
 	MatchedRoute string
+	// 命中的路由对应的 handler，在执行 Middleware 之前就已经确定
+	handler HandleFunc
+	// routedMethod 和 routedPath 是匹配路由的时候请求的方法和路径，
+	// Middleware 修改了它们之后需要重新匹配
+	routedMethod string
+	routedPath   string
 
 	// 缓存的数据
 	cacheQueryValues url.Values
@@ -38,6 +44,13 @@ type Context struct {
 	UserValues map[string]any
 }
 
+// reset 清空 Context，放回池子之前调用。
+// 所有的字段都直接丢弃，而不是复用，
+// 因为用户可能还持有 PathParams 之类的 map
+func (c *Context) reset() {
+	*c = Context{}
+}
+
 func (c *Context) BindJSON(val any) error {
 	if c.Req.Body == nil {
 		return errors.New("web: body 为 nil")
@@ -91,12 +104,51 @@ func (c *Context) RespJSON(code int, val any) error {
 	return err
 }
 
-func (c *Context) Render(tpl string, data any) error {
+// RenderOption 控制 Render 的行为
+type RenderOption func(opts *renderOptions)
+
+type renderOptions struct {
+	statusCode int
+	layout     string
+	// hasLayout 用于区分没有指定布局和指定了不使用布局
+	hasLayout bool
+}
+
+// WithStatusCode 渲染成功之后的响应码，默认是 200
+func WithStatusCode(code int) RenderOption {
+	return func(opts *renderOptions) {
+		opts.statusCode = code
+	}
+}
+
+// WithLayout 覆盖页面自己声明的布局，传入空字符串代表不使用布局
+// 模板引擎需要实现 LayoutTemplateEngine
+func WithLayout(layout string) RenderOption {
+	return func(opts *renderOptions) {
+		opts.layout = layout
+		opts.hasLayout = true
+	}
+}
+
+func (c *Context) Render(tpl string, data any, opts ...RenderOption) error {
+	options := &renderOptions{statusCode: http.StatusOK}
+	for _, opt := range opts {
+		opt(options)
+	}
 	var err error
-	c.RespData, err = c.tplEngine.Render(c.Req.Context(), tpl, data)
-	c.RespStatusCode = 200
+	if options.hasLayout {
+		engine, ok := c.tplEngine.(LayoutTemplateEngine)
+		if !ok {
+			c.RespStatusCode = http.StatusInternalServerError
+			return errors.New("web: 模板引擎不支持布局")
+		}
+		c.RespData, err = engine.RenderLayout(c.Req.Context(), tpl, options.layout, data)
+	} else {
+		c.RespData, err = c.tplEngine.Render(c.Req.Context(), tpl, data)
+	}
+	c.RespStatusCode = options.statusCode
 	if err != nil {
-		c.RespStatusCode = 500
+		c.RespStatusCode = http.StatusInternalServerError
 	}
 	return err
 }
@@ -0,0 +1,163 @@
+package v9
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ErrInvalidFileName 文件名为空，或者试图跳出根目录
+var ErrInvalidFileName = errors.New("web: 非法的文件名")
+
+// FileStorage 保存上传的文件
+// 实现必须保证写入是原子的：要么完整地写入，要么什么都不留下，
+// 不能让别人读到写了一半的文件
+type FileStorage interface {
+	// Save 保存文件，返回写入的字节数
+	Save(ctx context.Context, name string, src io.Reader) (int64, error)
+	// Rename 重命名文件，newName 已经存在的时候会被覆盖
+	Rename(ctx context.Context, oldName string, newName string) error
+	// Remove 删除文件，文件不存在的时候不返回 error
+	Remove(ctx context.Context, name string) error
+}
+
+// LocalFileStorage 将文件保存在本地磁盘
+// 先写入同一个目录下的临时文件，写完之后再重命名，以此保证原子性
+type LocalFileStorage struct {
+	// Dir 根目录，name 会被限制在这个目录里面
+	// 为空的时候 name 就是文件的路径，由调用者自己保证它是安全的
+	Dir string
+}
+
+func (l *LocalFileStorage) Save(ctx context.Context, name string, src io.Reader) (int64, error) {
+	path, err := l.path(name)
+	if err != nil {
+		return 0, err
+	}
+	dir := filepath.Dir(path)
+	if err = os.MkdirAll(dir, 0o755); err != nil {
+		return 0, err
+	}
+	tmp, err := os.CreateTemp(dir, ".upload-*")
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(tmp, src)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return 0, err
+	}
+	return n, nil
+}
+
+func (l *LocalFileStorage) Rename(ctx context.Context, oldName string, newName string) error {
+	oldPath, err := l.path(oldName)
+	if err != nil {
+		return err
+	}
+	newPath, err := l.path(newName)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(newPath), 0o755); err != nil {
+		return err
+	}
+	return os.Rename(oldPath, newPath)
+}
+
+func (l *LocalFileStorage) Remove(ctx context.Context, name string) error {
+	path, err := l.path(name)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (l *LocalFileStorage) path(name string) (string, error) {
+	if name == "" {
+		return "", ErrInvalidFileName
+	}
+	if l.Dir == "" {
+		return name, nil
+	}
+	// 加上 / 之后再 Clean，所有的 .. 都会被消掉
+	cleaned := filepath.Clean("/" + filepath.ToSlash(name))
+	if cleaned == "/" {
+		return "", ErrInvalidFileName
+	}
+	return filepath.Join(l.Dir, filepath.FromSlash(strings.TrimPrefix(cleaned, "/"))), nil
+}
+
+// MemoryFileStorage 将文件保存在内存里面，主要用于测试
+type MemoryFileStorage struct {
+	mutex sync.RWMutex
+	files map[string][]byte
+}
+
+func NewMemoryFileStorage() *MemoryFileStorage {
+	return &MemoryFileStorage{
+		files: make(map[string][]byte, 4),
+	}
+}
+
+func (m *MemoryFileStorage) Save(ctx context.Context, name string, src io.Reader) (int64, error) {
+	if name == "" {
+		return 0, ErrInvalidFileName
+	}
+	buf := &bytes.Buffer{}
+	n, err := io.Copy(buf, src)
+	if err != nil {
+		return 0, err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.files[name] = buf.Bytes()
+	return n, nil
+}
+
+func (m *MemoryFileStorage) Rename(ctx context.Context, oldName string, newName string) error {
+	if newName == "" {
+		return ErrInvalidFileName
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	data, ok := m.files[oldName]
+	if !ok {
+		return os.ErrNotExist
+	}
+	delete(m.files, oldName)
+	m.files[newName] = data
+	return nil
+}
+
+func (m *MemoryFileStorage) Remove(ctx context.Context, name string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.files, name)
+	return nil
+}
+
+// Get 返回保存的文件内容
+func (m *MemoryFileStorage) Get(name string) ([]byte, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	data, ok := m.files[name]
+	return data, ok
+}
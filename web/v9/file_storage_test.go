@@ -0,0 +1,122 @@
+package v9
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalFileStorage_Save(t *testing.T) {
+	dir := t.TempDir()
+	s := &LocalFileStorage{Dir: dir}
+	testCases := []struct {
+		name     string
+		fileName string
+		wantPath string
+		wantErr  error
+	}{
+		{
+			name:     "file",
+			fileName: "a.txt",
+			wantPath: filepath.Join(dir, "a.txt"),
+		},
+		{
+			name:     "sub dir",
+			fileName: "avatar/a.txt",
+			wantPath: filepath.Join(dir, "avatar", "a.txt"),
+		},
+		{
+			// 不能跳出根目录
+			name:     "traversal",
+			fileName: "../../etc/passwd",
+			wantPath: filepath.Join(dir, "etc", "passwd"),
+		},
+		{
+			name:     "empty",
+			fileName: "",
+			wantErr:  ErrInvalidFileName,
+		},
+		{
+			name:     "root",
+			fileName: "/",
+			wantErr:  ErrInvalidFileName,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			n, err := s.Save(context.Background(), tc.fileName, strings.NewReader("hello"))
+			assert.Equal(t, tc.wantErr, err)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, int64(5), n)
+			data, err := os.ReadFile(tc.wantPath)
+			require.NoError(t, err)
+			assert.Equal(t, "hello", string(data))
+		})
+	}
+}
+
+func TestLocalFileStorage_Remove(t *testing.T) {
+	dir := t.TempDir()
+	s := &LocalFileStorage{Dir: dir}
+	_, err := s.Save(context.Background(), "avatar/a.txt", strings.NewReader("hello"))
+	require.NoError(t, err)
+
+	require.NoError(t, s.Remove(context.Background(), "avatar/a.txt"))
+	_, err = os.Stat(filepath.Join(dir, "avatar", "a.txt"))
+	assert.True(t, os.IsNotExist(err))
+	// 不存在的文件不返回 error
+	assert.NoError(t, s.Remove(context.Background(), "avatar/a.txt"))
+	assert.Equal(t, ErrInvalidFileName, s.Remove(context.Background(), "/"))
+}
+
+func TestLocalFileStorage_Rename(t *testing.T) {
+	dir := t.TempDir()
+	s := &LocalFileStorage{Dir: dir}
+	_, err := s.Save(context.Background(), "a.txt.tmp", strings.NewReader("new"))
+	require.NoError(t, err)
+	_, err = s.Save(context.Background(), "avatar/a.txt", strings.NewReader("old"))
+	require.NoError(t, err)
+
+	// 覆盖已有的文件
+	require.NoError(t, s.Rename(context.Background(), "a.txt.tmp", "avatar/a.txt"))
+	data, err := os.ReadFile(filepath.Join(dir, "avatar", "a.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(data))
+	_, err = os.Stat(filepath.Join(dir, "a.txt.tmp"))
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, ErrInvalidFileName, s.Rename(context.Background(), "avatar/a.txt", "/"))
+}
+
+func TestLocalFileStorage_SaveAtomic(t *testing.T) {
+	dir := t.TempDir()
+	s := &LocalFileStorage{Dir: dir}
+	_, err := s.Save(context.Background(), "a.txt", strings.NewReader("old"))
+	require.NoError(t, err)
+
+	// 写到一半出错，原来的文件不受影响，也不会留下临时文件
+	src := io.MultiReader(strings.NewReader("new"), &errReader{err: errors.New("mock error")})
+	_, err = s.Save(context.Background(), "a.txt", src)
+	assert.Error(t, err)
+	data, err := os.ReadFile(filepath.Join(dir, "a.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "old", string(data))
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+type errReader struct {
+	err error
+}
+
+func (r *errReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
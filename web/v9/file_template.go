@@ -0,0 +1,280 @@
+package v9
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// layoutDirective 页面在第一行通过注释声明自己的布局，例如
+// {{/* layout: layouts/base.gohtml */}}
+var layoutDirective = regexp.MustCompile(`^\s*\{\{-?\s*/\*\s*layout:\s*(\S+)\s*\*/\s*-?\}\}`)
+
+// contentTemplate 页面的内容在布局里面的名字，布局通过
+// {{ block "content" . }}{{ end }} 或者 {{ template "content" . }} 引用页面
+const contentTemplate = "content"
+
+type FileTemplateEngineOption func(e *FileTemplateEngine)
+
+// FileTemplateEngine 从 fs.FS 里面加载模板，支持布局和公共的片段
+//  1. 模板的名字就是它在 fs.FS 里面的路径，例如 users/list.gohtml
+//  2. 页面在第一行通过 {{/* layout: layouts/base.gohtml */}} 声明布局，
+//     布局通过 {{ block "content" . }}{{ end }} 引用页面的内容，
+//     页面也可以通过 define 覆盖布局里面的其它 block，例如 title
+//  3. 片段目录（默认是 partials）下的所有文件在每个页面里面都可以通过
+//     {{ template "partials/nav.gohtml" . }} 使用
+//  4. 每个页面和布局的组合只解析一次，开发模式下文件修改之后会重新解析
+type FileTemplateEngine struct {
+	fs            fs.FS
+	partialDir    string
+	defaultLayout string
+	funcs         template.FuncMap
+	devMode       bool
+
+	mutex         sync.RWMutex
+	cache         map[string]*compiledTemplate
+	funcProviders []TemplateFuncProvider
+}
+
+// compiledTemplate 解析好的页面
+type compiledTemplate struct {
+	t *template.Template
+	// entry 执行的模板，有布局的时候是布局，否则是页面本身
+	entry string
+	// files 用到的文件和它们的修改时间，开发模式下用于判断是否需要重新解析
+	files map[string]time.Time
+	// partials 解析的时候有哪些片段，用于发现新增和删除的片段
+	partials []string
+	// clones 用于绑定请求方法的 t 的副本
+	clones sync.Pool
+}
+
+func NewFileTemplateEngine(fsys fs.FS, opts ...FileTemplateEngineOption) *FileTemplateEngine {
+	res := &FileTemplateEngine{
+		fs:         fsys,
+		partialDir: "partials",
+		funcs:      DefaultTemplateFuncs(),
+		cache:      make(map[string]*compiledTemplate, 16),
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res
+}
+
+// WithPartialDir 片段所在的目录，默认是 partials
+func WithPartialDir(dir string) FileTemplateEngineOption {
+	return func(e *FileTemplateEngine) {
+		e.partialDir = dir
+	}
+}
+
+// WithDefaultLayout 没有声明布局的页面使用的布局
+func WithDefaultLayout(layout string) FileTemplateEngineOption {
+	return func(e *FileTemplateEngine) {
+		e.defaultLayout = layout
+	}
+}
+
+// WithTemplateFuncs 注册模板方法，会覆盖 DefaultTemplateFuncs 里面同名的方法
+func WithTemplateFuncs(funcs template.FuncMap) FileTemplateEngineOption {
+	return func(e *FileTemplateEngine) {
+		for name, fn := range funcs {
+			e.funcs[name] = fn
+		}
+	}
+}
+
+// WithDevMode 开发模式，每次渲染之前检查文件是否被修改过，修改过就重新解析
+// 依赖文件的修改时间，所以对 embed.FS 没有效果
+func WithDevMode() FileTemplateEngineOption {
+	return func(e *FileTemplateEngine) {
+		e.devMode = true
+	}
+}
+
+// RegisterFuncProvider 注册一个 TemplateFuncProvider
+func (e *FileTemplateEngine) RegisterFuncProvider(p TemplateFuncProvider) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.funcProviders = append(e.funcProviders, p)
+	// 已经解析的模板没有这些方法
+	e.cache = make(map[string]*compiledTemplate, 16)
+}
+
+func (e *FileTemplateEngine) Render(ctx context.Context, tplName string, data any) ([]byte, error) {
+	return e.render(ctx, tplName, "", false, data)
+}
+
+func (e *FileTemplateEngine) RenderLayout(ctx context.Context,
+	tplName string, layout string, data any) ([]byte, error) {
+	return e.render(ctx, tplName, layout, true, data)
+}
+
+func (e *FileTemplateEngine) render(ctx context.Context,
+	tplName string, layout string, override bool, data any) ([]byte, error) {
+	key := tplName
+	if override {
+		key = tplName + "\x00" + layout
+	}
+	ct, err := e.get(key, tplName, layout, override)
+	if err != nil {
+		return nil, err
+	}
+
+	e.mutex.RLock()
+	providers := e.funcProviders
+	e.mutex.RUnlock()
+	res := &bytes.Buffer{}
+	if len(providers) == 0 {
+		err = ct.t.ExecuteTemplate(res, ct.entry, data)
+		return res.Bytes(), err
+	}
+	// 和 GoTemplateEngine 一样，缓存里面的模板永远不执行，在副本上绑定这次请求的方法
+	t, _ := ct.clones.Get().(*template.Template)
+	if t == nil {
+		if t, err = ct.t.Clone(); err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range providers {
+		t.Funcs(p(ctx))
+	}
+	err = t.ExecuteTemplate(res, ct.entry, data)
+	ct.clones.Put(t)
+	return res.Bytes(), err
+}
+
+func (e *FileTemplateEngine) get(key string, tplName string, layout string, override bool) (*compiledTemplate, error) {
+	e.mutex.RLock()
+	ct, ok := e.cache[key]
+	e.mutex.RUnlock()
+	if ok && (!e.devMode || !e.modified(ct)) {
+		return ct, nil
+	}
+	ct, err := e.compile(tplName, layout, override)
+	if err != nil {
+		return nil, err
+	}
+	e.mutex.Lock()
+	e.cache[key] = ct
+	e.mutex.Unlock()
+	return ct, nil
+}
+
+func (e *FileTemplateEngine) compile(tplName string, layout string, override bool) (*compiledTemplate, error) {
+	ct := &compiledTemplate{files: make(map[string]time.Time, 4)}
+	page, err := e.readFile(ct, tplName)
+	if err != nil {
+		return nil, err
+	}
+	if !override {
+		layout = e.defaultLayout
+		if matches := layoutDirective.FindSubmatch(page); matches != nil {
+			layout = string(matches[1])
+		}
+	}
+
+	ct.entry = tplName
+	if layout != "" {
+		ct.entry = layout
+	}
+	t := template.New(ct.entry).Funcs(e.funcs)
+	ct.t = t
+	e.mutex.RLock()
+	for _, p := range e.funcProviders {
+		t.Funcs(p(context.Background()))
+	}
+	e.mutex.RUnlock()
+
+	ct.partials, err = e.partials()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range ct.partials {
+		src, err := e.readFile(ct, name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = t.New(name).Parse(string(src)); err != nil {
+			return nil, err
+		}
+	}
+
+	if layout == "" {
+		_, err = t.Parse(string(page))
+		return ct, err
+	}
+	src, err := e.readFile(ct, layout)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = t.Parse(string(src)); err != nil {
+		return nil, err
+	}
+	// 布局先解析，页面里面的 define 才能覆盖布局里面的 block
+	if _, err = t.New(contentTemplate).Parse(string(page)); err != nil {
+		return nil, err
+	}
+	return ct, nil
+}
+
+func (e *FileTemplateEngine) readFile(ct *compiledTemplate, name string) ([]byte, error) {
+	info, err := fs.Stat(e.fs, name)
+	if err != nil {
+		return nil, fmt.Errorf("web: 找不到模板 %s: %w", name, err)
+	}
+	src, err := fs.ReadFile(e.fs, name)
+	if err != nil {
+		return nil, err
+	}
+	ct.files[name] = info.ModTime()
+	return src, nil
+}
+
+// partials 找到片段目录下所有的文件
+func (e *FileTemplateEngine) partials() ([]string, error) {
+	res := make([]string, 0, 8)
+	err := fs.WalkDir(e.fs, e.partialDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			res = append(res, path)
+		}
+		return nil
+	})
+	// 没有片段目录
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	sort.Strings(res)
+	return res, err
+}
+
+// modified 判断解析之后文件是否被修改过，或者片段有没有增减
+func (e *FileTemplateEngine) modified(ct *compiledTemplate) bool {
+	for name, modTime := range ct.files {
+		info, err := fs.Stat(e.fs, name)
+		if err != nil || !info.ModTime().Equal(modTime) {
+			return true
+		}
+	}
+	partials, err := e.partials()
+	if err != nil || len(partials) != len(ct.partials) {
+		return true
+	}
+	for i, name := range partials {
+		if name != ct.partials[i] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,321 @@
+package v9
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+type templateUser struct {
+	Name      string
+	CreatedAt time.Time
+}
+
+func TestFileTemplateEngine_Render(t *testing.T) {
+	s := NewHTTPServer()
+	s.Get("/users", func(ctx *Context) {}, WithRouteName("user.list"))
+	users := map[string]any{
+		"Users": []templateUser{
+			{Name: "Tom", CreatedAt: time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)},
+			{Name: "Jerry"},
+		},
+	}
+	testCases := []struct {
+		name    string
+		engine  *FileTemplateEngine
+		tplName string
+		data    any
+
+		wantContains []string
+		wantRes      string
+		wantErr      string
+	}{
+		{
+			name:    "layout",
+			engine:  NewFileTemplateEngine(os.DirFS("testdata/views")),
+			tplName: "users/list.gohtml",
+			data:    users,
+			wantContains: []string{
+				"<title>用户列表</title>",
+				`<nav><a href="/users?page=1">用户</a></nav>`,
+				"<ul><li>Tom 2023-10-01</li><li>Jerry </li></ul>",
+			},
+		},
+		{
+			name:    "no layout",
+			engine:  NewFileTemplateEngine(os.DirFS("testdata/views")),
+			tplName: "users/detail.gohtml",
+			data:    templateUser{Name: "Tom"},
+			wantRes: "<p>Tom</p>\n",
+		},
+		{
+			// 页面自己声明的布局优先
+			name: "default layout",
+			engine: NewFileTemplateEngine(os.DirFS("testdata/views"),
+				WithDefaultLayout("layouts/plain.gohtml")),
+			tplName: "users/detail.gohtml",
+			data:    templateUser{Name: "Tom"},
+			wantRes: "<main><p>Tom</p>\n</main>\n",
+		},
+		{
+			name: "page layout over default layout",
+			engine: NewFileTemplateEngine(os.DirFS("testdata/views"),
+				WithDefaultLayout("layouts/plain.gohtml")),
+			tplName:      "users/list.gohtml",
+			data:         users,
+			wantContains: []string{"<title>用户列表</title>"},
+		},
+		{
+			name: "custom funcs",
+			engine: NewFileTemplateEngine(os.DirFS("testdata/views"),
+				WithTemplateFuncs(template.FuncMap{
+					"formatDate": func(t time.Time, layout string) string {
+						return "today"
+					},
+				})),
+			tplName:      "users/list.gohtml",
+			data:         users,
+			wantContains: []string{"<li>Tom today</li>"},
+		},
+		{
+			name:    "not found",
+			engine:  NewFileTemplateEngine(os.DirFS("testdata/views")),
+			tplName: "users/missing.gohtml",
+			wantErr: "web: 找不到模板 users/missing.gohtml",
+		},
+		{
+			name:    "layout not found",
+			engine:  NewFileTemplateEngine(os.DirFS("testdata/views"), WithDefaultLayout("layouts/missing.gohtml")),
+			tplName: "users/detail.gohtml",
+			wantErr: "web: 找不到模板 layouts/missing.gohtml",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.engine.RegisterFuncProvider(s.TemplateFuncs)
+			// 第二次走缓存
+			for i := 0; i < 2; i++ {
+				res, err := tc.engine.Render(context.Background(), tc.tplName, tc.data)
+				if tc.wantErr != "" {
+					require.Error(t, err)
+					assert.True(t, strings.HasPrefix(err.Error(), tc.wantErr), err.Error())
+					return
+				}
+				require.NoError(t, err)
+				if tc.wantRes != "" {
+					assert.Equal(t, tc.wantRes, string(res))
+				}
+				for _, want := range tc.wantContains {
+					assert.Contains(t, string(res), want)
+				}
+			}
+		})
+	}
+}
+
+func TestContext_RenderOptions(t *testing.T) {
+	engine := NewFileTemplateEngine(os.DirFS("testdata/views"))
+	s := NewHTTPServer(ServerWithTemplateEngine(engine))
+	engine.RegisterFuncProvider(s.TemplateFuncs)
+	user := templateUser{Name: "Tom"}
+	s.Get("/plain", func(ctx *Context) {
+		_ = ctx.Render("users/detail.gohtml", user, WithLayout("layouts/plain.gohtml"))
+	})
+	s.Get("/no_layout", func(ctx *Context) {
+		_ = ctx.Render("users/list.gohtml", map[string]any{}, WithLayout(""))
+	})
+	s.Post("/created", func(ctx *Context) {
+		_ = ctx.Render("users/detail.gohtml", user, WithStatusCode(http.StatusCreated))
+	})
+	s.Get("/error", func(ctx *Context) {
+		_ = ctx.Render("users/missing.gohtml", user, WithStatusCode(http.StatusCreated))
+	})
+
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/plain", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "<main><p>Tom</p>\n</main>\n", recorder.Body.String())
+
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/no_layout", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.NotContains(t, recorder.Body.String(), "<html>")
+	assert.Contains(t, recorder.Body.String(), "<ul></ul>")
+
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/created", nil))
+	assert.Equal(t, http.StatusCreated, recorder.Code)
+	assert.Equal(t, "<p>Tom</p>\n", recorder.Body.String())
+
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/error", nil))
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+
+	// GoTemplateEngine 不支持布局
+	tpl, err := template.New("page").Parse("page")
+	require.NoError(t, err)
+	s = NewHTTPServer(ServerWithTemplateEngine(&GoTemplateEngine{T: tpl}))
+	s.Get("/page", func(ctx *Context) {
+		assert.EqualError(t, ctx.Render("page", nil, WithLayout("layout")), "web: 模板引擎不支持布局")
+	})
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/page", nil))
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+}
+
+func TestFileTemplateEngine_DevMode(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, content string, modTime time.Time) {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+		require.NoError(t, os.Chtimes(path, modTime, modTime))
+	}
+	old := time.Now().Add(-time.Hour)
+	write("page.gohtml", `{{/* layout: layout.gohtml */}}v1`, old)
+	write("layout.gohtml", `[{{ block "content" . }}{{ end }}]`, old)
+
+	dev := NewFileTemplateEngine(os.DirFS(dir), WithDevMode())
+	prod := NewFileTemplateEngine(os.DirFS(dir))
+	render := func(e *FileTemplateEngine) string {
+		res, err := e.Render(context.Background(), "page.gohtml", nil)
+		require.NoError(t, err)
+		return string(res)
+	}
+	assert.Equal(t, "[v1]", render(dev))
+	assert.Equal(t, "[v1]", render(prod))
+
+	// 修改页面
+	write("page.gohtml", `{{/* layout: layout.gohtml */}}v2`, time.Now())
+	assert.Equal(t, "[v2]", render(dev))
+	assert.Equal(t, "[v1]", render(prod))
+
+	// 修改布局
+	write("layout.gohtml", `({{ block "content" . }}{{ end }}){{ template "partials/footer.gohtml" }}`, time.Now())
+	// 片段还不存在
+	_, err := dev.Render(context.Background(), "page.gohtml", nil)
+	assert.Error(t, err)
+	// 新增片段
+	write("partials/footer.gohtml", `footer`, time.Now())
+	assert.Equal(t, "(v2)footer", render(dev))
+}
+
+func TestFileTemplateEngine_FuncProvider(t *testing.T) {
+	type userKey struct{}
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "page.gohtml"),
+		[]byte(`{{ currentUser }} {{ cspNonce }}`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "other.gohtml"), []byte(`other`), 0o644))
+	engine := NewFileTemplateEngine(os.DirFS(dir))
+	// 注册之前渲染过的模板，注册之后依旧可以渲染
+	res, err := engine.Render(context.Background(), "other.gohtml", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "other", string(res))
+
+	engine.RegisterFuncProvider(func(ctx context.Context) template.FuncMap {
+		user, _ := ctx.Value(userKey{}).(string)
+		return template.FuncMap{
+			"currentUser": func() string {
+				return user
+			},
+		}
+	})
+	for _, user := range []string{"tom", "jerry"} {
+		res, err = engine.Render(context.WithValue(context.Background(), userKey{}, user), "page.gohtml", nil)
+		require.NoError(t, err)
+		assert.Equal(t, user+" ", string(res))
+	}
+	res, err = engine.Render(context.Background(), "other.gohtml", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "other", string(res))
+}
+
+func TestGoTemplateEngine_FuncProvider(t *testing.T) {
+	type userKey struct{}
+	engine := &GoTemplateEngine{}
+	engine.RegisterFuncProvider(func(ctx context.Context) template.FuncMap {
+		user, _ := ctx.Value(userKey{}).(string)
+		return template.FuncMap{
+			"currentUser": func() string {
+				return user
+			},
+		}
+	})
+	require.NoError(t, engine.LoadFromFS(fstest.MapFS{
+		"page.gohtml": {Data: []byte(`{{ define "page" }}{{ currentUser }}{{ end }}`)},
+	}, "*.gohtml"))
+
+	// 并发渲染的时候，副本被复用，但是每个请求拿到的都是自己的方法
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(user string) {
+			defer wg.Done()
+			res, err := engine.Render(context.WithValue(context.Background(), userKey{}, user), "page", nil)
+			assert.NoError(t, err)
+			assert.Equal(t, user, string(res))
+		}(fmt.Sprintf("user%d", i))
+	}
+	wg.Wait()
+
+	// 重新加载之后，旧的副本不再使用
+	require.NoError(t, engine.LoadFromFS(fstest.MapFS{
+		"page.gohtml": {Data: []byte(`{{ define "page" }}hello {{ currentUser }}{{ end }}`)},
+	}, "*.gohtml"))
+	res, err := engine.Render(context.WithValue(context.Background(), userKey{}, "tom"), "page", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "hello tom", string(res))
+}
+
+func TestDefaultTemplateFuncs(t *testing.T) {
+	testCases := []struct {
+		name    string
+		tpl     string
+		data    any
+		wantRes string
+		wantErr bool
+	}{
+		{
+			name:    "formatDate",
+			tpl:     `{{ formatDate . "2006-01-02 15:04" }}`,
+			data:    time.Date(2023, 10, 1, 12, 30, 0, 0, time.UTC),
+			wantRes: "2023-10-01 12:30",
+		},
+		{
+			name:    "formatDate zero",
+			tpl:     `{{ formatDate . "2006-01-02" }}`,
+			data:    time.Time{},
+			wantRes: "",
+		},
+		{
+			name:    "placeholders",
+			tpl:     `{{ csrfToken }}{{ csrfField }}{{ cspNonce }}`,
+			wantRes: "",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tpl, err := template.New(tc.name).Funcs(DefaultTemplateFuncs()).Parse(tc.tpl)
+			require.NoError(t, err)
+			res := &strings.Builder{}
+			err = tpl.Execute(res, tc.data)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantRes, res.String())
+		})
+	}
+}
@@ -1,23 +1,68 @@
 package v9
 
 import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	lru "github.com/hashicorp/golang-lru"
+	"github.com/google/uuid"
+	"html"
 	"io"
-	"io/ioutil"
-	"log"
+	"io/fs"
+	"mime"
 	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxUploadRequestSize = 32 << 20
+	// 超过这个大小的部分会被写入临时文件
+	uploadMaxMemory = 8 << 20
+	// http.DetectContentType 最多只看前 512 个字节
+	sniffLen = 512
 )
 
 type FileUploader struct {
 	// FileField 对应于文件在表单中的字段名字
+	// 同一个字段可以上传多个文件
 	FileField string
-	// DstPathFunc 用于计算目标路径
+	// DstPathFunc 用于计算目标路径，没有设置 Storage 的时候就是本地磁盘上的路径，
+	// 否则是文件在 Storage 里面的名字。
+	// fh.Filename 已经去掉了目录部分，为 nil 的时候直接使用 fh.Filename
 	DstPathFunc func(fh *multipart.FileHeader) string
+	// Storage 保存文件，默认保存在本地磁盘上 DstPathFunc 返回的路径，
+	// DstPathFunc 也为 nil 的时候保存在 os.TempDir() 下面
+	Storage FileStorage
+	// MaxRequestSize 整个请求的大小上限，默认是 32MB
+	MaxRequestSize int64
+	// MaxFileSize 单个文件的大小上限，0 代表只受 MaxRequestSize 限制
+	MaxFileSize int64
+	// AllowedContentTypes 允许的文件类型，根据文件内容判断而不是文件扩展名，
+	// 支持 image/* 这种写法。为空的时候不限制
+	AllowedContentTypes []string
+}
+
+// UploadedFile 上传成功的文件
+type UploadedFile struct {
+	Name        string `json:"name"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type"`
+}
+
+type uploadResult struct {
+	Files []UploadedFile `json:"files,omitempty"`
+	Error string         `json:"error,omitempty"`
 }
 
 func (f *FileUploader) Handle() HandleFunc {
@@ -27,125 +72,335 @@ func (f *FileUploader) Handle() HandleFunc {
 	// 	// 因为我们需要教会用户说，这个 file 是指什么意思
 	// 	f.FileField = "file"
 	// }
-	return func(ctx *Context) {
-		src, srcHeader, err := ctx.Req.FormFile(f.FileField)
-		if err != nil {
-			ctx.RespStatusCode = 400
-			ctx.RespData = []byte("上传失败，未找到数据")
-			log.Fatalln(err)
+	return f.HandleFunc
+}
+
+// HandleFunc 这种设计方案也是可以的，但是不如上一种灵活。
+// 它可以直接用来注册路由
+// 上一种可以在返回 HandleFunc 之前可以继续检测一下传入的字段
+// 这种形态和 Option 模式配合就很好
+func (f *FileUploader) HandleFunc(ctx *Context) {
+	maxSize := f.MaxRequestSize
+	if maxSize <= 0 {
+		maxSize = defaultMaxUploadRequestSize
+	}
+	ctx.Req.Body = http.MaxBytesReader(ctx.Resp, ctx.Req.Body, maxSize)
+	err := ctx.Req.ParseMultipartForm(uploadMaxMemory)
+	if ctx.Req.MultipartForm != nil {
+		// 删除解析表单时生成的临时文件
+		defer ctx.Req.MultipartForm.RemoveAll()
+	}
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			respUploadError(ctx, http.StatusRequestEntityTooLarge, "上传失败，请求太大")
 			return
 		}
-		defer src.Close()
-		dst, err := os.OpenFile(f.DstPathFunc(srcHeader),
-			os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o666)
+		respUploadError(ctx, http.StatusBadRequest, "上传失败，无法解析表单")
+		return
+	}
+	fhs := ctx.Req.MultipartForm.File[f.FileField]
+	if len(fhs) == 0 {
+		respUploadError(ctx, http.StatusBadRequest, "上传失败，未找到数据")
+		return
+	}
+	storage := f.storage()
+	// 要么全部成功，要么全部失败：先把所有的文件保存在临时的名字下面，
+	// 全部成功之后再重命名，这样失败的时候不会删掉原本就存在的同名文件
+	files := make([]UploadedFile, 0, len(fhs))
+	staged := make([]stagedFile, 0, len(fhs))
+	// 请求可能已经被取消了，所以清理的时候不使用请求的 context
+	cleanup := func() {
+		for _, sf := range staged {
+			_ = storage.Remove(context.Background(), sf.tmp)
+		}
+	}
+	for _, fh := range fhs {
+		file, sf, code, err := f.save(ctx, storage, fh)
 		if err != nil {
-			ctx.RespStatusCode = 500
-			ctx.RespData = []byte("上传失败")
-			log.Fatalln(err)
+			cleanup()
+			respUploadError(ctx, code, err.Error())
 			return
 		}
-		defer dst.Close()
-
-		_, err = io.CopyBuffer(dst, src, nil)
-		if err != nil {
-			ctx.RespStatusCode = 500
-			ctx.RespData = []byte("上传失败")
-			log.Fatalln(err)
+		files = append(files, file)
+		staged = append(staged, sf)
+	}
+	for len(staged) > 0 {
+		if err = storage.Rename(ctx.Req.Context(), staged[0].tmp, staged[0].name); err != nil {
+			cleanup()
+			respUploadError(ctx, http.StatusInternalServerError, "上传失败")
 			return
 		}
-		ctx.RespData = []byte("上传成功")
+		staged = staged[1:]
 	}
+	respUploadJSON(ctx, http.StatusOK, uploadResult{Files: files})
 }
 
-// HandleFunc 这种设计方案也是可以的，但是不如上一种灵活。
-// 它可以直接用来注册路由
-// 上一种可以在返回 HandleFunc 之前可以继续检测一下传入的字段
-// 这种形态和 Option 模式配合就很好
-func (f *FileUploader) HandleFunc(ctx *Context) {
-	src, srcHeader, err := ctx.Req.FormFile(f.FileField)
+// stagedFile 已经保存在临时名字 tmp 下面，还没有重命名为 name 的文件
+type stagedFile struct {
+	tmp  string
+	name string
+}
+
+func (f *FileUploader) storage() FileStorage {
+	if f.Storage != nil {
+		return f.Storage
+	}
+	// 空的 Dir 代表 DstPathFunc 返回的就是完整的路径
+	if f.DstPathFunc != nil {
+		return &LocalFileStorage{}
+	}
+	return &LocalFileStorage{Dir: os.TempDir()}
+}
+
+// save 将单个文件保存在临时的名字下面，出错的时候返回对应的响应码
+func (f *FileUploader) save(ctx *Context, storage FileStorage,
+	fh *multipart.FileHeader) (UploadedFile, stagedFile, int, error) {
+	fh.Filename = sanitizeFileName(fh.Filename)
+	if fh.Filename == "" {
+		return UploadedFile{}, stagedFile{}, http.StatusBadRequest, fmt.Errorf("上传失败，非法的文件名")
+	}
+	if f.MaxFileSize > 0 && fh.Size > f.MaxFileSize {
+		return UploadedFile{}, stagedFile{}, http.StatusRequestEntityTooLarge,
+			fmt.Errorf("上传失败，文件 %s 太大", fh.Filename)
+	}
+	src, err := fh.Open()
 	if err != nil {
-		ctx.RespStatusCode = 400
-		ctx.RespData = []byte("上传失败，未找到数据")
-		log.Fatalln(err)
-		return
+		return UploadedFile{}, stagedFile{}, http.StatusInternalServerError, fmt.Errorf("上传失败")
 	}
 	defer src.Close()
-	dst, err := os.OpenFile(f.DstPathFunc(srcHeader),
-		os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o666)
+
+	head, contentType, err := sniffContentType(src)
 	if err != nil {
-		ctx.RespStatusCode = 500
-		ctx.RespData = []byte("上传失败")
-		log.Fatalln(err)
-		return
+		return UploadedFile{}, stagedFile{}, http.StatusInternalServerError, fmt.Errorf("上传失败")
+	}
+	if !f.allowed(contentType) {
+		return UploadedFile{}, stagedFile{}, http.StatusUnsupportedMediaType,
+			fmt.Errorf("上传失败，不支持的文件类型 %s", contentType)
 	}
-	defer dst.Close()
 
-	_, err = io.CopyBuffer(dst, src, nil)
+	name := fh.Filename
+	if f.DstPathFunc != nil {
+		name = f.DstPathFunc(fh)
+	}
+	// 和目标在同一个目录下面，重命名才是原子的
+	sf := stagedFile{tmp: name + ".upload-" + uuid.New().String(), name: name}
+	size, err := storage.Save(ctx.Req.Context(), sf.tmp, io.MultiReader(bytes.NewReader(head), src))
 	if err != nil {
-		ctx.RespStatusCode = 500
-		ctx.RespData = []byte("上传失败")
-		log.Fatalln(err)
-		return
+		return UploadedFile{}, stagedFile{}, http.StatusInternalServerError, fmt.Errorf("上传失败")
 	}
-	ctx.RespData = []byte("上传成功")
+	return UploadedFile{Name: fh.Filename, Size: size, ContentType: contentType}, sf, 0, nil
+}
+
+func (f *FileUploader) allowed(contentType string) bool {
+	if len(f.AllowedContentTypes) == 0 {
+		return true
+	}
+	// 去掉 ; charset=utf-8 之类的参数
+	if idx := strings.Index(contentType, ";"); idx >= 0 {
+		contentType = contentType[:idx]
+	}
+	for _, allowed := range f.AllowedContentTypes {
+		if allowed == contentType {
+			return true
+		}
+		if strings.HasSuffix(allowed, "/*") && strings.HasPrefix(contentType, allowed[:len(allowed)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// sanitizeFileName 去掉目录部分，防止路径穿越，非法的文件名返回空字符串
+func sanitizeFileName(name string) string {
+	name = filepath.Base(filepath.Clean("/" + strings.ReplaceAll(name, "\\", "/")))
+	if name == "/" || name == "." {
+		return ""
+	}
+	return name
+}
+
+// sniffContentType 根据前 512 个字节判断文件类型，返回读出来的数据
+func sniffContentType(src io.Reader) ([]byte, string, error) {
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(src, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, "", err
+	}
+	return head[:n], http.DetectContentType(head[:n]), nil
+}
+
+func respUploadError(ctx *Context, code int, msg string) {
+	respUploadJSON(ctx, code, uploadResult{Error: msg})
+}
+
+func respUploadJSON(ctx *Context, code int, res uploadResult) {
+	ctx.Resp.Header().Set("Content-Type", "application/json")
+	// uploadResult 序列化不会出错
+	_ = ctx.RespJSON(code, res)
 }
 
 // FileDownloader 直接操作了 http.ResponseWriter
 // 所以在 Middleware 里面将不能使用 RespData
 // 因为没有赋值
 type FileDownloader struct {
+	// Dir 下载的文件所在目录，用户只能下载这个目录里面的文件
 	Dir string
+	// Inline 为 true 的时候让浏览器直接展示文件，否则作为附件下载
+	Inline bool
+	// Authorize 判断当前请求能不能下载文件，name 是相对于 Dir 的路径
+	// 返回 false 的时候响应 403。为 nil 的时候不做检查
+	Authorize func(ctx *Context, name string) bool
 }
 
+// Handle 通过查询参数 file 指定要下载的文件，例如 /download?file=test.txt
+// 支持 Range 和条件请求，Content-Type 根据扩展名判断，判断不出来的话根据文件内容判断
 func (f *FileDownloader) Handle() HandleFunc {
 	return func(ctx *Context) {
 		req, _ := ctx.QueryValue("file").String()
-		path := filepath.Join(f.Dir, filepath.Clean(req))
-		fn := filepath.Base(path)
+		// 加上 / 之后再 Clean，所有的 .. 都会被消掉
+		name := strings.TrimPrefix(filepath.Clean("/"+filepath.ToSlash(req)), "/")
+		if name == "" {
+			ctx.RespStatusCode = http.StatusBadRequest
+			ctx.RespData = []byte("请指定文件")
+			return
+		}
+		path, ok := f.confine(name)
+		if !ok {
+			ctx.RespStatusCode = http.StatusNotFound
+			return
+		}
+		if f.Authorize != nil && !f.Authorize(ctx, name) {
+			ctx.RespStatusCode = http.StatusForbidden
+			return
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			ctx.RespStatusCode = http.StatusNotFound
+			return
+		}
+		defer file.Close()
+		stat, err := file.Stat()
+		if err != nil || stat.IsDir() {
+			ctx.RespStatusCode = http.StatusNotFound
+			return
+		}
+		disposition := "attachment"
+		if f.Inline {
+			disposition = "inline"
+		}
 		header := ctx.Resp.Header()
-		header.Set("Content-Disposition", "attachment;filename="+fn)
+		header.Set("Content-Disposition", contentDisposition(disposition, filepath.Base(path)))
 		header.Set("Content-Description", "File Transfer")
-		header.Set("Content-Type", "application/octet-stream")
-		header.Set("Content-Transfer-Encoding", "binary")
-		header.Set("Expires", "0")
 		header.Set("Cache-Control", "must-revalidate")
-		header.Set("Pragma", "public")
-		http.ServeFile(ctx.Resp, ctx.Req, path)
+		// ServeContent 会根据文件名和文件内容设置 Content-Type，
+		// 并且处理 Range 和条件请求
+		http.ServeContent(ctx.Resp, ctx.Req, stat.Name(), stat.ModTime(), file)
+	}
+}
+
+// confine 确认 name 对应的文件在 Dir 里面，符号链接也不能指向 Dir 外面
+func (f *FileDownloader) confine(name string) (string, bool) {
+	root, err := filepath.EvalSymlinks(f.Dir)
+	if err != nil {
+		return "", false
+	}
+	path, err := filepath.EvalSymlinks(filepath.Join(root, filepath.FromSlash(name)))
+	if err != nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
 	}
+	return path, true
+}
+
+// contentDisposition 按照 RFC 6266 和 RFC 5987 生成 Content-Disposition。
+// filename 是给老浏览器用的 ASCII 版本，filename* 是 UTF-8 编码的完整文件名
+func contentDisposition(disposition string, name string) string {
+	var fallback strings.Builder
+	ascii := true
+	for _, r := range name {
+		switch {
+		case r == '"' || r == '\\':
+			fallback.WriteByte('\\')
+			fallback.WriteRune(r)
+		case r < 0x20 || r == 0x7f:
+			fallback.WriteByte('_')
+		case r > 0x7f:
+			ascii = false
+			fallback.WriteByte('_')
+		default:
+			fallback.WriteRune(r)
+		}
+	}
+	res := disposition + `; filename="` + fallback.String() + `"`
+	if ascii {
+		return res
+	}
+	var encoded strings.Builder
+	for i := 0; i < len(name); i++ {
+		if isAttrChar(name[i]) {
+			encoded.WriteByte(name[i])
+			continue
+		}
+		fmt.Fprintf(&encoded, "%%%02X", name[i])
+	}
+	return res + "; filename*=UTF-8''" + encoded.String()
+}
+
+// isAttrChar RFC 5987 里面不需要编码的字符
+func isAttrChar(c byte) bool {
+	if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' {
+		return true
+	}
+	return strings.IndexByte("!#$&+-.^_`|~", c) >= 0
 }
 
 type StaticResourceHandlerOption func(h *StaticResourceHandler)
 
+// StaticResourceHandler 静态资源服务器
+// 1. 文件来自 fs.FS，所以既可以是磁盘上的目录，也可以是 embed.FS
+// 2. 请求目录的时候返回目录下的 index 文件，没有的话按照设置列出目录或者返回 404
+// 3. 客户端支持的话，优先返回预先压缩好的 .br 和 .gz 文件
+// 4. 支持 Range 和条件请求
 type StaticResourceHandler struct {
-	dir                     string
+	fs                      fs.FS
 	pathPrefix              string
 	extensionContentTypeMap map[string]string
+	indexFiles              []string
+	listDir                 bool
 
 	// 缓存静态资源的限制
-	cache       *lru.Cache
+	cache       *fileCache
 	maxFileSize int
 }
 
 type fileCacheItem struct {
-	fileName    string
-	fileSize    int
-	contentType string
-	data        []byte
+	fileName string
+	fileSize int
+	data     []byte
+	// etag 根据文件内容计算
+	etag    string
+	modTime time.Time
 }
 
+// NewStaticResourceHandler 提供 dir 目录下的文件
+// pathPrefix 是路由的前缀，请求的路径去掉 pathPrefix 之后就是文件在 dir 里面的路径
 func NewStaticResourceHandler(dir string, pathPrefix string,
+	options ...StaticResourceHandlerOption) *StaticResourceHandler {
+	return NewStaticResourceHandlerFS(os.DirFS(dir), pathPrefix, options...)
+}
+
+// NewStaticResourceHandlerFS 提供 fsys 里面的文件，例如 embed.FS
+func NewStaticResourceHandlerFS(fsys fs.FS, pathPrefix string,
 	options ...StaticResourceHandlerOption) *StaticResourceHandler {
 	res := &StaticResourceHandler{
-		dir:        dir,
-		pathPrefix: pathPrefix,
-		extensionContentTypeMap: map[string]string{
-			// 这里根据自己的需要不断添加
-			"jpeg": "image/jpeg",
-			"jpe":  "image/jpeg",
-			"jpg":  "image/jpeg",
-			"png":  "image/png",
-			"pdf":  "image/pdf",
-		},
+		fs:                      fsys,
+		pathPrefix:              pathPrefix,
+		extensionContentTypeMap: map[string]string{},
+		indexFiles:              []string{"index.html"},
 	}
 
 	for _, o := range options {
@@ -158,17 +413,24 @@ func NewStaticResourceHandler(dir string, pathPrefix string,
 // maxFileSizeThreshold 超过这个大小的文件，就被认为是大文件，我们将不会缓存
 // maxCacheFileCnt 最多缓存多少个文件
 // 所以我们最多缓存 maxFileSizeThreshold * maxCacheFileCnt
+//
+// Deprecated: 文件大小差别很大的时候，按照个数限制没有意义，使用 WithFileCacheSize
 func WithFileCache(maxFileSizeThreshold int, maxCacheFileCnt int) StaticResourceHandlerOption {
+	return WithFileCacheSize(maxFileSizeThreshold, maxFileSizeThreshold*maxCacheFileCnt)
+}
+
+// WithFileCacheSize 静态文件将会被缓存
+// maxFileSize 超过这个大小的文件不会被缓存
+// maxTotalSize 缓存的文件加起来最多这么大，超过之后淘汰最久没有使用的
+func WithFileCacheSize(maxFileSize int, maxTotalSize int) StaticResourceHandlerOption {
 	return func(h *StaticResourceHandler) {
-		c, err := lru.New(maxCacheFileCnt)
-		if err != nil {
-			log.Printf("创建缓存失败，将不会缓存静态资源")
-		}
-		h.maxFileSize = maxFileSizeThreshold
-		h.cache = c
+		h.maxFileSize = maxFileSize
+		h.cache = newFileCache(maxTotalSize)
 	}
 }
 
+// WithMoreExtension 指定扩展名对应的 Content-Type，优先于 mime.TypeByExtension
+// 扩展名不需要带上 .
 func WithMoreExtension(extMap map[string]string) StaticResourceHandlerOption {
 	return func(h *StaticResourceHandler) {
 		for ext, contentType := range extMap {
@@ -177,69 +439,308 @@ func WithMoreExtension(extMap map[string]string) StaticResourceHandlerOption {
 	}
 }
 
+// WithIndexFiles 请求目录的时候，按照顺序查找这些文件，默认是 index.html
+func WithIndexFiles(names ...string) StaticResourceHandlerOption {
+	return func(h *StaticResourceHandler) {
+		h.indexFiles = names
+	}
+}
+
+// WithDirListing 目录下没有 index 文件的时候，列出目录里面的文件
+// 默认返回 404
+func WithDirListing() StaticResourceHandlerOption {
+	return func(h *StaticResourceHandler) {
+		h.listDir = true
+	}
+}
+
 func (h *StaticResourceHandler) Handle(ctx *Context) {
-	req, _ := ctx.PathValue("file").String()
-	if item, ok := h.readFileFromData(req); ok {
-		log.Printf("从缓存中读取数据...")
-		h.writeItemAsResponse(item, ctx.Resp)
+	name := h.fileName(ctx.Req.URL.Path)
+	info, err := fs.Stat(h.fs, name)
+	if err != nil {
+		h.respError(ctx, err)
 		return
 	}
-	path := filepath.Join(h.dir, req)
-	f, err := os.Open(path)
+	if info.IsDir() {
+		index, indexInfo, ok := h.findIndex(name)
+		if !ok {
+			if !h.listDir {
+				ctx.RespStatusCode = http.StatusNotFound
+				return
+			}
+			h.listDirectory(ctx, name)
+			return
+		}
+		name, info = index, indexInfo
+	}
+	h.serveFile(ctx, name, info)
+}
+
+// fileName 计算请求的文件在 fs 里面的路径，fs.FS 要求路径不能以 / 开头，根目录是 .
+func (h *StaticResourceHandler) fileName(urlPath string) string {
+	name := path.Clean("/" + strings.TrimPrefix(urlPath, h.pathPrefix))
+	if name == "/" {
+		return "."
+	}
+	return name[1:]
+}
+
+func (h *StaticResourceHandler) findIndex(dir string) (string, fs.FileInfo, bool) {
+	for _, index := range h.indexFiles {
+		name := path.Join(dir, index)
+		if info, err := fs.Stat(h.fs, name); err == nil && !info.IsDir() {
+			return name, info, true
+		}
+	}
+	return "", nil, false
+}
+
+func (h *StaticResourceHandler) serveFile(ctx *Context, name string, info fs.FileInfo) {
+	header := ctx.Resp.Header()
+	contentType := h.contentType(name)
+	fileName, encoding, variantInfo, hasVariant := h.negotiate(ctx.Req, name)
+	if hasVariant {
+		header.Add("Vary", "Accept-Encoding")
+	}
+	if encoding != "" {
+		info = variantInfo
+		header.Set("Content-Encoding", encoding)
+		// 压缩过的内容没办法再根据内容判断类型
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+	}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+
+	if h.cache != nil && info.Size() < int64(h.maxFileSize) {
+		item, err := h.readFile(fileName, info)
+		if err != nil {
+			h.respError(ctx, err)
+			return
+		}
+		ctx.SetETag(item.etag)
+		// ServeContent 会处理 Range 和条件请求
+		http.ServeContent(ctx.Resp, ctx.Req, name, item.modTime, bytes.NewReader(item.data))
+		return
+	}
+
+	// 大文件直接从 fs 里面读，不放进内存
+	f, err := h.fs.Open(fileName)
 	if err != nil {
-		ctx.Resp.WriteHeader(http.StatusInternalServerError)
+		h.respError(ctx, err)
 		return
 	}
-	ext := getFileExt(f.Name())
-	t, ok := h.extensionContentTypeMap[ext]
+	defer f.Close()
+	content, ok := f.(io.ReadSeeker)
 	if !ok {
-		ctx.Resp.WriteHeader(http.StatusBadRequest)
+		// fs.File 不一定支持 Seek，那就只能读进来了
+		data, err := io.ReadAll(f)
+		if err != nil {
+			h.respError(ctx, err)
+			return
+		}
+		content = bytes.NewReader(data)
+	}
+	etag, err := fileETag(content, info)
+	if err != nil {
+		h.respError(ctx, err)
 		return
 	}
+	ctx.SetETag(etag)
+	http.ServeContent(ctx.Resp, ctx.Req, name, info.ModTime(), content)
+}
+
+// fileETag 根据修改时间和大小计算 ETag
+// embed.FS 里面的文件没有修改时间，只能根据内容计算
+func fileETag(content io.ReadSeeker, info fs.FileInfo) (string, error) {
+	if !info.ModTime().IsZero() {
+		return fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()), nil
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, content); err != nil {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`, nil
+}
+
+// negotiate 根据 Accept-Encoding 选择预先压缩好的文件
+// hasVariant 代表存在压缩过的文件，那么响应需要加上 Vary
+func (h *StaticResourceHandler) negotiate(req *http.Request,
+	name string) (fileName string, encoding string, info fs.FileInfo, hasVariant bool) {
+	variants := []struct {
+		encoding string
+		ext      string
+	}{
+		{encoding: "br", ext: ".br"},
+		{encoding: "gzip", ext: ".gz"},
+	}
+	acceptEncoding := req.Header.Get("Accept-Encoding")
+	for _, v := range variants {
+		variantInfo, err := fs.Stat(h.fs, name+v.ext)
+		if err != nil || variantInfo.IsDir() {
+			continue
+		}
+		hasVariant = true
+		if acceptsEncoding(acceptEncoding, v.encoding) {
+			return name + v.ext, v.encoding, variantInfo, true
+		}
+	}
+	return name, "", nil, hasVariant
+}
+
+// acceptsEncoding 判断 Accept-Encoding 里面是否接受 encoding，q=0 代表不接受
+func acceptsEncoding(acceptEncoding string, encoding string) bool {
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), encoding) {
+			continue
+		}
+		params = strings.ReplaceAll(params, " ", "")
+		if !strings.HasPrefix(params, "q=") {
+			return true
+		}
+		val, err := strconv.ParseFloat(strings.TrimPrefix(params, "q="), 64)
+		return err == nil && val > 0
+	}
+	return false
+}
 
-	data, err := ioutil.ReadAll(f)
+func (h *StaticResourceHandler) contentType(name string) string {
+	ext := path.Ext(name)
+	if t, ok := h.extensionContentTypeMap[strings.TrimPrefix(ext, ".")]; ok {
+		return t
+	}
+	// 为空的时候 ServeContent 会根据文件内容判断
+	return mime.TypeByExtension(ext)
+}
+
+// readFile 读取文件并且缓存起来，缓存里面的文件被修改过的话会重新读取
+func (h *StaticResourceHandler) readFile(name string, info fs.FileInfo) (*fileCacheItem, error) {
+	if item, ok := h.readFileFromData(name); ok &&
+		item.modTime.Equal(info.ModTime()) && int64(item.fileSize) == info.Size() {
+		return item, nil
+	}
+	data, err := fs.ReadFile(h.fs, name)
 	if err != nil {
-		ctx.Resp.WriteHeader(http.StatusInternalServerError)
-		return
+		return nil, err
 	}
+	sum := sha256.Sum256(data)
 	item := &fileCacheItem{
-		fileSize:    len(data),
-		data:        data,
-		contentType: t,
-		fileName:    req,
+		fileSize: len(data),
+		data:     data,
+		fileName: name,
+		etag:     `"` + hex.EncodeToString(sum[:16]) + `"`,
+		modTime:  info.ModTime(),
 	}
-
 	h.cacheFile(item)
-	h.writeItemAsResponse(item, ctx.Resp)
+	return item, nil
 }
 
-func (h *StaticResourceHandler) cacheFile(item *fileCacheItem) {
-	if h.cache != nil && item.fileSize < h.maxFileSize {
-		h.cache.Add(item.fileName, item)
+func (h *StaticResourceHandler) listDirectory(ctx *Context, name string) {
+	entries, err := fs.ReadDir(h.fs, name)
+	if err != nil {
+		h.respError(ctx, err)
+		return
+	}
+	base := ctx.Req.URL.Path
+	if !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+	buf := &bytes.Buffer{}
+	buf.WriteString("<!DOCTYPE html>\n<html>\n<body>\n<pre>\n")
+	if name != "." {
+		buf.WriteString("<a href=\"../\">../</a>\n")
 	}
+	for _, entry := range entries {
+		entryName := entry.Name()
+		if entry.IsDir() {
+			entryName += "/"
+		}
+		href := (&url.URL{Path: base + entryName}).EscapedPath()
+		fmt.Fprintf(buf, "<a href=\"%s\">%s</a>\n", html.EscapeString(href), html.EscapeString(entryName))
+	}
+	buf.WriteString("</pre>\n</body>\n</html>\n")
+	ctx.Resp.Header().Set("Content-Type", "text/html; charset=utf-8")
+	ctx.RespStatusCode = http.StatusOK
+	ctx.RespData = buf.Bytes()
 }
 
-func (h *StaticResourceHandler) writeItemAsResponse(item *fileCacheItem, writer http.ResponseWriter) {
-	writer.WriteHeader(http.StatusOK)
-	writer.Header().Set("Content-Type", item.contentType)
-	writer.Header().Set("Content-Length", fmt.Sprintf("%d", item.fileSize))
-	_, _ = writer.Write(item.data)
+func (h *StaticResourceHandler) respError(ctx *Context, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist), errors.Is(err, fs.ErrInvalid):
+		ctx.RespStatusCode = http.StatusNotFound
+	case errors.Is(err, fs.ErrPermission):
+		ctx.RespStatusCode = http.StatusForbidden
+	default:
+		ctx.RespStatusCode = http.StatusInternalServerError
+		ctx.RespData = []byte("服务器错误")
+	}
+}
 
+func (h *StaticResourceHandler) cacheFile(item *fileCacheItem) {
+	if h.cache != nil && item.fileSize < h.maxFileSize {
+		h.cache.add(item)
+	}
 }
 
 func (h *StaticResourceHandler) readFileFromData(fileName string) (*fileCacheItem, bool) {
 	if h.cache != nil {
-		if item, ok := h.cache.Get(fileName); ok {
-			return item.(*fileCacheItem), true
-		}
+		return h.cache.get(fileName)
 	}
 	return nil, false
 }
 
-func getFileExt(name string) string {
-	index := strings.LastIndex(name, ".")
-	if index == len(name)-1 {
-		return ""
+// fileCache 按照总字节数限制大小的 LRU 缓存
+type fileCache struct {
+	mutex    sync.Mutex
+	maxSize  int
+	size     int
+	items    map[string]*list.Element
+	lruItems *list.List
+}
+
+func newFileCache(maxSize int) *fileCache {
+	return &fileCache{
+		maxSize:  maxSize,
+		items:    make(map[string]*list.Element, 16),
+		lruItems: list.New(),
 	}
-	return name[index+1:]
+}
+
+func (c *fileCache) get(name string) (*fileCacheItem, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	elem, ok := c.items[name]
+	if !ok {
+		return nil, false
+	}
+	c.lruItems.MoveToFront(elem)
+	return elem.Value.(*fileCacheItem), true
+}
+
+func (c *fileCache) add(item *fileCacheItem) {
+	if item.fileSize > c.maxSize {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if elem, ok := c.items[item.fileName]; ok {
+		c.removeElement(elem)
+	}
+	c.items[item.fileName] = c.lruItems.PushFront(item)
+	c.size += item.fileSize
+	for c.size > c.maxSize {
+		c.removeElement(c.lruItems.Back())
+	}
+}
+
+func (c *fileCache) removeElement(elem *list.Element) {
+	item := c.lruItems.Remove(elem).(*fileCacheItem)
+	delete(c.items, item.fileName)
+	c.size -= item.fileSize
 }
@@ -2,10 +2,24 @@ package v9
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"html/template"
+	"io"
+	"mime"
 	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
 	"path"
+	"path/filepath"
 	"testing"
+	"testing/fstest"
+	"time"
 )
 
 func TestFileUploader_Handle(t *testing.T) {
@@ -46,6 +60,192 @@ func TestFileUploader_Handle(t *testing.T) {
 	s.Start(":8081")
 }
 
+func TestFileUploader_HandleFunc(t *testing.T) {
+	png := append([]byte("\x89PNG\x0D\x0A\x1A\x0A"), make([]byte, 64)...)
+	type file struct {
+		field string
+		name  string
+		data  []byte
+	}
+	testCases := []struct {
+		name     string
+		uploader *FileUploader
+		files    []file
+		// existing 上传之前就已经存在的文件
+		existing map[string][]byte
+
+		wantCode  int
+		wantRes   uploadResult
+		wantSaved map[string][]byte
+	}{
+		{
+			name:     "multiple files",
+			uploader: &FileUploader{FileField: "myfile"},
+			files: []file{
+				{field: "myfile", name: "a.txt", data: []byte("hello")},
+				{field: "myfile", name: "b.png", data: png},
+			},
+			wantCode: http.StatusOK,
+			wantRes: uploadResult{Files: []UploadedFile{
+				{Name: "a.txt", Size: 5, ContentType: "text/plain; charset=utf-8"},
+				{Name: "b.png", Size: int64(len(png)), ContentType: "image/png"},
+			}},
+			wantSaved: map[string][]byte{"a.txt": []byte("hello"), "b.png": png},
+		},
+		{
+			name: "dst path func",
+			uploader: &FileUploader{
+				FileField: "myfile",
+				DstPathFunc: func(fh *multipart.FileHeader) string {
+					return path.Join("avatar", fh.Filename)
+				},
+			},
+			// 目录部分会被去掉
+			files:    []file{{field: "myfile", name: "../../etc/a.txt", data: []byte("hello")}},
+			wantCode: http.StatusOK,
+			wantRes: uploadResult{Files: []UploadedFile{
+				{Name: "a.txt", Size: 5, ContentType: "text/plain; charset=utf-8"},
+			}},
+			wantSaved: map[string][]byte{"avatar/a.txt": []byte("hello")},
+		},
+		{
+			name:     "no file",
+			uploader: &FileUploader{FileField: "myfile"},
+			files:    []file{{field: "other", name: "a.txt", data: []byte("hello")}},
+			wantCode: http.StatusBadRequest,
+			wantRes:  uploadResult{Error: "上传失败，未找到数据"},
+		},
+		{
+			name:     "request too large",
+			uploader: &FileUploader{FileField: "myfile", MaxRequestSize: 100},
+			files:    []file{{field: "myfile", name: "a.txt", data: bytes.Repeat([]byte("a"), 200)}},
+			wantCode: http.StatusRequestEntityTooLarge,
+			wantRes:  uploadResult{Error: "上传失败，请求太大"},
+		},
+		{
+			name:     "file too large",
+			uploader: &FileUploader{FileField: "myfile", MaxFileSize: 10},
+			files:    []file{{field: "myfile", name: "a.txt", data: bytes.Repeat([]byte("a"), 11)}},
+			wantCode: http.StatusRequestEntityTooLarge,
+			wantRes:  uploadResult{Error: "上传失败，文件 a.txt 太大"},
+		},
+		{
+			name:     "allowed content type",
+			uploader: &FileUploader{FileField: "myfile", AllowedContentTypes: []string{"image/*"}},
+			// 扩展名是假的，按照内容来判断
+			files:    []file{{field: "myfile", name: "a.txt", data: png}},
+			wantCode: http.StatusOK,
+			wantRes: uploadResult{Files: []UploadedFile{
+				{Name: "a.txt", Size: int64(len(png)), ContentType: "image/png"},
+			}},
+			wantSaved: map[string][]byte{"a.txt": png},
+		},
+		{
+			name:     "disallowed content type",
+			uploader: &FileUploader{FileField: "myfile", AllowedContentTypes: []string{"image/png"}},
+			files:    []file{{field: "myfile", name: "a.png", data: []byte("hello")}},
+			wantCode: http.StatusUnsupportedMediaType,
+			wantRes:  uploadResult{Error: "上传失败，不支持的文件类型 text/plain; charset=utf-8"},
+		},
+		{
+			name:     "rollback",
+			uploader: &FileUploader{FileField: "myfile", AllowedContentTypes: []string{"text/plain"}},
+			// 第二个文件失败了，第一个文件也不保存，原本的同名文件不受影响
+			files: []file{
+				{field: "myfile", name: "a.txt", data: []byte("hello")},
+				{field: "myfile", name: "b.png", data: png},
+			},
+			existing:  map[string][]byte{"a.txt": []byte("old")},
+			wantCode:  http.StatusUnsupportedMediaType,
+			wantRes:   uploadResult{Error: "上传失败，不支持的文件类型 image/png"},
+			wantSaved: map[string][]byte{"a.txt": []byte("old")},
+		},
+		{
+			name:     "overwrite",
+			uploader: &FileUploader{FileField: "myfile"},
+			files:    []file{{field: "myfile", name: "a.txt", data: []byte("hello")}},
+			existing: map[string][]byte{"a.txt": []byte("old")},
+			wantCode: http.StatusOK,
+			wantRes: uploadResult{Files: []UploadedFile{
+				{Name: "a.txt", Size: 5, ContentType: "text/plain; charset=utf-8"},
+			}},
+			wantSaved: map[string][]byte{"a.txt": []byte("hello")},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			storage := NewMemoryFileStorage()
+			for name, data := range tc.existing {
+				_, err := storage.Save(context.Background(), name, bytes.NewReader(data))
+				require.NoError(t, err)
+			}
+			tc.uploader.Storage = storage
+			s := NewHTTPServer()
+			s.Post("/upload", tc.uploader.Handle())
+
+			body := &bytes.Buffer{}
+			writer := multipart.NewWriter(body)
+			for _, f := range tc.files {
+				w, err := writer.CreateFormFile(f.field, f.name)
+				require.NoError(t, err)
+				_, err = w.Write(f.data)
+				require.NoError(t, err)
+			}
+			require.NoError(t, writer.Close())
+			req := httptest.NewRequest(http.MethodPost, "/upload", body)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, req)
+
+			assert.Equal(t, tc.wantCode, recorder.Code)
+			assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+			var res uploadResult
+			require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &res))
+			assert.Equal(t, tc.wantRes, res)
+			assert.Equal(t, len(tc.wantSaved), len(storage.files))
+			for name, data := range tc.wantSaved {
+				saved, ok := storage.Get(name)
+				require.True(t, ok)
+				assert.Equal(t, data, saved)
+			}
+		})
+	}
+}
+
+// TestFileUploader_DstPath 没有设置 Storage 的时候，DstPathFunc 返回的就是本地磁盘上的路径
+func TestFileUploader_DstPath(t *testing.T) {
+	dir := t.TempDir()
+	s := NewHTTPServer()
+	s.Post("/upload", (&FileUploader{
+		FileField: "myfile",
+		DstPathFunc: func(fh *multipart.FileHeader) string {
+			return filepath.Join(dir, "avatar", fh.Filename)
+		},
+	}).Handle())
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	w, err := writer.CreateFormFile("myfile", "a.txt")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	req := httptest.NewRequest(http.MethodPost, "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	data, err := os.ReadFile(filepath.Join(dir, "avatar", "a.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+	// 临时文件已经被重命名了
+	entries, err := os.ReadDir(filepath.Join(dir, "avatar"))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
 func TestFileDownloader_Handle(t *testing.T) {
 	s := NewHTTPServer()
 	s.Get("/download", (&FileDownloader{
@@ -56,6 +256,180 @@ func TestFileDownloader_Handle(t *testing.T) {
 	s.Start(":8081")
 }
 
+func TestFileDownloader_Download(t *testing.T) {
+	outside := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0o644))
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "test.txt"), []byte("hello, world"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "报告 v1.pdf"), []byte("%PDF-1.4"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "data"), []byte("<html></html>"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "private.txt"), []byte("private"), 0o644))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "sub"), 0o755))
+	require.NoError(t, os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(dir, "link.txt")))
+
+	testCases := []struct {
+		name   string
+		inline bool
+		file   string
+		header map[string]string
+
+		wantCode        int
+		wantBody        string
+		wantType        string
+		wantDisposition string
+		wantRange       string
+	}{
+		{
+			name:            "download",
+			file:            "test.txt",
+			wantCode:        http.StatusOK,
+			wantBody:        "hello, world",
+			wantType:        "text/plain; charset=utf-8",
+			wantDisposition: `attachment; filename="test.txt"`,
+		},
+		{
+			name:            "inline",
+			inline:          true,
+			file:            "test.txt",
+			wantCode:        http.StatusOK,
+			wantBody:        "hello, world",
+			wantType:        "text/plain; charset=utf-8",
+			wantDisposition: `inline; filename="test.txt"`,
+		},
+		{
+			name:            "non ascii",
+			file:            "报告 v1.pdf",
+			wantCode:        http.StatusOK,
+			wantBody:        "%PDF-1.4",
+			wantType:        "application/pdf",
+			wantDisposition: `attachment; filename="__ v1.pdf"; filename*=UTF-8''%E6%8A%A5%E5%91%8A%20v1.pdf`,
+		},
+		{
+			// 没有扩展名，根据内容判断
+			name:            "sniff",
+			file:            "data",
+			wantCode:        http.StatusOK,
+			wantBody:        "<html></html>",
+			wantType:        "text/html; charset=utf-8",
+			wantDisposition: `attachment; filename="data"`,
+		},
+		{
+			name:     "no file",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "not found",
+			file:     "not_exist.txt",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "dir",
+			file:     "sub",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "traversal",
+			file:     "../" + filepath.Base(outside) + "/secret.txt",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "symlink outside",
+			file:     "link.txt",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "unauthorized",
+			file:     "private.txt",
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:            "range",
+			file:            "test.txt",
+			header:          map[string]string{"Range": "bytes=7-"},
+			wantCode:        http.StatusPartialContent,
+			wantBody:        "world",
+			wantType:        "text/plain; charset=utf-8",
+			wantRange:       "bytes 7-11/12",
+			wantDisposition: `attachment; filename="test.txt"`,
+		},
+		{
+			name:            "range not satisfiable",
+			file:            "test.txt",
+			header:          map[string]string{"Range": "bytes=100-"},
+			wantCode:        http.StatusRequestedRangeNotSatisfiable,
+			wantDisposition: `attachment; filename="test.txt"`,
+			wantRange:       "bytes */12",
+		},
+		{
+			name:            "not modified",
+			file:            "test.txt",
+			header:          map[string]string{"If-Modified-Since": time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)},
+			wantCode:        http.StatusNotModified,
+			wantDisposition: `attachment; filename="test.txt"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewHTTPServer()
+			s.Get("/download", (&FileDownloader{
+				Dir:    dir,
+				Inline: tc.inline,
+				Authorize: func(ctx *Context, name string) bool {
+					return name != "private.txt"
+				},
+			}).Handle())
+			req := httptest.NewRequest(http.MethodGet, "/download?file="+url.QueryEscape(tc.file), nil)
+			for k, v := range tc.header {
+				req.Header.Set(k, v)
+			}
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, req)
+			assert.Equal(t, tc.wantCode, recorder.Code)
+			if tc.wantBody != "" {
+				assert.Equal(t, tc.wantBody, recorder.Body.String())
+				assert.Equal(t, tc.wantType, recorder.Header().Get("Content-Type"))
+			}
+			assert.Equal(t, tc.wantDisposition, recorder.Header().Get("Content-Disposition"))
+			assert.Equal(t, tc.wantRange, recorder.Header().Get("Content-Range"))
+		})
+	}
+}
+
+func TestFileDownloader_MultiRange(t *testing.T) {
+	s := NewHTTPServer()
+	s.Get("/download", (&FileDownloader{Dir: "./testdata/download"}).Handle())
+	req := httptest.NewRequest(http.MethodGet, "/download?file=test.txt", nil)
+	req.Header.Set("Range", "bytes=0-1,4-5")
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusPartialContent, recorder.Code)
+
+	data, err := os.ReadFile("./testdata/download/test.txt")
+	require.NoError(t, err)
+	mediaType, params, err := mime.ParseMediaType(recorder.Header().Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/byteranges", mediaType)
+	reader := multipart.NewReader(recorder.Body, params["boundary"])
+	wants := []struct {
+		contentRange string
+		data         []byte
+	}{
+		{contentRange: fmt.Sprintf("bytes 0-1/%d", len(data)), data: data[0:2]},
+		{contentRange: fmt.Sprintf("bytes 4-5/%d", len(data)), data: data[4:6]},
+	}
+	for _, want := range wants {
+		part, err := reader.NextPart()
+		require.NoError(t, err)
+		assert.Equal(t, want.contentRange, part.Header.Get("Content-Range"))
+		got, err := io.ReadAll(part)
+		require.NoError(t, err)
+		assert.Equal(t, want.data, got)
+	}
+	_, err = reader.NextPart()
+	assert.Equal(t, io.EOF, err)
+}
+
 func TestStaticResourceHandler_Handle(t *testing.T) {
 	s := NewHTTPServer()
 	handler := NewStaticResourceHandler("./testdata/img", "/img")
@@ -63,3 +437,265 @@ func TestStaticResourceHandler_Handle(t *testing.T) {
 	// 在浏览器里面输入 localhost:8081/img/come_on_baby.jpg
 	s.Start(":8081")
 }
+
+func TestStaticResourceHandler_Conditional(t *testing.T) {
+	s := NewHTTPServer()
+	handler := NewStaticResourceHandler("./testdata/img", "/img",
+		WithFileCache(1024*1024, 10))
+	s.Get("/img/:file", handler.Handle)
+
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/img/come_on_baby.jpg", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "image/jpeg", recorder.Header().Get("Content-Type"))
+	etag := recorder.Header().Get("ETag")
+	lastModified := recorder.Header().Get("Last-Modified")
+	require.NotEmpty(t, etag)
+	require.NotEmpty(t, lastModified)
+
+	// 第二次走缓存
+	req := httptest.NewRequest(http.MethodGet, "/img/come_on_baby.jpg", nil)
+	req.Header.Set("If-None-Match", etag)
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusNotModified, recorder.Code)
+	assert.Equal(t, 0, recorder.Body.Len())
+
+	req = httptest.NewRequest(http.MethodGet, "/img/come_on_baby.jpg", nil)
+	req.Header.Set("If-Modified-Since", lastModified)
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusNotModified, recorder.Code)
+}
+
+func TestStaticResourceHandler_FS(t *testing.T) {
+	modTime := time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"index.html":      {Data: []byte("<html>home</html>"), ModTime: modTime},
+		"css/app.css":     {Data: []byte("body{}"), ModTime: modTime},
+		"css/app.css.gz":  {Data: []byte("gzip"), ModTime: modTime},
+		"css/app.css.br":  {Data: []byte("br"), ModTime: modTime},
+		"docs/a.pdf":      {Data: []byte("%PDF-1.4"), ModTime: modTime},
+		"docs/sub/b.txt":  {Data: []byte("b"), ModTime: modTime},
+		"docs/readme":     {Data: []byte("<html></html>"), ModTime: modTime},
+		"js/index.js.map": {Data: []byte("{}")},
+	}
+	testCases := []struct {
+		name   string
+		path   string
+		header map[string]string
+
+		wantCode     int
+		wantBody     string
+		wantType     string
+		wantEncoding string
+		wantVary     string
+	}{
+		{
+			name:     "index",
+			path:     "/static/",
+			wantCode: http.StatusOK,
+			wantBody: "<html>home</html>",
+			wantType: "text/html; charset=utf-8",
+		},
+		{
+			name:     "identity",
+			path:     "/static/css/app.css",
+			wantCode: http.StatusOK,
+			wantBody: "body{}",
+			wantType: "text/css; charset=utf-8",
+			wantVary: "Accept-Encoding",
+		},
+		{
+			name:         "gzip",
+			path:         "/static/css/app.css",
+			header:       map[string]string{"Accept-Encoding": "gzip, deflate"},
+			wantCode:     http.StatusOK,
+			wantBody:     "gzip",
+			wantType:     "text/css; charset=utf-8",
+			wantEncoding: "gzip",
+			wantVary:     "Accept-Encoding",
+		},
+		{
+			name:         "br",
+			path:         "/static/css/app.css",
+			header:       map[string]string{"Accept-Encoding": "gzip, br"},
+			wantCode:     http.StatusOK,
+			wantBody:     "br",
+			wantType:     "text/css; charset=utf-8",
+			wantEncoding: "br",
+			wantVary:     "Accept-Encoding",
+		},
+		{
+			name:         "br not acceptable",
+			path:         "/static/css/app.css",
+			header:       map[string]string{"Accept-Encoding": "br;q=0, gzip;q=0.5"},
+			wantCode:     http.StatusOK,
+			wantBody:     "gzip",
+			wantType:     "text/css; charset=utf-8",
+			wantEncoding: "gzip",
+			wantVary:     "Accept-Encoding",
+		},
+		{
+			name:     "pdf",
+			path:     "/static/docs/a.pdf",
+			wantCode: http.StatusOK,
+			wantBody: "%PDF-1.4",
+			wantType: "application/pdf",
+		},
+		{
+			name:     "sniff",
+			path:     "/static/docs/readme",
+			wantCode: http.StatusOK,
+			wantBody: "<html></html>",
+			wantType: "text/html; charset=utf-8",
+		},
+		{
+			name:     "nested",
+			path:     "/static/docs/sub/b.txt",
+			wantCode: http.StatusOK,
+			wantBody: "b",
+			wantType: "text/plain; charset=utf-8",
+		},
+		{
+			name:     "not found",
+			path:     "/static/missing.js",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "dir without index",
+			path:     "/static/docs",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			// 不能跳出根目录
+			name:     "traversal",
+			path:     "/static/../../index.html",
+			wantCode: http.StatusOK,
+			wantBody: "<html>home</html>",
+			wantType: "text/html; charset=utf-8",
+		},
+		{
+			name:     "range",
+			path:     "/static/css/app.css",
+			header:   map[string]string{"Range": "bytes=0-1"},
+			wantCode: http.StatusPartialContent,
+			wantBody: "bo",
+			wantType: "text/css; charset=utf-8",
+			wantVary: "Accept-Encoding",
+		},
+		{
+			name:     "not modified",
+			path:     "/static/docs/a.pdf",
+			header:   map[string]string{"If-Modified-Since": modTime.Format(http.TimeFormat)},
+			wantCode: http.StatusNotModified,
+		},
+	}
+
+	for _, cached := range []bool{false, true} {
+		opts := []StaticResourceHandlerOption{WithMoreExtension(map[string]string{"map": "application/json"})}
+		if cached {
+			opts = append(opts, WithFileCacheSize(1024, 4096))
+		}
+		h := NewStaticResourceHandlerFS(fsys, "/static", opts...)
+		for _, tc := range testCases {
+			t.Run(fmt.Sprintf("%s cached %v", tc.name, cached), func(t *testing.T) {
+				req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+				for k, v := range tc.header {
+					req.Header.Set(k, v)
+				}
+				recorder := serveStatic(h, req)
+				assert.Equal(t, tc.wantCode, recorder.Code)
+				assert.Equal(t, tc.wantBody, recorder.Body.String())
+				assert.Equal(t, tc.wantEncoding, recorder.Header().Get("Content-Encoding"))
+				assert.Equal(t, tc.wantVary, recorder.Header().Get("Vary"))
+				if tc.wantType != "" {
+					assert.Equal(t, tc.wantType, recorder.Header().Get("Content-Type"))
+				}
+			})
+		}
+	}
+
+	// 扩展名映射，以及没有修改时间的文件根据内容计算 ETag
+	h := NewStaticResourceHandlerFS(fsys, "/static", WithMoreExtension(map[string]string{"map": "application/json"}))
+	recorder := serveStatic(h, httptest.NewRequest(http.MethodGet, "/static/js/index.js.map", nil))
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	etag := recorder.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+	req := httptest.NewRequest(http.MethodGet, "/static/js/index.js.map", nil)
+	req.Header.Set("If-None-Match", etag)
+	assert.Equal(t, http.StatusNotModified, serveStatic(h, req).Code)
+}
+
+func TestStaticResourceHandler_DirListing(t *testing.T) {
+	fsys := fstest.MapFS{
+		"docs/a b.pdf":   {Data: []byte("%PDF-1.4")},
+		"docs/<x>.txt":   {Data: []byte("x")},
+		"docs/sub/b.txt": {Data: []byte("b")},
+	}
+	h := NewStaticResourceHandlerFS(fsys, "/static", WithDirListing())
+	recorder := serveStatic(h, httptest.NewRequest(http.MethodGet, "/static/docs", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "text/html; charset=utf-8", recorder.Header().Get("Content-Type"))
+	body := recorder.Body.String()
+	assert.Contains(t, body, `<a href="../">../</a>`)
+	assert.Contains(t, body, `<a href="/static/docs/%3Cx%3E.txt">&lt;x&gt;.txt</a>`)
+	assert.Contains(t, body, `<a href="/static/docs/a%20b.pdf">a b.pdf</a>`)
+	assert.Contains(t, body, `<a href="/static/docs/sub/">sub/</a>`)
+
+	// 根目录没有上一级
+	recorder = serveStatic(h, httptest.NewRequest(http.MethodGet, "/static/", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	assert.NotContains(t, recorder.Body.String(), "../")
+}
+
+func TestStaticResourceHandler_CacheInvalidation(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.txt": {Data: []byte("old"), ModTime: time.Now().Add(-time.Hour)},
+	}
+	h := NewStaticResourceHandlerFS(fsys, "/static", WithFileCacheSize(1024, 4096))
+	recorder := serveStatic(h, httptest.NewRequest(http.MethodGet, "/static/a.txt", nil))
+	assert.Equal(t, "old", recorder.Body.String())
+
+	// 文件被修改之后，缓存失效
+	fsys["a.txt"] = &fstest.MapFile{Data: []byte("new!"), ModTime: time.Now()}
+	recorder = serveStatic(h, httptest.NewRequest(http.MethodGet, "/static/a.txt", nil))
+	assert.Equal(t, "new!", recorder.Body.String())
+}
+
+func TestFileCache(t *testing.T) {
+	c := newFileCache(10)
+	newItem := func(name string, size int) *fileCacheItem {
+		return &fileCacheItem{fileName: name, fileSize: size, data: make([]byte, size)}
+	}
+	c.add(newItem("a", 4))
+	c.add(newItem("b", 4))
+	// a 最近被访问过，所以淘汰 b
+	_, ok := c.get("a")
+	require.True(t, ok)
+	c.add(newItem("c", 4))
+	_, ok = c.get("b")
+	assert.False(t, ok)
+	_, ok = c.get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 8, c.size)
+
+	// 覆盖已有的
+	c.add(newItem("a", 2))
+	assert.Equal(t, 6, c.size)
+
+	// 太大了，不缓存
+	c.add(newItem("d", 11))
+	_, ok = c.get("d")
+	assert.False(t, ok)
+	assert.Equal(t, 6, c.size)
+}
+
+// serveStatic 路由不支持多段的通配符，所以直接调用 Handle
+func serveStatic(h *StaticResourceHandler, req *http.Request) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	ctx := &Context{Req: req, Resp: recorder}
+	h.Handle(ctx)
+	(&HTTPServer{}).flashResp(ctx)
+	return recorder
+}
@@ -0,0 +1,113 @@
+package v9
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CacheControl 描述了 Cache-Control 响应头
+// 零值代表什么都不设置
+type CacheControl struct {
+	// MaxAge 为 0 的时候不会输出 max-age
+	MaxAge time.Duration
+	// MaxAgeZero 输出 max-age=0，也就是响应立刻过期，此时忽略 MaxAge
+	MaxAgeZero bool
+	// SMaxAge 是给 CDN 之类的共享缓存使用的
+	SMaxAge time.Duration
+	// SMaxAgeZero 输出 s-maxage=0，此时忽略 SMaxAge
+	SMaxAgeZero    bool
+	Public         bool
+	Private        bool
+	NoCache        bool
+	NoStore        bool
+	MustRevalidate bool
+	// Immutable 用于那些文件名里面带了 hash 的静态资源
+	Immutable bool
+}
+
+func (c CacheControl) String() string {
+	directives := make([]string, 0, 8)
+	if c.Public {
+		directives = append(directives, "public")
+	}
+	if c.Private {
+		directives = append(directives, "private")
+	}
+	if c.NoCache {
+		directives = append(directives, "no-cache")
+	}
+	if c.NoStore {
+		directives = append(directives, "no-store")
+	}
+	if c.MaxAgeZero {
+		directives = append(directives, "max-age=0")
+	} else if c.MaxAge > 0 {
+		directives = append(directives, "max-age="+strconv.FormatInt(int64(c.MaxAge/time.Second), 10))
+	}
+	if c.SMaxAgeZero {
+		directives = append(directives, "s-maxage=0")
+	} else if c.SMaxAge > 0 {
+		directives = append(directives, "s-maxage="+strconv.FormatInt(int64(c.SMaxAge/time.Second), 10))
+	}
+	if c.MustRevalidate {
+		directives = append(directives, "must-revalidate")
+	}
+	if c.Immutable {
+		directives = append(directives, "immutable")
+	}
+	return strings.Join(directives, ", ")
+}
+
+// SetCacheControl 设置 Cache-Control 响应头
+func (c *Context) SetCacheControl(cc CacheControl) {
+	c.Resp.Header().Set("Cache-Control", cc.String())
+}
+
+// SetLastModified 设置 Last-Modified 响应头
+// HTTP 的时间只精确到秒，所以这里会截断
+func (c *Context) SetLastModified(t time.Time) {
+	if t.IsZero() {
+		return
+	}
+	c.Resp.Header().Set("Last-Modified", t.UTC().Format(http.TimeFormat))
+}
+
+// SetETag 设置 ETag 响应头，etag 需要带上双引号，弱 ETag 需要带上 W/ 前缀
+func (c *Context) SetETag(etag string) {
+	c.Resp.Header().Set("ETag", etag)
+}
+
+// NotModified 判断请求是否命中了客户端的缓存。
+// 按照 RFC 7232 的规定，If-None-Match 优先于 If-Modified-Since
+// 并且只有 GET 和 HEAD 请求才会返回 true
+func NotModified(req *http.Request, etag string, lastModified time.Time) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		return etag != "" && etagWeakMatch(inm, etag)
+	}
+	ims := req.Header.Get("If-Modified-Since")
+	if ims == "" || lastModified.IsZero() {
+		return false
+	}
+	t, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	return !lastModified.Truncate(time.Second).After(t)
+}
+
+// etagWeakMatch 按照弱比较的规则判断 etag 是否在 If-None-Match 的列表里面
+func etagWeakMatch(inm string, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(inm, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
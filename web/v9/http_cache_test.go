@@ -0,0 +1,136 @@
+package v9
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCacheControl_String(t *testing.T) {
+	testCases := []struct {
+		name string
+		cc   CacheControl
+		want string
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "public max age",
+			cc:   CacheControl{Public: true, MaxAge: time.Hour, SMaxAge: time.Minute},
+			want: "public, max-age=3600, s-maxage=60",
+		},
+		{
+			name: "immutable",
+			cc:   CacheControl{Public: true, MaxAge: 365 * 24 * time.Hour, Immutable: true},
+			want: "public, max-age=31536000, immutable",
+		},
+		{
+			name: "max age zero",
+			cc:   CacheControl{Public: true, MaxAge: time.Hour, MaxAgeZero: true, SMaxAgeZero: true},
+			want: "public, max-age=0, s-maxage=0",
+		},
+		{
+			name: "no store",
+			cc:   CacheControl{Private: true, NoCache: true, NoStore: true, MustRevalidate: true},
+			want: "private, no-cache, no-store, must-revalidate",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, tc.cc.String())
+		})
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	modTime := time.Date(2023, 1, 1, 0, 0, 0, 500, time.UTC)
+	testCases := []struct {
+		name         string
+		method       string
+		header       map[string]string
+		etag         string
+		lastModified time.Time
+		want         bool
+	}{
+		{
+			name:   "no condition",
+			method: http.MethodGet,
+			etag:   `"abc"`,
+		},
+		{
+			name:   "etag match",
+			method: http.MethodGet,
+			header: map[string]string{"If-None-Match": `"abc"`},
+			etag:   `"abc"`,
+			want:   true,
+		},
+		{
+			name:   "etag list weak match",
+			method: http.MethodGet,
+			header: map[string]string{"If-None-Match": `"xyz", W/"abc"`},
+			etag:   `"abc"`,
+			want:   true,
+		},
+		{
+			name:   "star",
+			method: http.MethodHead,
+			header: map[string]string{"If-None-Match": `*`},
+			etag:   `"abc"`,
+			want:   true,
+		},
+		{
+			name:   "etag mismatch",
+			method: http.MethodGet,
+			header: map[string]string{"If-None-Match": `"xyz"`},
+			etag:   `"abc"`,
+		},
+		{
+			name:   "post",
+			method: http.MethodPost,
+			header: map[string]string{"If-None-Match": `"abc"`},
+			etag:   `"abc"`,
+		},
+		{
+			// If-None-Match 优先
+			name:   "etag mismatch with if modified since",
+			method: http.MethodGet,
+			header: map[string]string{
+				"If-None-Match":     `"xyz"`,
+				"If-Modified-Since": modTime.Format(http.TimeFormat),
+			},
+			etag:         `"abc"`,
+			lastModified: modTime,
+		},
+		{
+			name:         "not modified since",
+			method:       http.MethodGet,
+			header:       map[string]string{"If-Modified-Since": modTime.Format(http.TimeFormat)},
+			lastModified: modTime,
+			want:         true,
+		},
+		{
+			name:         "modified since",
+			method:       http.MethodGet,
+			header:       map[string]string{"If-Modified-Since": modTime.Add(-time.Hour).Format(http.TimeFormat)},
+			lastModified: modTime,
+		},
+		{
+			name:         "invalid date",
+			method:       http.MethodGet,
+			header:       map[string]string{"If-Modified-Since": "yesterday"},
+			lastModified: modTime,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, "/", nil)
+			for k, v := range tc.header {
+				req.Header.Set(k, v)
+			}
+			assert.Equal(t, tc.want, NotModified(req, tc.etag, tc.lastModified))
+		})
+	}
+}
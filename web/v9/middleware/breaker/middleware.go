@@ -0,0 +1,293 @@
+package breaker
+
+import (
+	web "github.com/go-tour/web/v9"
+	"github.com/prometheus/client_golang/prometheus"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type State int
+
+const (
+	StateClosed State = iota
+	StateHalfOpen
+	StateOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateHalfOpen:
+		return "half-open"
+	case StateOpen:
+		return "open"
+	default:
+		return "unknown"
+	}
+}
+
+// MiddlewareBuilder 构造熔断的 Middleware，每一个路由都有自己独立的熔断器
+// 熔断器的状态转换是：
+// 1. closed：正常处理请求。在一个统计窗口内，失败的比例超过阈值之后进入 open
+// 2. open：直接返回 503，过了 openTimeout 之后进入 half-open
+// 3. half-open：放行 halfOpenRequests 个请求作为探测，全部成功则进入 closed，任何一个失败则回到 open
+type MiddlewareBuilder struct {
+	window           time.Duration
+	minRequests      int
+	failureRatio     float64
+	slowThreshold    time.Duration
+	openTimeout      time.Duration
+	halfOpenRequests int
+	isFailure        func(ctx *web.Context) bool
+	openHdl          web.HandleFunc
+
+	mutex sync.Mutex
+	// method + 空格 + route => 熔断器
+	breakers map[string]*breaker
+
+	stateDesc *prometheus.Desc
+}
+
+func NewMiddlewareBuilder() *MiddlewareBuilder {
+	return &MiddlewareBuilder{
+		window:           10 * time.Second,
+		minRequests:      20,
+		failureRatio:     0.5,
+		openTimeout:      5 * time.Second,
+		halfOpenRequests: 1,
+		isFailure: func(ctx *web.Context) bool {
+			return ctx.RespStatusCode >= 500
+		},
+		openHdl: func(ctx *web.Context) {
+			ctx.RespStatusCode = http.StatusServiceUnavailable
+			ctx.RespData = []byte("服务暂时不可用")
+		},
+		breakers: make(map[string]*breaker, 16),
+		stateDesc: prometheus.NewDesc("web_circuit_breaker_state",
+			"熔断器的状态，0 closed，1 half-open，2 open", []string{"method", "pattern"}, nil),
+	}
+}
+
+// Window 统计窗口，closed 状态下每过一个窗口都会重新计数
+func (b *MiddlewareBuilder) Window(window time.Duration) *MiddlewareBuilder {
+	b.window = window
+	return b
+}
+
+// Threshold 在一个窗口内，已经结束的请求至少有 minRequests 个，
+// 并且其中失败的比例达到 failureRatio 之后熔断
+func (b *MiddlewareBuilder) Threshold(minRequests int, failureRatio float64) *MiddlewareBuilder {
+	b.minRequests = minRequests
+	b.failureRatio = failureRatio
+	return b
+}
+
+// SlowThreshold 响应时间超过这个值的请求也被认为是失败的，0 代表不考虑响应时间
+func (b *MiddlewareBuilder) SlowThreshold(threshold time.Duration) *MiddlewareBuilder {
+	b.slowThreshold = threshold
+	return b
+}
+
+// OpenTimeout 熔断之后多久进入 half-open 状态
+func (b *MiddlewareBuilder) OpenTimeout(timeout time.Duration) *MiddlewareBuilder {
+	b.openTimeout = timeout
+	return b
+}
+
+// HalfOpenRequests half-open 状态下放行多少个请求用于探测
+func (b *MiddlewareBuilder) HalfOpenRequests(cnt int) *MiddlewareBuilder {
+	b.halfOpenRequests = cnt
+	return b
+}
+
+// IsFailure 判断请求是否失败，默认响应码 >= 500 就是失败
+func (b *MiddlewareBuilder) IsFailure(fn func(ctx *web.Context) bool) *MiddlewareBuilder {
+	b.isFailure = fn
+	return b
+}
+
+// OpenHandler 熔断的时候执行，默认返回 503
+func (b *MiddlewareBuilder) OpenHandler(hdl web.HandleFunc) *MiddlewareBuilder {
+	b.openHdl = hdl
+	return b
+}
+
+func (b *MiddlewareBuilder) Build() web.Middleware {
+	return func(next web.HandleFunc) web.HandleFunc {
+		return func(ctx *web.Context) {
+			// 没有命中路由的请求不需要熔断
+			if ctx.MatchedRoute == "" {
+				next(ctx)
+				return
+			}
+			cb := b.breaker(ctx.Req.Method, ctx.MatchedRoute)
+			generation, ok := cb.allow(time.Now())
+			if !ok {
+				b.openHdl(ctx)
+				return
+			}
+			start := time.Now()
+			success := false
+			defer func() {
+				// handler panic 了也算失败
+				cb.done(generation, success, time.Now())
+			}()
+			next(ctx)
+			success = !b.isFailure(ctx) &&
+				(b.slowThreshold <= 0 || time.Since(start) <= b.slowThreshold)
+		}
+	}
+}
+
+// State 返回某个路由的熔断器的状态
+func (b *MiddlewareBuilder) State(method string, route string) State {
+	b.mutex.Lock()
+	cb, ok := b.breakers[method+" "+route]
+	b.mutex.Unlock()
+	if !ok {
+		return StateClosed
+	}
+	return cb.currentState(time.Now())
+}
+
+// Describe 实现了 prometheus.Collector 接口
+// 可以通过 prometheus 中间件的 Collectors 字段注册
+func (b *MiddlewareBuilder) Describe(ch chan<- *prometheus.Desc) {
+	ch <- b.stateDesc
+}
+
+// Collect 实现了 prometheus.Collector 接口
+func (b *MiddlewareBuilder) Collect(ch chan<- prometheus.Metric) {
+	b.mutex.Lock()
+	breakers := make(map[string]*breaker, len(b.breakers))
+	for key, cb := range b.breakers {
+		breakers[key] = cb
+	}
+	b.mutex.Unlock()
+	now := time.Now()
+	for _, cb := range breakers {
+		ch <- prometheus.MustNewConstMetric(b.stateDesc, prometheus.GaugeValue,
+			float64(cb.currentState(now)), cb.method, cb.route)
+	}
+}
+
+func (b *MiddlewareBuilder) breaker(method string, route string) *breaker {
+	key := method + " " + route
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	cb, ok := b.breakers[key]
+	if !ok {
+		cb = &breaker{
+			method:  method,
+			route:   route,
+			builder: b,
+		}
+		cb.newGeneration(time.Now())
+		b.breakers[key] = cb
+	}
+	return cb
+}
+
+type breaker struct {
+	method  string
+	route   string
+	builder *MiddlewareBuilder
+
+	mutex sync.Mutex
+	state State
+	// generation 每次状态变化都会加一，
+	// 避免上一个状态下发出的请求影响到当前状态的计数
+	generation uint64
+	// closed 状态下是窗口的结束时间，open 状态下是进入 half-open 的时间
+	expiry time.Time
+
+	requests  int
+	failures  int
+	successes int
+}
+
+func (cb *breaker) allow(now time.Time) (uint64, bool) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	cb.refresh(now)
+	switch cb.state {
+	case StateOpen:
+		return cb.generation, false
+	case StateHalfOpen:
+		if cb.requests >= cb.builder.halfOpenRequests {
+			return cb.generation, false
+		}
+	}
+	cb.requests++
+	return cb.generation, true
+}
+
+func (cb *breaker) done(generation uint64, success bool, now time.Time) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	cb.refresh(now)
+	if generation != cb.generation {
+		return
+	}
+	if success {
+		cb.successes++
+		if cb.state == StateHalfOpen && cb.successes >= cb.builder.halfOpenRequests {
+			cb.setState(StateClosed, now)
+		}
+		return
+	}
+	cb.failures++
+	switch cb.state {
+	case StateHalfOpen:
+		cb.setState(StateOpen, now)
+	case StateClosed:
+		// 只统计已经结束的请求，还在处理的请求不知道结果，不能算作成功
+		completed := cb.successes + cb.failures
+		if completed >= cb.builder.minRequests &&
+			float64(cb.failures)/float64(completed) >= cb.builder.failureRatio {
+			cb.setState(StateOpen, now)
+		}
+	}
+}
+
+func (cb *breaker) currentState(now time.Time) State {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	cb.refresh(now)
+	return cb.state
+}
+
+// refresh 处理时间驱动的状态变化
+func (cb *breaker) refresh(now time.Time) {
+	switch cb.state {
+	case StateClosed:
+		if now.After(cb.expiry) {
+			cb.newGeneration(now)
+		}
+	case StateOpen:
+		if now.After(cb.expiry) {
+			cb.setState(StateHalfOpen, now)
+		}
+	}
+}
+
+func (cb *breaker) setState(state State, now time.Time) {
+	cb.state = state
+	cb.newGeneration(now)
+}
+
+func (cb *breaker) newGeneration(now time.Time) {
+	cb.generation++
+	cb.requests, cb.failures, cb.successes = 0, 0, 0
+	switch cb.state {
+	case StateClosed:
+		cb.expiry = now.Add(cb.builder.window)
+	case StateOpen:
+		cb.expiry = now.Add(cb.builder.openTimeout)
+	default:
+		cb.expiry = time.Time{}
+	}
+}
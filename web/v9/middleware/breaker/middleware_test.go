@@ -0,0 +1,123 @@
+package breaker
+
+import (
+	web "github.com/go-tour/web/v9"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareBuilder_Build(t *testing.T) {
+	b := NewMiddlewareBuilder().
+		Window(time.Minute).
+		Threshold(4, 0.5).
+		OpenTimeout(50 * time.Millisecond).
+		HalfOpenRequests(2)
+	code := http.StatusInternalServerError
+	s := web.NewHTTPServer()
+	s.Use(b.Build())
+	s.Get("/user/:id", func(ctx *web.Context) {
+		ctx.RespStatusCode = code
+	})
+	s.Get("/order", func(ctx *web.Context) {
+		ctx.RespStatusCode = http.StatusOK
+	})
+	call := func(path string) int {
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		return recorder.Code
+	}
+
+	// 请求数量不够，不会熔断
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusInternalServerError, call("/user/1"))
+	}
+	assert.Equal(t, StateClosed, b.State(http.MethodGet, "/user/:id"))
+	assert.Equal(t, http.StatusInternalServerError, call("/user/2"))
+	assert.Equal(t, StateOpen, b.State(http.MethodGet, "/user/:id"))
+	assert.Equal(t, http.StatusServiceUnavailable, call("/user/1"))
+	// 其它路由不受影响
+	assert.Equal(t, http.StatusOK, call("/order"))
+
+	// 探测失败，重新熔断
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, StateHalfOpen, b.State(http.MethodGet, "/user/:id"))
+	assert.Equal(t, http.StatusInternalServerError, call("/user/1"))
+	assert.Equal(t, StateOpen, b.State(http.MethodGet, "/user/:id"))
+
+	// 探测成功，恢复
+	time.Sleep(60 * time.Millisecond)
+	code = http.StatusOK
+	assert.Equal(t, http.StatusOK, call("/user/1"))
+	assert.Equal(t, StateHalfOpen, b.State(http.MethodGet, "/user/:id"))
+	assert.Equal(t, http.StatusOK, call("/user/1"))
+	assert.Equal(t, StateClosed, b.State(http.MethodGet, "/user/:id"))
+}
+
+func TestMiddlewareBuilder_HalfOpenLimit(t *testing.T) {
+	b := NewMiddlewareBuilder().Threshold(1, 1).OpenTimeout(time.Millisecond)
+	cb := b.breaker(http.MethodGet, "/user")
+	now := time.Now()
+	gen, ok := cb.allow(now)
+	require.True(t, ok)
+	cb.done(gen, false, now)
+	assert.Equal(t, StateOpen, cb.state)
+
+	now = now.Add(10 * time.Millisecond)
+	_, ok = cb.allow(now)
+	assert.True(t, ok)
+	// 默认只放行一个探测请求
+	_, ok = cb.allow(now)
+	assert.False(t, ok)
+}
+
+// TestMiddlewareBuilder_InFlight 还在处理的请求不参与失败比例的计算
+func TestMiddlewareBuilder_InFlight(t *testing.T) {
+	b := NewMiddlewareBuilder().Threshold(4, 0.5)
+	cb := b.breaker(http.MethodGet, "/user")
+	now := time.Now()
+	gens := make([]uint64, 0, 10)
+	for i := 0; i < 10; i++ {
+		gen, ok := cb.allow(now)
+		require.True(t, ok)
+		gens = append(gens, gen)
+	}
+	for i := 0; i < 3; i++ {
+		cb.done(gens[i], false, now)
+	}
+	// 结束的请求数量还不够
+	assert.Equal(t, StateClosed, cb.state)
+	cb.done(gens[3], false, now)
+	assert.Equal(t, StateOpen, cb.state)
+}
+
+func TestMiddlewareBuilder_Slow(t *testing.T) {
+	b := NewMiddlewareBuilder().Threshold(2, 1).SlowThreshold(5 * time.Millisecond)
+	s := web.NewHTTPServer()
+	s.Use(b.Build())
+	s.Get("/slow", func(ctx *web.Context) {
+		time.Sleep(10 * time.Millisecond)
+		ctx.RespStatusCode = http.StatusOK
+	})
+	for i := 0; i < 2; i++ {
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/slow", nil))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+	}
+	assert.Equal(t, StateOpen, b.State(http.MethodGet, "/slow"))
+
+	reg := prometheus.NewPedanticRegistry()
+	require.NoError(t, reg.Register(b))
+	err := testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP web_circuit_breaker_state 熔断器的状态，0 closed，1 half-open，2 open
+# TYPE web_circuit_breaker_state gauge
+web_circuit_breaker_state{method="GET",pattern="/slow"} 2
+`))
+	assert.NoError(t, err)
+}
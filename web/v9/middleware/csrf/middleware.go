@@ -0,0 +1,222 @@
+package csrf
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	web "github.com/go-tour/web/v9"
+	"github.com/go-tour/web/v9/session"
+	"html/template"
+	"mime"
+	"net/http"
+)
+
+const (
+	defaultFieldName  = "_csrf"
+	defaultHeaderName = "X-CSRF-Token"
+	defaultCookieName = "_csrf"
+	defaultSessKey    = "_csrf_token"
+	tokenLen          = 32
+)
+
+type tokenCtxKey struct{}
+
+// MiddlewareBuilder 构造 CSRF 防护的 Middleware
+// 默认是 double-submit cookie 模式，也就是 token 放在 cookie 里面，
+// 请求的时候需要在表单或者 header 里面再提交一遍。
+// 调用 Session 之后，token 会存储在 session.Session 里面，
+// 但是如果请求还没有 session，例如登录页面，依旧会退化为 double-submit cookie 模式
+type MiddlewareBuilder struct {
+	manager    *session.Manager
+	sessKey    string
+	fieldName  string
+	headerName string
+	cookieName string
+	cookieOpt  func(c *http.Cookie)
+	errHandler web.HandleFunc
+}
+
+func NewMiddlewareBuilder() *MiddlewareBuilder {
+	return &MiddlewareBuilder{
+		sessKey:    defaultSessKey,
+		fieldName:  defaultFieldName,
+		headerName: defaultHeaderName,
+		cookieName: defaultCookieName,
+		cookieOpt:  func(c *http.Cookie) {},
+		errHandler: func(ctx *web.Context) {
+			ctx.RespStatusCode = http.StatusForbidden
+			ctx.RespData = []byte("CSRF token 校验失败")
+		},
+	}
+}
+
+// Session 将 token 保存在 session 里面
+// key 是 token 在 session 里面的 key
+func (b *MiddlewareBuilder) Session(m *session.Manager, key string) *MiddlewareBuilder {
+	b.manager = m
+	b.sessKey = key
+	return b
+}
+
+// FieldName 表单中 token 字段的名字
+func (b *MiddlewareBuilder) FieldName(name string) *MiddlewareBuilder {
+	b.fieldName = name
+	return b
+}
+
+// HeaderName 用于提交 token 的 header 的名字
+func (b *MiddlewareBuilder) HeaderName(name string) *MiddlewareBuilder {
+	b.headerName = name
+	return b
+}
+
+// CookieName double-submit cookie 的名字
+func (b *MiddlewareBuilder) CookieName(name string) *MiddlewareBuilder {
+	b.cookieName = name
+	return b
+}
+
+// CookieOption 用于设置 cookie 的 Domain，Secure 之类的字段
+// 注意，如果前端需要通过 JS 读取 token 放到 header 里面，那么不能设置 HttpOnly
+func (b *MiddlewareBuilder) CookieOption(opt func(c *http.Cookie)) *MiddlewareBuilder {
+	b.cookieOpt = opt
+	return b
+}
+
+// ErrHandler 校验失败的时候执行，默认返回 403
+func (b *MiddlewareBuilder) ErrHandler(hdl web.HandleFunc) *MiddlewareBuilder {
+	b.errHandler = hdl
+	return b
+}
+
+func (b *MiddlewareBuilder) Build() web.Middleware {
+	return func(next web.HandleFunc) web.HandleFunc {
+		return func(ctx *web.Context) {
+			token, err := b.token(ctx)
+			if err != nil {
+				ctx.RespStatusCode = http.StatusInternalServerError
+				ctx.RespData = []byte("生成 CSRF token 失败")
+				return
+			}
+			if !isSafeMethod(ctx.Req.Method) && !b.verify(ctx, token) {
+				b.errHandler(ctx)
+				return
+			}
+			reqCtx := context.WithValue(ctx.Req.Context(), tokenCtxKey{}, token)
+			ctx.Req = ctx.Req.WithContext(reqCtx)
+			next(ctx)
+		}
+	}
+}
+
+// token 拿到当前请求的 token，没有的话就生成一个新的并保存起来
+func (b *MiddlewareBuilder) token(ctx *web.Context) (string, error) {
+	if b.manager != nil {
+		sess, err := b.manager.GetSession(ctx)
+		if err == nil {
+			return b.sessionToken(ctx, sess)
+		}
+		// 只有没有 session 的时候才退化为 double-submit cookie 模式
+		if !session.IsNoSession(err) {
+			return "", err
+		}
+	}
+	if c, err := ctx.Req.Cookie(b.cookieName); err == nil && c.Value != "" {
+		return c.Value, nil
+	}
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	cookie := &http.Cookie{
+		Name:     b.cookieName,
+		Value:    token,
+		Path:     "/",
+		SameSite: http.SameSiteLaxMode,
+	}
+	b.cookieOpt(cookie)
+	ctx.SetCookie(cookie)
+	return token, nil
+}
+
+func (b *MiddlewareBuilder) sessionToken(ctx *web.Context, sess session.Session) (string, error) {
+	token, err := session.GetAs[string](ctx.Req.Context(), sess, b.sessKey)
+	if err == nil && token != "" {
+		return token, nil
+	}
+	token, err = newToken()
+	if err != nil {
+		return "", err
+	}
+	return token, sess.Set(ctx.Req.Context(), b.sessKey, token)
+}
+
+// verify 先检查 header，再检查表单。
+// 这里不会解析 multipart 表单，否则在校验 token 之前就要读完整个请求，
+// 后面 FileUploader 之类的大小限制也会失效，所以上传文件的时候必须通过 header 提交 token
+func (b *MiddlewareBuilder) verify(ctx *web.Context, token string) bool {
+	got := ctx.Req.Header.Get(b.headerName)
+	if got == "" {
+		got = b.formValue(ctx)
+	}
+	if got == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// formValue 只读取 urlencoded 表单，ParseForm 自己会限制请求体的大小
+func (b *MiddlewareBuilder) formValue(ctx *web.Context) string {
+	mediaType, _, _ := mime.ParseMediaType(ctx.Req.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		return ""
+	}
+	val, _ := ctx.FormValue(b.fieldName).String()
+	return val
+}
+
+// Token 返回当前请求的 CSRF token，
+// 前后端分离的时候可以通过接口把它返回给前端
+func Token(ctx *web.Context) string {
+	return tokenFromContext(ctx.Req.Context())
+}
+
+// TemplateFuncs 实现了 web.TemplateFuncProvider，提供了两个模板方法：
+// - csrfToken 返回 token
+// - csrfField 返回一个隐藏的 input 元素，可以直接放进表单
+func (b *MiddlewareBuilder) TemplateFuncs(ctx context.Context) template.FuncMap {
+	token := tokenFromContext(ctx)
+	return template.FuncMap{
+		"csrfToken": func() string {
+			return token
+		},
+		"csrfField": func() template.HTML {
+			return template.HTML(fmt.Sprintf(`<input type="hidden" name="%s" value="%s">`,
+				template.HTMLEscapeString(b.fieldName), template.HTMLEscapeString(token)))
+		},
+	}
+}
+
+func tokenFromContext(ctx context.Context) string {
+	token, _ := ctx.Value(tokenCtxKey{}).(string)
+	return token
+}
+
+func newToken() (string, error) {
+	bs := make([]byte, tokenLen)
+	if _, err := rand.Read(bs); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bs), nil
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,277 @@
+package csrf
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	web "github.com/go-tour/web/v9"
+	"github.com/go-tour/web/v9/session"
+	"github.com/go-tour/web/v9/session/cookie"
+	"github.com/go-tour/web/v9/session/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+var fieldRegexp = regexp.MustCompile(`name="_csrf" value="([^"]+)"`)
+
+func newLoginServer(t *testing.T, b *MiddlewareBuilder) *web.HTTPServer {
+	engine := &web.GoTemplateEngine{}
+	engine.RegisterFuncProvider(b.TemplateFuncs)
+	engine.RegisterFuncProvider(session.TemplateFuncs)
+	err := engine.LoadFromGlob("../../testdata/tpls/*.gohtml")
+	require.NoError(t, err)
+
+	s := web.NewHTTPServer(web.ServerWithTemplateEngine(engine))
+	s.Use(b.Build())
+	s.Get("/login", func(ctx *web.Context) {
+		_ = ctx.Render("login.gohtml", nil)
+	})
+	s.Post("/login", func(ctx *web.Context) {
+		ctx.RespData = []byte("ok")
+	})
+	return s
+}
+
+func TestMiddlewareBuilder_DoubleSubmit(t *testing.T) {
+	s := newLoginServer(t, NewMiddlewareBuilder())
+
+	// 打开登录页面，拿到 cookie 和表单里面的 token
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/login", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	matches := fieldRegexp.FindStringSubmatch(recorder.Body.String())
+	require.Len(t, matches, 2)
+	token := matches[1]
+	cookies := recorder.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, token, cookies[0].Value)
+
+	testCases := []struct {
+		name     string
+		req      func() *http.Request
+		wantCode int
+	}{
+		{
+			name: "form",
+			req: func() *http.Request {
+				form := url.Values{"_csrf": {token}, "email": {"a@b.com"}}
+				req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				req.AddCookie(cookies[0])
+				return req
+			},
+			wantCode: http.StatusOK,
+		},
+		{
+			// multipart 表单不会被解析，必须通过 header 提交
+			name: "multipart form",
+			req: func() *http.Request {
+				req := newUploadRequest(t, "/login", map[string]string{"_csrf": token}, 5)
+				req.AddCookie(cookies[0])
+				return req
+			},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name: "multipart form with header",
+			req: func() *http.Request {
+				req := newUploadRequest(t, "/login", nil, 5)
+				req.Header.Set("X-CSRF-Token", token)
+				req.AddCookie(cookies[0])
+				return req
+			},
+			wantCode: http.StatusOK,
+		},
+		{
+			name: "header",
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/login", nil)
+				req.Header.Set("X-CSRF-Token", token)
+				req.AddCookie(cookies[0])
+				return req
+			},
+			wantCode: http.StatusOK,
+		},
+		{
+			name: "missing token",
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/login", nil)
+				req.AddCookie(cookies[0])
+				return req
+			},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name: "wrong token",
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/login", nil)
+				req.Header.Set("X-CSRF-Token", "abc")
+				req.AddCookie(cookies[0])
+				return req
+			},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name: "no cookie",
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/login", nil)
+				req.Header.Set("X-CSRF-Token", token)
+				return req
+			},
+			wantCode: http.StatusForbidden,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, tc.req())
+			assert.Equal(t, tc.wantCode, recorder.Code)
+		})
+	}
+}
+
+// TestMiddlewareBuilder_Upload 校验 token 不会读取请求体，FileUploader 的大小限制依旧生效
+func TestMiddlewareBuilder_Upload(t *testing.T) {
+	s := web.NewHTTPServer()
+	s.Use(NewMiddlewareBuilder().Build())
+	s.Post("/upload", (&web.FileUploader{
+		FileField:      "file",
+		Storage:        web.NewMemoryFileStorage(),
+		MaxRequestSize: 1024,
+	}).Handle())
+	csrfCookie := &http.Cookie{Name: "_csrf", Value: "token"}
+
+	testCases := []struct {
+		name   string
+		size   int
+		header string
+		fields map[string]string
+
+		wantCode int
+	}{
+		{name: "ok", size: 10, header: "token", wantCode: http.StatusOK},
+		{name: "too large", size: 4096, header: "token", wantCode: http.StatusRequestEntityTooLarge},
+		// 表单里面的 token 不算，否则需要先读完整个请求
+		{name: "form field", size: 4096, fields: map[string]string{"_csrf": "token"}, wantCode: http.StatusForbidden},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := newUploadRequest(t, "/upload", tc.fields, tc.size)
+			req.AddCookie(csrfCookie)
+			if tc.header != "" {
+				req.Header.Set("X-CSRF-Token", tc.header)
+			}
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, req)
+			assert.Equal(t, tc.wantCode, recorder.Code)
+		})
+	}
+}
+
+func TestMiddlewareBuilder_SessionStoreError(t *testing.T) {
+	m := &session.Manager{
+		SessCtxKey: "_sess",
+		Store:      &failingStore{Store: memory.NewStore(time.Minute)},
+		Propagator: cookie.NewPropagator("sessid"),
+	}
+	s := web.NewHTTPServer()
+	s.Use(NewMiddlewareBuilder().Session(m, "csrf").Build())
+	s.Get("/token", func(ctx *web.Context) {
+		ctx.RespData = []byte(Token(ctx))
+	})
+
+	// Store 出错的时候不能退化为 double-submit cookie 模式
+	req := httptest.NewRequest(http.MethodGet, "/token", nil)
+	req.AddCookie(&http.Cookie{Name: "sessid", Value: "sess-1"})
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+	assert.Empty(t, recorder.Result().Cookies())
+
+	// 没有 session 的时候依旧可以使用 cookie
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/token", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Len(t, recorder.Result().Cookies(), 1)
+}
+
+func TestMiddlewareBuilder_Session(t *testing.T) {
+	m := &session.Manager{
+		SessCtxKey: "_sess",
+		Store:      memory.NewStore(time.Minute),
+		Propagator: cookie.NewPropagator("sessid"),
+	}
+	sess, err := m.Generate(context.Background(), "sess-1")
+	require.NoError(t, err)
+
+	b := NewMiddlewareBuilder().Session(m, "csrf")
+	s := web.NewHTTPServer()
+	s.Use(b.Build())
+	s.Get("/token", func(ctx *web.Context) {
+		ctx.RespData = []byte(Token(ctx))
+	})
+	s.Post("/transfer", func(ctx *web.Context) {
+		ctx.RespData = []byte("ok")
+	})
+	sessCookie := &http.Cookie{Name: "sessid", Value: "sess-1"}
+
+	req := httptest.NewRequest(http.MethodGet, "/token", nil)
+	req.AddCookie(sessCookie)
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, req)
+	token := recorder.Body.String()
+	require.NotEmpty(t, token)
+	// 有 session 的时候不会再写 cookie
+	assert.Empty(t, recorder.Result().Cookies())
+	stored, err := sess.Get(context.Background(), "csrf")
+	require.NoError(t, err)
+	assert.Equal(t, token, stored)
+
+	req = httptest.NewRequest(http.MethodPost, "/transfer", nil)
+	req.AddCookie(sessCookie)
+	req.Header.Set("X-CSRF-Token", token)
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/transfer", nil)
+	req.AddCookie(sessCookie)
+	req.Header.Set("X-CSRF-Token", "forged")
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+}
+
+// newUploadRequest 构造一个 multipart 请求，fields 是普通字段，文件的大小是 size
+func newUploadRequest(t *testing.T, path string, fields map[string]string, size int) *http.Request {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for key, val := range fields {
+		require.NoError(t, writer.WriteField(key, val))
+	}
+	w, err := writer.CreateFormFile("file", "a.txt")
+	require.NoError(t, err)
+	_, err = w.Write(bytes.Repeat([]byte("a"), size))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	req := httptest.NewRequest(http.MethodPost, path, body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+// failingStore Get 总是返回 error
+type failingStore struct {
+	session.Store
+}
+
+func (s *failingStore) Get(ctx context.Context, id string) (session.Session, error) {
+	return nil, errors.New("mock error")
+}
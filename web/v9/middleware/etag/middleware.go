@@ -0,0 +1,71 @@
+package etag
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	web "github.com/go-tour/web/v9"
+	"net/http"
+	"time"
+)
+
+// MiddlewareBuilder 根据 ctx.RespData 计算 ETag，
+// 并且处理 If-None-Match 和 If-Modified-Since，命中的时候返回 304。
+// 直接使用 ctx.Resp 写响应的 handler 不会被处理，它们应该自己处理条件请求，
+// 例如 http.ServeContent
+type MiddlewareBuilder struct {
+	weak bool
+}
+
+func NewMiddlewareBuilder() *MiddlewareBuilder {
+	return &MiddlewareBuilder{}
+}
+
+// Weak 生成弱 ETag
+// 如果响应会被压缩之类的中间件修改，那么应该使用弱 ETag
+func (b *MiddlewareBuilder) Weak(weak bool) *MiddlewareBuilder {
+	b.weak = weak
+	return b
+}
+
+func (b *MiddlewareBuilder) Build() web.Middleware {
+	return func(next web.HandleFunc) web.HandleFunc {
+		return func(ctx *web.Context) {
+			next(ctx)
+			if ctx.Req.Method != http.MethodGet && ctx.Req.Method != http.MethodHead {
+				return
+			}
+			if ctx.RespStatusCode != 0 && ctx.RespStatusCode != http.StatusOK {
+				return
+			}
+			// 已经直接通过 ctx.Resp 写了响应，例如 http.ServeContent，
+			// 再设置响应码会导致重复调用 WriteHeader
+			if ctx.RespStatusCode == 0 && len(ctx.RespData) == 0 {
+				return
+			}
+			header := ctx.Resp.Header()
+			// handler 自己设置了 ETag 的话，就不再计算了
+			tag := header.Get("ETag")
+			if tag == "" && len(ctx.RespData) > 0 {
+				tag = b.generate(ctx.RespData)
+				header.Set("ETag", tag)
+			}
+			var lastModified time.Time
+			if lm := header.Get("Last-Modified"); lm != "" {
+				lastModified, _ = http.ParseTime(lm)
+			}
+			if web.NotModified(ctx.Req, tag, lastModified) {
+				ctx.RespStatusCode = http.StatusNotModified
+				ctx.RespData = nil
+			}
+		}
+	}
+}
+
+func (b *MiddlewareBuilder) generate(data []byte) string {
+	sum := sha256.Sum256(data)
+	tag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	if b.weak {
+		return "W/" + tag
+	}
+	return tag
+}
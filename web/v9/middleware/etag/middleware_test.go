@@ -0,0 +1,135 @@
+package etag
+
+import (
+	web "github.com/go-tour/web/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareBuilder_Build(t *testing.T) {
+	modTime := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := web.NewHTTPServer()
+	s.Use(NewMiddlewareBuilder().Build())
+	s.Get("/user", func(ctx *web.Context) {
+		ctx.SetCacheControl(web.CacheControl{NoCache: true})
+		ctx.RespData = []byte("hello, world")
+	})
+	s.Get("/article", func(ctx *web.Context) {
+		ctx.SetLastModified(modTime)
+		ctx.RespData = []byte("article")
+	})
+	s.Get("/error", func(ctx *web.Context) {
+		ctx.RespStatusCode = http.StatusInternalServerError
+		ctx.RespData = []byte("error")
+	})
+
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/user", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "no-cache", recorder.Header().Get("Cache-Control"))
+	tag := recorder.Header().Get("ETag")
+	require.NotEmpty(t, tag)
+
+	testCases := []struct {
+		name     string
+		path     string
+		header   map[string]string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "etag hit",
+			path:     "/user",
+			header:   map[string]string{"If-None-Match": tag},
+			wantCode: http.StatusNotModified,
+		},
+		{
+			name:     "etag miss",
+			path:     "/user",
+			header:   map[string]string{"If-None-Match": `"abc"`},
+			wantCode: http.StatusOK,
+			wantBody: "hello, world",
+		},
+		{
+			name:     "not modified since",
+			path:     "/article",
+			header:   map[string]string{"If-Modified-Since": modTime.Format(http.TimeFormat)},
+			wantCode: http.StatusNotModified,
+		},
+		{
+			name:     "modified since",
+			path:     "/article",
+			header:   map[string]string{"If-Modified-Since": modTime.Add(-time.Hour).Format(http.TimeFormat)},
+			wantCode: http.StatusOK,
+			wantBody: "article",
+		},
+		{
+			name:     "error response",
+			path:     "/error",
+			header:   map[string]string{"If-None-Match": "*"},
+			wantCode: http.StatusInternalServerError,
+			wantBody: "error",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			for k, v := range tc.header {
+				req.Header.Set(k, v)
+			}
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, req)
+			assert.Equal(t, tc.wantCode, recorder.Code)
+			assert.Equal(t, tc.wantBody, recorder.Body.String())
+		})
+	}
+}
+
+// TestMiddlewareBuilder_Direct 直接写了响应的 handler 不处理，否则会重复调用 WriteHeader
+func TestMiddlewareBuilder_Direct(t *testing.T) {
+	var code int
+	s := web.NewHTTPServer()
+	s.Use(func(next web.HandleFunc) web.HandleFunc {
+		return func(ctx *web.Context) {
+			next(ctx)
+			code = ctx.RespStatusCode
+		}
+	}, NewMiddlewareBuilder().Build())
+	s.Get("/file", func(ctx *web.Context) {
+		ctx.Resp.Header().Set("ETag", `"v1"`)
+		ctx.Resp.WriteHeader(http.StatusOK)
+		_, _ = ctx.Resp.Write([]byte("hello"))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/file", nil)
+	req.Header.Set("If-None-Match", `"v1"`)
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, req)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "hello", recorder.Body.String())
+}
+
+func TestMiddlewareBuilder_Weak(t *testing.T) {
+	s := web.NewHTTPServer()
+	s.Use(NewMiddlewareBuilder().Weak(true).Build())
+	s.Get("/user", func(ctx *web.Context) {
+		ctx.RespData = []byte("hello, world")
+	})
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/user", nil))
+	tag := recorder.Header().Get("ETag")
+	assert.True(t, strings.HasPrefix(tag, `W/"`))
+
+	// 弱比较，客户端带回来的是强 ETag 也能命中
+	req := httptest.NewRequest(http.MethodGet, "/user", nil)
+	req.Header.Set("If-None-Match", strings.TrimPrefix(tag, "W/"))
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusNotModified, recorder.Code)
+}
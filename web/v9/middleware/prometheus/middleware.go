@@ -12,6 +12,9 @@ type MiddlewareBuilder struct {
 	Subsystem   string
 	ConstLabels map[string]string
 	Help        string
+	// Collectors 会和请求的统计数据一起注册，
+	// 例如 shedding 和 breaker 中间件，用于暴露它们的状态
+	Collectors []prometheus.Collector
 }
 
 func (m *MiddlewareBuilder) Build() web.Middleware {
@@ -22,6 +25,7 @@ func (m *MiddlewareBuilder) Build() web.Middleware {
 		Help:        m.Help,
 	}, []string{"pattern", "method", "status"})
 	prometheus.MustRegister(summaryVec)
+	prometheus.MustRegister(m.Collectors...)
 	return func(next web.HandleFunc) web.HandleFunc {
 		return func(ctx *web.Context) {
 			startTime := time.Now()
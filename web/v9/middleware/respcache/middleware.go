@@ -0,0 +1,304 @@
+package respcache
+
+import (
+	web "github.com/go-tour/web/v9"
+	lru "github.com/hashicorp/golang-lru"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	tagsKey = "_respcache_tags"
+	keyKey  = "_respcache_key"
+
+	defaultTTL = time.Minute
+)
+
+// RouteConfig 是单个路由的缓存配置
+type RouteConfig struct {
+	// TTL 缓存的有效期，小于等于 0 的时候使用默认的一分钟
+	TTL time.Duration
+	// QueryParams 参与计算 key 的查询参数，其余的查询参数会被忽略
+	QueryParams []string
+	// Vary 参与计算 key 的请求头，例如 Accept-Language
+	Vary []string
+	// Tags 这个路由所有缓存的响应都会被打上这些标签
+	Tags []string
+}
+
+// MiddlewareBuilder 在服务端缓存整个响应，包括响应码，响应头和 RespData
+// 只有通过 Route 注册了的路由才会被缓存，并且只缓存 GET 请求的 200 响应。
+// 直接使用 ctx.Resp 写响应的 handler，例如 FileDownloader，或者设置了 cookie 的响应都不会被缓存。
+// 只有 handler 和内层的 Middleware 设置的响应头会被缓存，
+// 外层 Middleware 设置的响应头，例如 secure 每个请求都不同的 CSP nonce，每次都由它们自己设置
+type MiddlewareBuilder struct {
+	cache *lru.Cache
+	// method + 空格 + route => 配置
+	routes map[string]RouteConfig
+
+	mutex sync.Mutex
+	// tag => keys，用于按照标签失效
+	tags map[string]map[string]struct{}
+	// 正在加载的 key，用于合并并发的请求
+	calls map[string]*call
+}
+
+type entry struct {
+	statusCode int
+	header     http.Header
+	data       []byte
+	tags       []string
+	expireAt   time.Time
+}
+
+type call struct {
+	wg    sync.WaitGroup
+	entry *entry
+}
+
+// NewMiddlewareBuilder 创建一个 MiddlewareBuilder
+// size 是最多缓存多少个响应，超过之后按照 LRU 淘汰
+func NewMiddlewareBuilder(size int) (*MiddlewareBuilder, error) {
+	b := &MiddlewareBuilder{
+		routes: make(map[string]RouteConfig, 8),
+		tags:   make(map[string]map[string]struct{}, 8),
+		calls:  make(map[string]*call, 8),
+	}
+	c, err := lru.NewWithEvict(size, b.onEvict)
+	if err != nil {
+		return nil, err
+	}
+	b.cache = c
+	return b, nil
+}
+
+// Route 开启某个路由的缓存
+// route 就是注册路由时候的路径，例如 /user/:id
+func (b *MiddlewareBuilder) Route(method string, route string, cfg RouteConfig) *MiddlewareBuilder {
+	if cfg.TTL <= 0 {
+		cfg.TTL = defaultTTL
+	}
+	b.routes[method+" "+route] = cfg
+	return b
+}
+
+func (b *MiddlewareBuilder) Build() web.Middleware {
+	return func(next web.HandleFunc) web.HandleFunc {
+		return func(ctx *web.Context) {
+			if ctx.Req.Method != http.MethodGet {
+				next(ctx)
+				return
+			}
+			cfg, ok := b.routes[ctx.Req.Method+" "+ctx.MatchedRoute]
+			if !ok {
+				next(ctx)
+				return
+			}
+			key := b.key(ctx, cfg)
+			if e, ok := b.get(key); ok {
+				writeEntry(ctx, e)
+				return
+			}
+
+			b.mutex.Lock()
+			if c, ok := b.calls[key]; ok {
+				// 已经有请求在加载了，等它的结果
+				b.mutex.Unlock()
+				c.wg.Wait()
+				if c.entry != nil {
+					writeEntry(ctx, c.entry)
+					return
+				}
+				// 前面的请求没能缓存下来，那么只能自己执行了
+				next(ctx)
+				return
+			}
+			c := &call{}
+			c.wg.Add(1)
+			b.calls[key] = c
+			b.mutex.Unlock()
+
+			defer func() {
+				b.mutex.Lock()
+				delete(b.calls, key)
+				b.mutex.Unlock()
+				c.wg.Done()
+			}()
+
+			if ctx.UserValues == nil {
+				ctx.UserValues = make(map[string]any, 2)
+			}
+			ctx.UserValues[keyKey] = key
+			outer := ctx.Resp.Header().Clone()
+			next(ctx)
+			ctx.Resp.Header().Set("X-Cache", "MISS")
+			c.entry = b.store(ctx, key, cfg, outer)
+		}
+	}
+}
+
+// InvalidateKey 让某个 key 的缓存失效
+// 在 handler 里面可以通过 Key 拿到当前请求的 key
+func (b *MiddlewareBuilder) InvalidateKey(key string) {
+	b.cache.Remove(key)
+}
+
+// InvalidateTag 让所有打了这个标签的缓存失效
+func (b *MiddlewareBuilder) InvalidateTag(tag string) {
+	b.mutex.Lock()
+	keys := make([]string, 0, len(b.tags[tag]))
+	for key := range b.tags[tag] {
+		keys = append(keys, key)
+	}
+	b.mutex.Unlock()
+	// 不能在持有锁的时候操作 cache，因为淘汰的回调里面也要加锁
+	for _, key := range keys {
+		b.cache.Remove(key)
+	}
+}
+
+// AddTags 给当前请求的响应打上标签，在 handler 里面调用
+func AddTags(ctx *web.Context, tags ...string) {
+	if ctx.UserValues == nil {
+		ctx.UserValues = make(map[string]any, 2)
+	}
+	old, _ := ctx.UserValues[tagsKey].([]string)
+	ctx.UserValues[tagsKey] = append(old, tags...)
+}
+
+// Key 返回当前请求的缓存 key，只有缓存未命中，执行 handler 的时候才有
+func Key(ctx *web.Context) string {
+	key, _ := ctx.UserValues[keyKey].(string)
+	return key
+}
+
+func (b *MiddlewareBuilder) get(key string) (*entry, bool) {
+	val, ok := b.cache.Get(key)
+	if !ok {
+		return nil, false
+	}
+	e := val.(*entry)
+	if time.Now().After(e.expireAt) {
+		b.cache.Remove(key)
+		return nil, false
+	}
+	return e, true
+}
+
+// store outer 是执行 handler 之前就已经有的响应头，它们不会被缓存
+func (b *MiddlewareBuilder) store(ctx *web.Context, key string, cfg RouteConfig, outer http.Header) *entry {
+	if ctx.RespStatusCode != 0 && ctx.RespStatusCode != http.StatusOK {
+		return nil
+	}
+	// 直接通过 ctx.Resp 写了响应，缓存下来只会是一个空的响应
+	if ctx.RespStatusCode == 0 && len(ctx.RespData) == 0 {
+		return nil
+	}
+	if ctx.Resp.Header().Get("Set-Cookie") != "" {
+		return nil
+	}
+	tags, _ := ctx.UserValues[tagsKey].([]string)
+	tags = append(tags, cfg.Tags...)
+	header := make(http.Header, len(ctx.Resp.Header()))
+	for k, vals := range ctx.Resp.Header() {
+		if k == "X-Cache" || equalValues(outer[k], vals) {
+			continue
+		}
+		header[k] = append([]string(nil), vals...)
+	}
+	e := &entry{
+		statusCode: ctx.RespStatusCode,
+		header:     header,
+		data:       ctx.RespData,
+		tags:       tags,
+		expireAt:   time.Now().Add(cfg.TTL),
+	}
+	// 覆盖已有的缓存的时候 Add 不会触发淘汰的回调，
+	// 所以先删掉，这样老的标签也会被清理掉
+	b.cache.Remove(key)
+	b.cache.Add(key, e)
+	b.mutex.Lock()
+	for _, tag := range tags {
+		keys, ok := b.tags[tag]
+		if !ok {
+			keys = make(map[string]struct{}, 4)
+			b.tags[tag] = keys
+		}
+		keys[key] = struct{}{}
+	}
+	b.mutex.Unlock()
+	return e
+}
+
+func equalValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *MiddlewareBuilder) onEvict(key any, value any) {
+	e := value.(*entry)
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for _, tag := range e.tags {
+		keys := b.tags[tag]
+		delete(keys, key.(string))
+		if len(keys) == 0 {
+			delete(b.tags, tag)
+		}
+	}
+}
+
+// key 由 HTTP 方法，命中的路由，路径参数，选中的查询参数和 Vary 请求头组成
+func (b *MiddlewareBuilder) key(ctx *web.Context, cfg RouteConfig) string {
+	var sb strings.Builder
+	sb.WriteString(ctx.Req.Method)
+	sb.WriteByte(' ')
+	sb.WriteString(ctx.MatchedRoute)
+	if len(ctx.PathParams) > 0 {
+		// Encode 会按照参数名排序，所以 key 是稳定的
+		params := make(url.Values, len(ctx.PathParams))
+		for name, val := range ctx.PathParams {
+			params.Set(name, val)
+		}
+		sb.WriteString("|p:")
+		sb.WriteString(params.Encode())
+	}
+	if len(cfg.QueryParams) > 0 {
+		query := ctx.Req.URL.Query()
+		selected := url.Values{}
+		for _, name := range cfg.QueryParams {
+			if vals, ok := query[name]; ok {
+				selected[name] = vals
+			}
+		}
+		sb.WriteString("|q:")
+		sb.WriteString(selected.Encode())
+	}
+	for _, name := range cfg.Vary {
+		sb.WriteString("|h:")
+		sb.WriteString(name)
+		sb.WriteByte('=')
+		sb.WriteString(url.QueryEscape(ctx.Req.Header.Get(name)))
+	}
+	return sb.String()
+}
+
+func writeEntry(ctx *web.Context, e *entry) {
+	header := ctx.Resp.Header()
+	for k, vals := range e.header {
+		header[k] = append([]string(nil), vals...)
+	}
+	header.Set("X-Cache", "HIT")
+	ctx.RespStatusCode = e.statusCode
+	ctx.RespData = e.data
+}
@@ -0,0 +1,331 @@
+package respcache
+
+import (
+	web "github.com/go-tour/web/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareBuilder_Build(t *testing.T) {
+	b, err := NewMiddlewareBuilder(16)
+	require.NoError(t, err)
+	b.Route(http.MethodGet, "/user/:id", RouteConfig{
+		TTL:         time.Minute,
+		QueryParams: []string{"fields"},
+		Vary:        []string{"Accept-Language"},
+		Tags:        []string{"user"},
+	}).Route(http.MethodGet, "/short", RouteConfig{TTL: time.Millisecond}).
+		Route(http.MethodGet, "/default", RouteConfig{})
+
+	var cnt int64
+	s := web.NewHTTPServer()
+	s.Use(b.Build())
+	s.Get("/user/:id", func(ctx *web.Context) {
+		atomic.AddInt64(&cnt, 1)
+		id, _ := ctx.PathValue("id").String()
+		ctx.Resp.Header().Set("Content-Type", "text/plain")
+		ctx.RespData = []byte("user " + id)
+	})
+	s.Get("/short", func(ctx *web.Context) {
+		atomic.AddInt64(&cnt, 1)
+		ctx.RespData = []byte("short")
+	})
+	s.Get("/default", func(ctx *web.Context) {
+		atomic.AddInt64(&cnt, 1)
+		ctx.RespData = []byte("default")
+	})
+	s.Get("/nocache", func(ctx *web.Context) {
+		atomic.AddInt64(&cnt, 1)
+		ctx.RespData = []byte("nocache")
+	})
+
+	testCases := []struct {
+		name      string
+		path      string
+		header    map[string]string
+		sleep     time.Duration
+		wantBody  string
+		wantCache string
+		wantCnt   int64
+	}{
+		{
+			name:      "first",
+			path:      "/user/1?fields=name",
+			wantBody:  "user 1",
+			wantCache: "MISS",
+			wantCnt:   1,
+		},
+		{
+			name:      "hit",
+			path:      "/user/1?fields=name",
+			wantBody:  "user 1",
+			wantCache: "HIT",
+			wantCnt:   1,
+		},
+		{
+			// 没有被选中的查询参数不影响 key
+			name:      "ignored query",
+			path:      "/user/1?fields=name&_t=123",
+			wantBody:  "user 1",
+			wantCache: "HIT",
+			wantCnt:   1,
+		},
+		{
+			name:      "different query",
+			path:      "/user/1?fields=email",
+			wantBody:  "user 1",
+			wantCache: "MISS",
+			wantCnt:   2,
+		},
+		{
+			name:      "different path param",
+			path:      "/user/2?fields=name",
+			wantBody:  "user 2",
+			wantCache: "MISS",
+			wantCnt:   3,
+		},
+		{
+			name:      "vary",
+			path:      "/user/1?fields=name",
+			header:    map[string]string{"Accept-Language": "zh-CN"},
+			wantBody:  "user 1",
+			wantCache: "MISS",
+			wantCnt:   4,
+		},
+		{
+			name:      "not configured",
+			path:      "/nocache",
+			wantBody:  "nocache",
+			wantCache: "",
+			wantCnt:   5,
+		},
+		{
+			name:      "short first",
+			path:      "/short",
+			wantBody:  "short",
+			wantCache: "MISS",
+			wantCnt:   6,
+		},
+		{
+			name:      "expired",
+			path:      "/short",
+			sleep:     5 * time.Millisecond,
+			wantBody:  "short",
+			wantCache: "MISS",
+			wantCnt:   7,
+		},
+		{
+			name:      "default ttl first",
+			path:      "/default",
+			wantBody:  "default",
+			wantCache: "MISS",
+			wantCnt:   8,
+		},
+		{
+			// 没有设置 TTL 的时候使用默认值，而不是立刻过期
+			name:      "default ttl",
+			path:      "/default",
+			wantBody:  "default",
+			wantCache: "HIT",
+			wantCnt:   8,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			time.Sleep(tc.sleep)
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			for k, v := range tc.header {
+				req.Header.Set(k, v)
+			}
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, req)
+			assert.Equal(t, tc.wantBody, recorder.Body.String())
+			assert.Equal(t, tc.wantCache, recorder.Header().Get("X-Cache"))
+			assert.Equal(t, tc.wantCnt, atomic.LoadInt64(&cnt))
+		})
+	}
+
+	// 命中的时候响应头也要恢复
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/user/2?fields=name", nil))
+	assert.Equal(t, "HIT", recorder.Header().Get("X-Cache"))
+	assert.Equal(t, "text/plain", recorder.Header().Get("Content-Type"))
+
+	// 按照标签失效
+	b.InvalidateTag("user")
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/user/2?fields=name", nil))
+	assert.Equal(t, "MISS", recorder.Header().Get("X-Cache"))
+}
+
+func TestMiddlewareBuilder_Invalidate(t *testing.T) {
+	b, err := NewMiddlewareBuilder(16)
+	require.NoError(t, err)
+	b.Route(http.MethodGet, "/article/:id", RouteConfig{TTL: time.Minute})
+
+	var key string
+	version := "v1"
+	s := web.NewHTTPServer()
+	s.Use(b.Build())
+	s.Get("/article/:id", func(ctx *web.Context) {
+		key = Key(ctx)
+		id, _ := ctx.PathValue("id").String()
+		AddTags(ctx, "article:"+id)
+		ctx.RespData = []byte(version)
+	})
+	s.Get("/error", func(ctx *web.Context) {
+		ctx.RespStatusCode = http.StatusInternalServerError
+	})
+	s.Post("/article/:id", func(ctx *web.Context) {
+		id, _ := ctx.PathValue("id").String()
+		version = "v2"
+		b.InvalidateTag("article:" + id)
+	})
+
+	get := func(path string) string {
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		return recorder.Body.String()
+	}
+	assert.Equal(t, "v1", get("/article/1"))
+	assert.Equal(t, "v1", get("/article/2"))
+	key2 := key
+	require.NotEmpty(t, key2)
+
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/article/1", nil))
+	assert.Equal(t, "v2", get("/article/1"))
+	// 其余的缓存不受影响
+	assert.Equal(t, "v1", get("/article/2"))
+
+	b.InvalidateKey(key2)
+	assert.Equal(t, "v2", get("/article/2"))
+	// 非 200 的响应不缓存
+	get("/error")
+	assert.Equal(t, 2, b.cache.Len())
+}
+
+// TestMiddlewareBuilder_Overwrite 覆盖已有的缓存的时候，老的标签不能残留
+func TestMiddlewareBuilder_Overwrite(t *testing.T) {
+	b, err := NewMiddlewareBuilder(16)
+	require.NoError(t, err)
+	cfg := RouteConfig{TTL: time.Minute}
+	newCtx := func(tag string) *web.Context {
+		ctx := &web.Context{
+			Req:        httptest.NewRequest(http.MethodGet, "/article/1", nil),
+			Resp:       httptest.NewRecorder(),
+			RespData:   []byte(tag),
+			UserValues: map[string]any{},
+		}
+		AddTags(ctx, tag)
+		return ctx
+	}
+	b.store(newCtx("v1"), "key", cfg, nil)
+	b.store(newCtx("v2"), "key", cfg, nil)
+
+	b.mutex.Lock()
+	assert.Equal(t, map[string]map[string]struct{}{
+		"v2": {"key": {}},
+	}, b.tags)
+	b.mutex.Unlock()
+	// 老的标签已经不会让新的缓存失效了
+	b.InvalidateTag("v1")
+	e, ok := b.get("key")
+	require.True(t, ok)
+	assert.Equal(t, []byte("v2"), e.data)
+}
+
+// TestMiddlewareBuilder_Direct 直接写 ctx.Resp 的 handler 不缓存
+func TestMiddlewareBuilder_Direct(t *testing.T) {
+	b, err := NewMiddlewareBuilder(16)
+	require.NoError(t, err)
+	b.Route(http.MethodGet, "/file", RouteConfig{})
+
+	var cnt int64
+	s := web.NewHTTPServer()
+	s.Use(b.Build())
+	s.Get("/file", func(ctx *web.Context) {
+		atomic.AddInt64(&cnt, 1)
+		http.ServeContent(ctx.Resp, ctx.Req, "a.txt", time.Time{}, strings.NewReader("hello"))
+	})
+	for i := 0; i < 2; i++ {
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/file", nil))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "hello", recorder.Body.String())
+	}
+	assert.Equal(t, int64(2), cnt)
+	assert.Equal(t, 0, b.cache.Len())
+}
+
+// TestMiddlewareBuilder_OuterHeader 外层 Middleware 设置的响应头不能缓存给别的请求
+func TestMiddlewareBuilder_OuterHeader(t *testing.T) {
+	b, err := NewMiddlewareBuilder(16)
+	require.NoError(t, err)
+	b.Route(http.MethodGet, "/page", RouteConfig{})
+
+	var nonce int64
+	s := web.NewHTTPServer()
+	s.Use(func(next web.HandleFunc) web.HandleFunc {
+		return func(ctx *web.Context) {
+			n := atomic.AddInt64(&nonce, 1)
+			ctx.Resp.Header().Set("X-Nonce", strconv.FormatInt(n, 10))
+			next(ctx)
+		}
+	}, b.Build())
+	s.Get("/page", func(ctx *web.Context) {
+		ctx.Resp.Header().Set("Content-Type", "text/plain")
+		ctx.RespData = []byte("page")
+	})
+	for i, want := range []string{"1", "2"} {
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/page", nil))
+		assert.Equal(t, want, recorder.Header().Get("X-Nonce"))
+		assert.Equal(t, "text/plain", recorder.Header().Get("Content-Type"))
+		assert.Equal(t, []string{"MISS", "HIT"}[i], recorder.Header().Get("X-Cache"))
+	}
+}
+
+func TestMiddlewareBuilder_SingleFlight(t *testing.T) {
+	b, err := NewMiddlewareBuilder(16)
+	require.NoError(t, err)
+	b.Route(http.MethodGet, "/slow", RouteConfig{TTL: time.Minute})
+
+	var cnt int64
+	start := make(chan struct{})
+	s := web.NewHTTPServer()
+	s.Use(b.Build())
+	s.Get("/slow", func(ctx *web.Context) {
+		atomic.AddInt64(&cnt, 1)
+		<-start
+		ctx.RespData = []byte("slow")
+	})
+
+	const n = 10
+	var wg sync.WaitGroup
+	wg.Add(n)
+	bodies := make([]string, n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			defer wg.Done()
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/slow", nil))
+			bodies[i] = recorder.Body.String()
+		}(i)
+	}
+	// 等所有的请求都进来
+	time.Sleep(50 * time.Millisecond)
+	close(start)
+	wg.Wait()
+	assert.Equal(t, int64(1), atomic.LoadInt64(&cnt))
+	for _, body := range bodies {
+		assert.Equal(t, "slow", body)
+	}
+}
@@ -0,0 +1,221 @@
+package secure
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	web "github.com/go-tour/web/v9"
+	"html/template"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// NoncePlaceholder 在 Content-Security-Policy 里面出现的这个占位符，
+// 会被替换为每个请求都不同的 nonce。例如
+// script-src 'self' 'nonce-{nonce}'
+const NoncePlaceholder = "{nonce}"
+
+type nonceCtxKey struct{}
+
+// MiddlewareBuilder 构造设置安全相关 header 的 Middleware
+// 默认设置 X-Content-Type-Options，X-Frame-Options 和 Referrer-Policy，
+// 其余的 header 需要显式开启
+type MiddlewareBuilder struct {
+	hsts               string
+	csp                string
+	frameOptions       string
+	contentTypeNosniff bool
+	referrerPolicy     string
+	permissionsPolicy  string
+
+	sslRedirect  bool
+	sslHost      string
+	allowedHosts map[string]struct{}
+	// 是否信任 X-Forwarded-Proto，在负载均衡后面的时候需要开启
+	trustProxy bool
+}
+
+func NewMiddlewareBuilder() *MiddlewareBuilder {
+	return &MiddlewareBuilder{
+		frameOptions:       "DENY",
+		contentTypeNosniff: true,
+		referrerPolicy:     "strict-origin-when-cross-origin",
+	}
+}
+
+// HSTS 设置 Strict-Transport-Security，只有 HTTPS 请求才会返回这个 header
+func (b *MiddlewareBuilder) HSTS(maxAge time.Duration, includeSubDomains bool, preload bool) *MiddlewareBuilder {
+	val := fmt.Sprintf("max-age=%d", int64(maxAge/time.Second))
+	if includeSubDomains {
+		val += "; includeSubDomains"
+	}
+	if preload {
+		val += "; preload"
+	}
+	b.hsts = val
+	return b
+}
+
+// ContentSecurityPolicy 设置 CSP，policy 里面可以使用 NoncePlaceholder
+func (b *MiddlewareBuilder) ContentSecurityPolicy(policy string) *MiddlewareBuilder {
+	b.csp = policy
+	return b
+}
+
+// FrameOptions 设置 X-Frame-Options，传入空字符串则不设置
+func (b *MiddlewareBuilder) FrameOptions(val string) *MiddlewareBuilder {
+	b.frameOptions = val
+	return b
+}
+
+// ContentTypeNosniff 控制是否设置 X-Content-Type-Options: nosniff
+func (b *MiddlewareBuilder) ContentTypeNosniff(enabled bool) *MiddlewareBuilder {
+	b.contentTypeNosniff = enabled
+	return b
+}
+
+// ReferrerPolicy 设置 Referrer-Policy，传入空字符串则不设置
+func (b *MiddlewareBuilder) ReferrerPolicy(val string) *MiddlewareBuilder {
+	b.referrerPolicy = val
+	return b
+}
+
+// PermissionsPolicy 设置 Permissions-Policy，例如 camera=(), geolocation=(self)
+func (b *MiddlewareBuilder) PermissionsPolicy(val string) *MiddlewareBuilder {
+	b.permissionsPolicy = val
+	return b
+}
+
+// SSLRedirect 将 HTTP 请求重定向到 HTTPS
+// host 不为空的时候，会重定向到这个 host，否则使用请求本身的 host
+func (b *MiddlewareBuilder) SSLRedirect(host string) *MiddlewareBuilder {
+	b.sslRedirect = true
+	b.sslHost = host
+	return b
+}
+
+// AllowedHosts 只允许 Host 为这些值的请求，其余的请求返回 400
+func (b *MiddlewareBuilder) AllowedHosts(hosts ...string) *MiddlewareBuilder {
+	b.allowedHosts = make(map[string]struct{}, len(hosts))
+	for _, h := range hosts {
+		b.allowedHosts[strings.ToLower(h)] = struct{}{}
+	}
+	return b
+}
+
+// TrustProxy 信任 X-Forwarded-Proto 来判断是否是 HTTPS 请求
+func (b *MiddlewareBuilder) TrustProxy(trust bool) *MiddlewareBuilder {
+	b.trustProxy = trust
+	return b
+}
+
+func (b *MiddlewareBuilder) Build() web.Middleware {
+	return func(next web.HandleFunc) web.HandleFunc {
+		return func(ctx *web.Context) {
+			if b.allowedHosts != nil && !b.isAllowedHost(ctx.Req.Host) {
+				ctx.RespStatusCode = http.StatusBadRequest
+				ctx.RespData = []byte("非法的 Host")
+				return
+			}
+			https := b.isHTTPS(ctx.Req)
+			if b.sslRedirect && !https {
+				b.redirect(ctx)
+				return
+			}
+
+			header := ctx.Resp.Header()
+			if b.hsts != "" && https {
+				header.Set("Strict-Transport-Security", b.hsts)
+			}
+			if b.frameOptions != "" {
+				header.Set("X-Frame-Options", b.frameOptions)
+			}
+			if b.contentTypeNosniff {
+				header.Set("X-Content-Type-Options", "nosniff")
+			}
+			if b.referrerPolicy != "" {
+				header.Set("Referrer-Policy", b.referrerPolicy)
+			}
+			if b.permissionsPolicy != "" {
+				header.Set("Permissions-Policy", b.permissionsPolicy)
+			}
+			if b.csp != "" {
+				csp := b.csp
+				if strings.Contains(csp, NoncePlaceholder) {
+					nonce, err := newNonce()
+					if err != nil {
+						ctx.RespStatusCode = http.StatusInternalServerError
+						return
+					}
+					csp = strings.ReplaceAll(csp, NoncePlaceholder, nonce)
+					reqCtx := context.WithValue(ctx.Req.Context(), nonceCtxKey{}, nonce)
+					ctx.Req = ctx.Req.WithContext(reqCtx)
+				}
+				header.Set("Content-Security-Policy", csp)
+			}
+			next(ctx)
+		}
+	}
+}
+
+func (b *MiddlewareBuilder) redirect(ctx *web.Context) {
+	host := b.sslHost
+	if host == "" {
+		host = ctx.Req.Host
+	}
+	target := "https://" + host + ctx.Req.URL.RequestURI()
+	ctx.Resp.Header().Set("Location", target)
+	// GET 和 HEAD 之外的请求使用 308，保证浏览器不会改变请求方法
+	ctx.RespStatusCode = http.StatusMovedPermanently
+	if ctx.Req.Method != http.MethodGet && ctx.Req.Method != http.MethodHead {
+		ctx.RespStatusCode = http.StatusPermanentRedirect
+	}
+}
+
+func (b *MiddlewareBuilder) isHTTPS(req *http.Request) bool {
+	if req.TLS != nil {
+		return true
+	}
+	return b.trustProxy && strings.EqualFold(req.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+func (b *MiddlewareBuilder) isAllowedHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	_, ok := b.allowedHosts[strings.ToLower(host)]
+	return ok
+}
+
+// Nonce 返回当前请求的 CSP nonce
+func Nonce(ctx *web.Context) string {
+	return nonceFromContext(ctx.Req.Context())
+}
+
+// TemplateFuncs 实现了 web.TemplateFuncProvider，
+// 提供了 cspNonce 模板方法，例如
+// <script nonce="{{ cspNonce }}">...</script>
+func TemplateFuncs(ctx context.Context) template.FuncMap {
+	nonce := nonceFromContext(ctx)
+	return template.FuncMap{
+		"cspNonce": func() string {
+			return nonce
+		},
+	}
+}
+
+func nonceFromContext(ctx context.Context) string {
+	nonce, _ := ctx.Value(nonceCtxKey{}).(string)
+	return nonce
+}
+
+func newNonce() (string, error) {
+	bs := make([]byte, 16)
+	if _, err := rand.Read(bs); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bs), nil
+}
@@ -0,0 +1,165 @@
+package secure
+
+import (
+	"context"
+	"crypto/tls"
+	web "github.com/go-tour/web/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareBuilder_Build(t *testing.T) {
+	testCases := []struct {
+		name    string
+		builder *MiddlewareBuilder
+		req     func() *http.Request
+
+		wantCode   int
+		wantHeader http.Header
+	}{
+		{
+			name:    "default",
+			builder: NewMiddlewareBuilder(),
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodGet, "http://example.com/user", nil)
+			},
+			wantCode: http.StatusOK,
+			wantHeader: http.Header{
+				"X-Frame-Options":        {"DENY"},
+				"X-Content-Type-Options": {"nosniff"},
+				"Referrer-Policy":        {"strict-origin-when-cross-origin"},
+			},
+		},
+		{
+			name: "hsts over https",
+			builder: NewMiddlewareBuilder().
+				HSTS(365*24*time.Hour, true, true).
+				PermissionsPolicy("camera=()").
+				FrameOptions("").ReferrerPolicy("").ContentTypeNosniff(false),
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodGet, "https://example.com/user", nil)
+				req.TLS = &tls.ConnectionState{}
+				return req
+			},
+			wantCode: http.StatusOK,
+			wantHeader: http.Header{
+				"Strict-Transport-Security": {"max-age=31536000; includeSubDomains; preload"},
+				"Permissions-Policy":        {"camera=()"},
+			},
+		},
+		{
+			name: "no hsts over http",
+			builder: NewMiddlewareBuilder().HSTS(time.Hour, false, false).
+				FrameOptions("").ReferrerPolicy("").ContentTypeNosniff(false),
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodGet, "http://example.com/user", nil)
+			},
+			wantCode:   http.StatusOK,
+			wantHeader: http.Header{},
+		},
+		{
+			name:    "ssl redirect",
+			builder: NewMiddlewareBuilder().SSLRedirect("").FrameOptions("").ReferrerPolicy("").ContentTypeNosniff(false),
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodGet, "http://example.com/user?id=1", nil)
+			},
+			wantCode: http.StatusMovedPermanently,
+			wantHeader: http.Header{
+				"Location": {"https://example.com/user?id=1"},
+			},
+		},
+		{
+			name:    "ssl redirect post",
+			builder: NewMiddlewareBuilder().SSLRedirect("secure.example.com").FrameOptions("").ReferrerPolicy("").ContentTypeNosniff(false),
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodPost, "http://example.com/user", nil)
+			},
+			wantCode: http.StatusPermanentRedirect,
+			wantHeader: http.Header{
+				"Location": {"https://secure.example.com/user"},
+			},
+		},
+		{
+			name:    "trust proxy",
+			builder: NewMiddlewareBuilder().SSLRedirect("").TrustProxy(true).FrameOptions("").ReferrerPolicy("").ContentTypeNosniff(false),
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodGet, "http://example.com/user", nil)
+				req.Header.Set("X-Forwarded-Proto", "https")
+				return req
+			},
+			wantCode:   http.StatusOK,
+			wantHeader: http.Header{},
+		},
+		{
+			name:    "allowed host",
+			builder: NewMiddlewareBuilder().AllowedHosts("example.com").FrameOptions("").ReferrerPolicy("").ContentTypeNosniff(false),
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodGet, "http://Example.com:8081/user", nil)
+			},
+			wantCode:   http.StatusOK,
+			wantHeader: http.Header{},
+		},
+		{
+			name:    "disallowed host",
+			builder: NewMiddlewareBuilder().AllowedHosts("example.com").FrameOptions("").ReferrerPolicy("").ContentTypeNosniff(false),
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodGet, "http://evil.com/user", nil)
+			},
+			wantCode:   http.StatusBadRequest,
+			wantHeader: http.Header{},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := web.NewHTTPServer()
+			s.Use(tc.builder.Build())
+			s.Get("/user", func(ctx *web.Context) {
+				ctx.RespStatusCode = http.StatusOK
+			})
+			s.Post("/user", func(ctx *web.Context) {
+				ctx.RespStatusCode = http.StatusOK
+			})
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, tc.req())
+			assert.Equal(t, tc.wantCode, recorder.Code)
+			header := recorder.Header()
+			header.Del("Content-Type")
+			assert.Equal(t, tc.wantHeader, header)
+		})
+	}
+}
+
+func TestMiddlewareBuilder_Nonce(t *testing.T) {
+	tpl, err := template.New("page").Funcs(TemplateFuncs(context.Background())).
+		Parse(`<script nonce="{{ cspNonce }}"></script>`)
+	require.NoError(t, err)
+	engine := &web.GoTemplateEngine{T: tpl}
+	engine.RegisterFuncProvider(TemplateFuncs)
+
+	s := web.NewHTTPServer(web.ServerWithTemplateEngine(engine))
+	s.Use(NewMiddlewareBuilder().
+		ContentSecurityPolicy("script-src 'self' 'nonce-" + NoncePlaceholder + "'").Build())
+	var nonce string
+	s.Get("/page", func(ctx *web.Context) {
+		nonce = Nonce(ctx)
+		_ = ctx.Render("page", nil)
+	})
+
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/page", nil))
+	require.NotEmpty(t, nonce)
+	assert.Equal(t, "script-src 'self' 'nonce-"+nonce+"'", recorder.Header().Get("Content-Security-Policy"))
+	assert.Equal(t, `<script nonce="`+nonce+`"></script>`, recorder.Body.String())
+	assert.False(t, strings.Contains(recorder.Header().Get("Content-Security-Policy"), NoncePlaceholder))
+
+	// 每个请求的 nonce 都不一样
+	first := nonce
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/page", nil))
+	assert.NotEqual(t, first, nonce)
+}
@@ -0,0 +1,217 @@
+package shedding
+
+import (
+	web "github.com/go-tour/web/v9"
+	"github.com/prometheus/client_golang/prometheus"
+	"math"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MiddlewareBuilder 构造负载保护的 Middleware
+// 同时处理的请求数量超过了上限之后，新的请求会排队等待，
+// 等待超过 queueTimeout 之后就直接返回 503。
+//
+// 开启 Adaptive 之后，上限会根据响应时间自动调整：
+// 响应时间相比最小响应时间变长的时候，说明服务端已经开始排队了，那么就降低上限，
+// 反之则慢慢提高上限。这种方式不依赖于 CPU 使用率，所以 IO 密集的服务也适用
+type MiddlewareBuilder struct {
+	limiter      *limiter
+	queueTimeout time.Duration
+	rejectHdl    web.HandleFunc
+	rejected     atomic.Uint64
+
+	inFlightDesc *prometheus.Desc
+	limitDesc    *prometheus.Desc
+	rejectedDesc *prometheus.Desc
+}
+
+// NewMiddlewareBuilder 创建一个 MiddlewareBuilder
+// maxConcurrency 是同时处理的请求数量的上限
+func NewMiddlewareBuilder(maxConcurrency int) *MiddlewareBuilder {
+	return &MiddlewareBuilder{
+		limiter: &limiter{
+			limit:    float64(maxConcurrency),
+			minLimit: float64(maxConcurrency),
+			maxLimit: float64(maxConcurrency),
+			notify:   make(chan struct{}),
+		},
+		rejectHdl: func(ctx *web.Context) {
+			ctx.RespStatusCode = http.StatusServiceUnavailable
+			ctx.RespData = []byte("服务繁忙，请稍后再试")
+		},
+		inFlightDesc: prometheus.NewDesc("web_load_shedding_in_flight",
+			"正在处理的请求数量", nil, nil),
+		limitDesc: prometheus.NewDesc("web_load_shedding_limit",
+			"同时处理的请求数量上限", nil, nil),
+		rejectedDesc: prometheus.NewDesc("web_load_shedding_rejected_total",
+			"被拒绝的请求数量", nil, nil),
+	}
+}
+
+// QueueTimeout 请求排队等待的最长时间，默认为 0，也就是不排队
+func (b *MiddlewareBuilder) QueueTimeout(timeout time.Duration) *MiddlewareBuilder {
+	b.queueTimeout = timeout
+	return b
+}
+
+// Adaptive 开启自适应模式，上限会在 [minLimit, maxLimit] 之间调整
+// 初始的上限是 NewMiddlewareBuilder 传入的 maxConcurrency，超出范围的时候取最近的边界
+func (b *MiddlewareBuilder) Adaptive(minLimit int, maxLimit int) *MiddlewareBuilder {
+	l := b.limiter
+	l.adaptive = true
+	l.minLimit = float64(minLimit)
+	l.maxLimit = float64(maxLimit)
+	l.limit = math.Max(l.minLimit, math.Min(l.maxLimit, l.limit))
+	return b
+}
+
+// RejectHandler 请求被拒绝的时候执行，默认返回 503
+func (b *MiddlewareBuilder) RejectHandler(hdl web.HandleFunc) *MiddlewareBuilder {
+	b.rejectHdl = hdl
+	return b
+}
+
+func (b *MiddlewareBuilder) Build() web.Middleware {
+	return func(next web.HandleFunc) web.HandleFunc {
+		return func(ctx *web.Context) {
+			if !b.limiter.acquire(b.queueTimeout) {
+				b.rejected.Add(1)
+				b.rejectHdl(ctx)
+				return
+			}
+			start := time.Now()
+			defer func() {
+				b.limiter.release(time.Since(start))
+			}()
+			next(ctx)
+		}
+	}
+}
+
+// Limit 返回当前的上限
+func (b *MiddlewareBuilder) Limit() int {
+	limit, _ := b.limiter.state()
+	return limit
+}
+
+// InFlight 返回正在处理的请求数量
+func (b *MiddlewareBuilder) InFlight() int {
+	_, inFlight := b.limiter.state()
+	return inFlight
+}
+
+// Describe 实现了 prometheus.Collector 接口
+// 可以通过 prometheus 中间件的 Collectors 字段注册
+func (b *MiddlewareBuilder) Describe(ch chan<- *prometheus.Desc) {
+	ch <- b.inFlightDesc
+	ch <- b.limitDesc
+	ch <- b.rejectedDesc
+}
+
+// Collect 实现了 prometheus.Collector 接口
+func (b *MiddlewareBuilder) Collect(ch chan<- prometheus.Metric) {
+	limit, inFlight := b.limiter.state()
+	ch <- prometheus.MustNewConstMetric(b.inFlightDesc, prometheus.GaugeValue, float64(inFlight))
+	ch <- prometheus.MustNewConstMetric(b.limitDesc, prometheus.GaugeValue, float64(limit))
+	ch <- prometheus.MustNewConstMetric(b.rejectedDesc, prometheus.CounterValue,
+		float64(b.rejected.Load()))
+}
+
+const (
+	// 平滑系数，避免上限剧烈波动
+	smoothing = 0.2
+	// 最小响应时间的统计窗口，过了窗口之后重新统计，避免一直被历史上的最小值影响
+	minRTTWindow = 30 * time.Second
+)
+
+type limiter struct {
+	mutex    sync.Mutex
+	limit    float64
+	inFlight int
+	// 每次有请求结束都会关闭并且重新创建，用于唤醒排队的请求
+	notify chan struct{}
+
+	adaptive    bool
+	minLimit    float64
+	maxLimit    float64
+	minRTT      time.Duration
+	minRTTReset time.Time
+	rtt         time.Duration
+}
+
+func (l *limiter) acquire(timeout time.Duration) bool {
+	var expired <-chan time.Time
+	for {
+		l.mutex.Lock()
+		if l.inFlight < int(l.limit) {
+			l.inFlight++
+			l.mutex.Unlock()
+			return true
+		}
+		notify := l.notify
+		l.mutex.Unlock()
+
+		if timeout <= 0 {
+			return false
+		}
+		if expired == nil {
+			timer := time.NewTimer(timeout)
+			defer timer.Stop()
+			expired = timer.C
+		}
+		select {
+		case <-notify:
+		case <-expired:
+			return false
+		}
+	}
+}
+
+func (l *limiter) release(rtt time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.adaptive {
+		l.adjust(rtt, l.inFlight)
+	}
+	l.inFlight--
+	close(l.notify)
+	l.notify = make(chan struct{})
+}
+
+// adjust 根据 gradient 算法调整上限
+// gradient = minRTT / rtt，响应时间变长的时候 gradient 小于 1，上限就会下降
+// 额外加上 sqrt(limit) 作为允许排队的数量，保证上限在响应时间稳定的时候能够增长
+// inFlight 是包括这个请求在内，正在处理的请求数量
+func (l *limiter) adjust(rtt time.Duration, inFlight int) {
+	now := time.Now()
+	if l.minRTT == 0 || rtt < l.minRTT || now.After(l.minRTTReset) {
+		l.minRTT = rtt
+		l.minRTTReset = now.Add(minRTTWindow)
+	}
+	if l.rtt == 0 {
+		l.rtt = rtt
+	} else {
+		l.rtt = time.Duration(float64(l.rtt)*(1-smoothing) + float64(rtt)*smoothing)
+	}
+	if l.rtt <= 0 {
+		return
+	}
+	gradient := math.Max(0.5, math.Min(1.0, float64(l.minRTT)/float64(l.rtt)))
+	newLimit := l.limit*gradient + math.Sqrt(l.limit)
+	newLimit = l.limit*(1-smoothing) + newLimit*smoothing
+	// 正在处理的请求连上限的一半都不到，说明上限并不是瓶颈，
+	// 这个时候响应时间再短也不能说明可以承受更多的请求，所以只降不升
+	if newLimit > l.limit && float64(inFlight) < l.limit/2 {
+		return
+	}
+	l.limit = math.Max(l.minLimit, math.Min(l.maxLimit, newLimit))
+}
+
+func (l *limiter) state() (int, int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return int(l.limit), l.inFlight
+}
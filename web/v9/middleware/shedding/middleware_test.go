@@ -0,0 +1,140 @@
+package shedding
+
+import (
+	web "github.com/go-tour/web/v9"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareBuilder_Build(t *testing.T) {
+	testCases := []struct {
+		name         string
+		queueTimeout time.Duration
+		// 第二个请求到来之后多久第一个请求结束
+		release  time.Duration
+		wantCode int
+	}{
+		{
+			name:     "no queue",
+			release:  10 * time.Millisecond,
+			wantCode: http.StatusServiceUnavailable,
+		},
+		{
+			name:         "queue timeout",
+			queueTimeout: 10 * time.Millisecond,
+			release:      100 * time.Millisecond,
+			wantCode:     http.StatusServiceUnavailable,
+		},
+		{
+			name:         "queued",
+			queueTimeout: time.Second,
+			release:      10 * time.Millisecond,
+			wantCode:     http.StatusOK,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b := NewMiddlewareBuilder(1).QueueTimeout(tc.queueTimeout)
+			entered := make(chan struct{}, 2)
+			block := make(chan struct{})
+			s := web.NewHTTPServer()
+			s.Use(b.Build())
+			s.Get("/slow", func(ctx *web.Context) {
+				entered <- struct{}{}
+				<-block
+				ctx.RespStatusCode = http.StatusOK
+			})
+			s.Get("/fast", func(ctx *web.Context) {
+				ctx.RespStatusCode = http.StatusOK
+			})
+
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+			}()
+			<-entered
+			assert.Equal(t, 1, b.InFlight())
+			time.AfterFunc(tc.release, func() {
+				close(block)
+			})
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/fast", nil))
+			assert.Equal(t, tc.wantCode, recorder.Code)
+			wg.Wait()
+			assert.Equal(t, 0, b.InFlight())
+		})
+	}
+}
+
+func TestLimiter_Adaptive(t *testing.T) {
+	// 初始的上限会被限制在范围内
+	assert.Equal(t, 50, NewMiddlewareBuilder(100).Adaptive(10, 50).Limit())
+	assert.Equal(t, 10, NewMiddlewareBuilder(1).Adaptive(10, 50).Limit())
+
+	b := NewMiddlewareBuilder(100).Adaptive(10, 200)
+	l := b.limiter
+	// 请求很少的时候，即便响应时间稳定，上限也不会增长
+	for i := 0; i < 20; i++ {
+		require.True(t, l.acquire(0))
+		l.release(10 * time.Millisecond)
+	}
+	assert.Equal(t, 100, b.Limit())
+
+	// 请求数量接近上限并且响应时间稳定的时候，上限慢慢增长
+	for i := 0; i < 80; i++ {
+		require.True(t, l.acquire(0))
+	}
+	for i := 0; i < 80; i++ {
+		l.release(10 * time.Millisecond)
+	}
+	grown := b.Limit()
+	assert.Greater(t, grown, 100)
+
+	// 响应时间变长，上限下降
+	for i := 0; i < 50; i++ {
+		require.True(t, l.acquire(0))
+		l.release(100 * time.Millisecond)
+	}
+	assert.Less(t, b.Limit(), grown)
+	assert.GreaterOrEqual(t, b.Limit(), 10)
+
+	// 不会超过上下限
+	for i := 0; i < 1000; i++ {
+		require.True(t, l.acquire(0))
+		l.release(time.Second)
+	}
+	assert.Equal(t, 10, b.Limit())
+}
+
+func TestMiddlewareBuilder_Collect(t *testing.T) {
+	b := NewMiddlewareBuilder(0)
+	s := web.NewHTTPServer()
+	s.Use(b.Build())
+	s.Get("/", func(ctx *web.Context) {})
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	reg := prometheus.NewPedanticRegistry()
+	require.NoError(t, reg.Register(b))
+	err := testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP web_load_shedding_in_flight 正在处理的请求数量
+# TYPE web_load_shedding_in_flight gauge
+web_load_shedding_in_flight 0
+# HELP web_load_shedding_limit 同时处理的请求数量上限
+# TYPE web_load_shedding_limit gauge
+web_load_shedding_limit 0
+# HELP web_load_shedding_rejected_total 被拒绝的请求数量
+# TYPE web_load_shedding_rejected_total counter
+web_load_shedding_rejected_total 1
+`))
+	assert.NoError(t, err)
+}
@@ -2,18 +2,52 @@ package v9
 
 import (
 	"fmt"
+	"net/url"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 )
 
+// routeTree 路由树，HTTPServer 默认使用按段组织的 router，
+// 也可以通过 ServerWithRadixRouter 使用 radixRouter。
+// 两者的匹配规则完全一样
+type routeTree interface {
+	addRoute(method string, path string, handler HandleFunc, opts ...RouteOption)
+	// match 查找路由
+	// 注意，matchInfo.n 不为 nil 并且 HandleFunc 不为 nil 才算是注册了路由
+	match(method string, path string) (matchInfo, bool)
+	routes() []RouteInfo
+	URLFor(name string, params map[string]string, query url.Values) (string, error)
+	// routeOf 返回名字对应的路由
+	routeOf(name string) (string, bool)
+}
+
+var _ routeTree = &router{}
+
 type router struct {
 	// trees 是按照 HTTP 方法来组织的
 	// 如 GET => *node
 	trees map[string]*node
+	namedRoutes
 }
 
 func newRouter() router {
 	return router{
-		trees: map[string]*node{},
+		trees:       map[string]*node{},
+		namedRoutes: namedRoutes{names: map[string]string{}},
+	}
+}
+
+// RouteOption 注册路由时候的额外设置
+type RouteOption func(n *node)
+
+// WithRouteName 给路由起一个名字，之后可以通过 URLFor 反向生成 URL
+// 名字不能重复，但是不同 HTTP 方法的同一个路由可以使用同一个名字
+func WithRouteName(name string) RouteOption {
+	return func(n *node) {
+		n.name = name
 	}
 }
 
@@ -24,17 +58,8 @@ func newRouter() router {
 // - 不能在同一个位置注册不同的参数路由，例如 /user/:id 和 /user/:name 冲突
 // - 不能在同一个位置同时注册通配符路由和参数路由，例如 /user/:id 和 /user/* 冲突
 // - 同名路径参数，在路由匹配的时候，值会被覆盖。例如 /user/:id/abc/:id，那么 /user/123/abc/456 最终 id = 456
-func (r *router) addRoute(method string, path string, handler HandleFunc) {
-	if path == "" {
-		panic("web: 路由是空字符串")
-	}
-	if path[0] != '/' {
-		panic("web: 路由必须以 / 开头")
-	}
-
-	if path != "/" && path[len(path)-1] == '/' {
-		panic("web: 路由不能以 / 结尾")
-	}
+func (r *router) addRoute(method string, path string, handler HandleFunc, opts ...RouteOption) {
+	checkRoute(path)
 
 	root, ok := r.trees[method]
 	// 这是一个全新的 HTTP 方法，创建根节点
@@ -48,6 +73,8 @@ func (r *router) addRoute(method string, path string, handler HandleFunc) {
 			panic("web: 路由冲突[/]")
 		}
 		root.handler = handler
+		root.route = "/"
+		r.setOptions(root, opts)
 		return
 	}
 
@@ -64,31 +91,203 @@ func (r *router) addRoute(method string, path string, handler HandleFunc) {
 	}
 	root.handler = handler
 	root.route = path
+	r.setOptions(root, opts)
+}
+
+// namedRoutes 路由的名字，两种路由树共用
+type namedRoutes struct {
+	// names 路由的名字 => 路由
+	names map[string]string
+}
+
+// checkRoute 检查 path 的格式，两种路由树共用
+func checkRoute(path string) {
+	if path == "" {
+		panic("web: 路由是空字符串")
+	}
+	if path[0] != '/' {
+		panic("web: 路由必须以 / 开头")
+	}
+
+	if path != "/" && path[len(path)-1] == '/' {
+		panic("web: 路由不能以 / 结尾")
+	}
+}
+
+func (r *namedRoutes) setOptions(n *node, opts []RouteOption) {
+	for _, opt := range opts {
+		opt(n)
+	}
+	if n.name == "" {
+		return
+	}
+	if r.names == nil {
+		r.names = map[string]string{}
+	}
+	if route, ok := r.names[n.name]; ok && route != n.route {
+		panic(fmt.Sprintf("web: 路由名字冲突 %s，已有 %s，新注册 %s", n.name, route, n.route))
+	}
+	r.names[n.name] = n.route
 }
 
-// findRoute 查找对应的节点
+// URLFor 根据路由的名字生成 URL
+// params 是路径参数，例如 /user/:id 里面的 id；
+// 通配符按照出现的顺序使用 *1、*2 作为 key，只有一个通配符的时候也可以使用 *
+// query 是查询参数，可以为 nil
+func (r *namedRoutes) URLFor(name string, params map[string]string, query url.Values) (string, error) {
+	route, ok := r.names[name]
+	if !ok {
+		return "", fmt.Errorf("web: 找不到路由 %s", name)
+	}
+	if route == "/" {
+		return withQuery(route, query), nil
+	}
+	segs := strings.Split(route[1:], "/")
+	stars := 0
+	for i, seg := range segs {
+		var key string
+		switch {
+		case seg == "*":
+			stars++
+			key = "*" + strconv.Itoa(stars)
+			if _, ok = params[key]; !ok && stars == 1 {
+				key = "*"
+			}
+		case seg[0] == ':':
+			key = seg[1:]
+		default:
+			continue
+		}
+		val, ok := params[key]
+		if !ok || val == "" {
+			return "", fmt.Errorf("web: 路由 %s 缺少参数 %s", route, key)
+		}
+		// 参数和通配符都只能匹配一段，所以 / 也需要转义
+		segs[i] = url.PathEscape(val)
+	}
+	return withQuery("/"+strings.Join(segs, "/"), query), nil
+}
+
+func (r *namedRoutes) routeOf(name string) (string, bool) {
+	route, ok := r.names[name]
+	return route, ok
+}
+
+// routeParams 返回路由里面所有的参数名字，通配符使用 URLFor 里面的 key
+func routeParams(route string) map[string]struct{} {
+	res := make(map[string]struct{}, 2)
+	stars := 0
+	for _, seg := range strings.Split(strings.Trim(route, "/"), "/") {
+		switch {
+		case seg == "*":
+			stars++
+			res["*"+strconv.Itoa(stars)] = struct{}{}
+			if stars == 1 {
+				res["*"] = struct{}{}
+			}
+		case strings.HasPrefix(seg, ":"):
+			res[seg[1:]] = struct{}{}
+		}
+	}
+	return res
+}
+
+func withQuery(path string, query url.Values) string {
+	if len(query) == 0 {
+		return path
+	}
+	return path + "?" + query.Encode()
+}
+
+// RouteInfo 注册的路由
+type RouteInfo struct {
+	Method string
+	Path   string
+	Name   string
+	// Handler 处理函数的名字
+	Handler string
+	// Middlewares 作用在这个路由上的 Middleware 的名字，按照执行顺序排列
+	Middlewares []string
+}
+
+// routes 返回所有注册的路由，按照路径和方法排序
+func (r *router) routes() []RouteInfo {
+	res := make([]RouteInfo, 0, 16)
+	for method, root := range r.trees {
+		root.walk(func(n *node) {
+			res = n.appendRoute(res, method)
+		})
+	}
+	sortRoutes(res)
+	return res
+}
+
+// appendRoute 如果 n 上注册了路由，就追加到 res 里面
+func (n *node) appendRoute(res []RouteInfo, method string) []RouteInfo {
+	if n == nil || n.handler == nil {
+		return res
+	}
+	return append(res, RouteInfo{
+		Method:  method,
+		Path:    n.route,
+		Name:    n.name,
+		Handler: funcName(n.handler),
+	})
+}
+
+func sortRoutes(res []RouteInfo) {
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Path != res[j].Path {
+			return res[i].Path < res[j].Path
+		}
+		return res[i].Method < res[j].Method
+	})
+}
+
+// funcName 返回函数的名字，用于打印日志
+func funcName(fn any) string {
+	f := runtime.FuncForPC(reflect.ValueOf(fn).Pointer())
+	if f == nil {
+		return ""
+	}
+	return f.Name()
+}
+
+// match 查找对应的节点，返回值而不是指针，
+// 这样 matchInfo 不需要分配内存，只有命中参数路由的时候才需要分配 pathParams
 // 注意，返回的 node 内部 HandleFunc 不为 nil 才算是注册了路由
-func (r *router) findRoute(method string, path string) (*matchInfo, bool) {
+func (r *router) match(method string, path string) (matchInfo, bool) {
+	var mi matchInfo
 	root, ok := r.trees[method]
 	if !ok {
-		return nil, false
+		return mi, false
 	}
 
 	if path == "/" {
-		return &matchInfo{n: root}, true
+		mi.n = root
+		return mi, true
 	}
 
-	segs := strings.Split(strings.Trim(path, "/"), "/")
-	mi := &matchInfo{}
-	for _, s := range segs {
+	// 逐段遍历，避免 strings.Split 分配切片
+	path = strings.Trim(path, "/")
+	for {
+		seg := path
+		idx := strings.IndexByte(path, '/')
+		if idx >= 0 {
+			seg = path[:idx]
+		}
 		var matchParam bool
-		root, matchParam, ok = root.childOf(s)
+		root, matchParam, ok = root.childOf(seg)
 		if !ok {
-			return nil, false
+			return mi, false
 		}
 		if matchParam {
-			mi.addValue(root.path[1:], s)
+			mi.addValue(root.path[1:], seg)
+		}
+		if idx < 0 {
+			break
 		}
+		path = path[idx+1:]
 	}
 	mi.n = root
 	return mi, true
@@ -110,6 +309,9 @@ type node struct {
 	// route 到达该节点的完整的路由路径
 	route string
 
+	// name 路由的名字，用于 URLFor
+	name string
+
 	// 通配符 * 表达的节点，任意匹配
 	starChild *node
 
@@ -143,10 +345,14 @@ func (n *node) childOf(path string) (*node, bool, bool) {
 // 最后会从 children 里面查找，
 // 如果没有找到，那么会创建一个新的节点，并且保存在 node 里面
 func (n *node) childOrCreate(path string) *node {
-	if path == "*" {
+	if path == "*" || path[0] == ':' {
+		paramPath := ""
 		if n.paramChild != nil {
-			panic(fmt.Sprintf("web: 非法路由，已有路径参数路由。不允许同时注册通配符路由和参数路由 [%s]", path))
+			paramPath = n.paramChild.path
 		}
+		checkWildcard(path, paramPath, n.starChild != nil)
+	}
+	if path == "*" {
 		if n.starChild == nil {
 			n.starChild = &node{path: path}
 		}
@@ -155,14 +361,7 @@ func (n *node) childOrCreate(path string) *node {
 
 	// 以 : 开头，我们认为是参数路由
 	if path[0] == ':' {
-		if n.starChild != nil {
-			panic(fmt.Sprintf("web: 非法路由，已有通配符路由。不允许同时注册通配符路由和参数路由 [%s]", path))
-		}
-		if n.paramChild != nil {
-			if n.paramChild.path != path {
-				panic(fmt.Sprintf("web: 路由冲突，参数路由冲突，已有 %s，新注册 %s", n.paramChild.path, path))
-			}
-		} else {
+		if n.paramChild == nil {
 			n.paramChild = &node{path: path}
 		}
 		return n.paramChild
@@ -179,11 +378,50 @@ func (n *node) childOrCreate(path string) *node {
 	return child
 }
 
+// checkWildcard 同一个位置只能注册一个参数路由或者通配符路由
+// paramPath 是这个位置已有的参数路由，hasStar 代表已有通配符路由
+func checkWildcard(path string, paramPath string, hasStar bool) {
+	if path == "*" {
+		if paramPath != "" {
+			panic(fmt.Sprintf("web: 非法路由，已有路径参数路由。不允许同时注册通配符路由和参数路由 [%s]", path))
+		}
+		return
+	}
+	if hasStar {
+		panic(fmt.Sprintf("web: 非法路由，已有通配符路由。不允许同时注册通配符路由和参数路由 [%s]", path))
+	}
+	if paramPath != "" && paramPath != path {
+		panic(fmt.Sprintf("web: 路由冲突，参数路由冲突，已有 %s，新注册 %s", paramPath, path))
+	}
+}
+
+// walk 遍历以 n 为根的路由树
+func (n *node) walk(fn func(n *node)) {
+	fn(n)
+	for _, child := range n.children {
+		child.walk(fn)
+	}
+	if n.paramChild != nil {
+		n.paramChild.walk(fn)
+	}
+	if n.starChild != nil {
+		n.starChild.walk(fn)
+	}
+}
+
 type matchInfo struct {
 	n          *node
 	pathParams map[string]string
 }
 
+// handler 命中的 HandleFunc，没有注册路由的时候返回 nil
+func (m *matchInfo) handler() HandleFunc {
+	if m.n == nil {
+		return nil
+	}
+	return m.n.handler
+}
+
 func (m *matchInfo) addValue(key string, value string) {
 	if m.pathParams == nil {
 		// 大多数情况，参数路径只会有一段
@@ -0,0 +1,277 @@
+package v9
+
+import (
+	"fmt"
+	"strings"
+)
+
+var _ routeTree = &radixRouter{}
+
+// radixRouter 压缩前缀树实现的路由树，
+// 连续的静态路径会被压缩到同一个节点里面，例如 /user/home 和 /user/profile
+// 只有 user/ 、home 和 profile 三个节点，匹配的时候逐个字节比较，不需要查 map。
+// 匹配规则和 router 完全一样：
+// 1. 依旧按段匹配，参数和通配符都只能匹配一段
+// 2. 静态匹配 > 参数匹配 > 通配符匹配，不回溯
+type radixRouter struct {
+	trees map[string]*radixNode
+	namedRoutes
+}
+
+func newRadixRouter() *radixRouter {
+	return &radixRouter{
+		trees:       map[string]*radixNode{},
+		namedRoutes: namedRoutes{names: map[string]string{}},
+	}
+}
+
+// addRoute 和 router 的 addRoute 规则一样
+func (r *radixRouter) addRoute(method string, path string, handler HandleFunc, opts ...RouteOption) {
+	checkRoute(path)
+	if strings.Contains(path, "//") {
+		panic(fmt.Sprintf("web: 非法路由。不允许使用 //a/b, /a//b 之类的路由, [%s]", path))
+	}
+
+	root, ok := r.trees[method]
+	if !ok {
+		root = &radixNode{}
+		r.trees[method] = root
+	}
+
+	n, rest := root, path[1:]
+	for rest != "" {
+		// 静态的部分一直到下一个参数段或者通配符段为止
+		idx := wildcardIndex(rest)
+		if idx != 0 {
+			if idx < 0 {
+				idx = len(rest)
+			}
+			n = n.staticChildOrCreate(rest[:idx])
+			rest = rest[idx:]
+			continue
+		}
+		end := strings.IndexByte(rest, '/')
+		if end < 0 {
+			end = len(rest)
+		}
+		n = n.wildcardChildOrCreate(rest[:end])
+		rest = rest[end:]
+	}
+	if n.endpoint != nil {
+		panic(fmt.Sprintf("web: 路由冲突[%s]", path))
+	}
+	last := path[strings.LastIndexByte(path, '/')+1:]
+	if path == "/" {
+		last = path
+	}
+	// 为了和 RouteOption 以及 matchInfo 兼容，命中的路由依旧使用 node 表示
+	n.endpoint = &node{path: last, handler: handler, route: path}
+	r.setOptions(n.endpoint, opts)
+}
+
+// wildcardIndex 返回 path 里面第一个参数段或者通配符段的位置，没有就返回 -1
+// path 的开头必须是一段的开始
+func wildcardIndex(path string) int {
+	for start := 0; start < len(path); {
+		end := strings.IndexByte(path[start:], '/')
+		if end < 0 {
+			end = len(path)
+		} else {
+			end += start
+		}
+		if seg := path[start:end]; seg == "*" || (seg != "" && seg[0] == ':') {
+			return start
+		}
+		start = end + 1
+	}
+	return -1
+}
+
+func (r *radixRouter) match(method string, path string) (matchInfo, bool) {
+	var mi matchInfo
+	root, ok := r.trees[method]
+	if !ok {
+		return mi, false
+	}
+	if path == "/" {
+		mi.n = root.endpoint
+		return mi, true
+	}
+
+	path = strings.Trim(path, "/")
+	// n 和 offset 表示当前在树里面的位置，也就是 n.path[:offset] 已经匹配了
+	n, offset := root, 0
+	for {
+		seg := path
+		idx := strings.IndexByte(path, '/')
+		if idx >= 0 {
+			seg = path[:idx]
+		}
+		if sn, so, ok := n.matchStatic(offset, seg); ok {
+			n, offset = sn, so
+		} else if offset == len(n.path) && n.paramChild != nil {
+			n = n.paramChild
+			offset = len(n.path)
+			mi.addValue(n.path[1:], seg)
+		} else if offset == len(n.path) && n.starChild != nil {
+			n = n.starChild
+			offset = len(n.path)
+		} else {
+			return mi, false
+		}
+		if idx < 0 {
+			break
+		}
+		path = path[idx+1:]
+		// 进入下一段之前先匹配 /
+		if n, offset, ok = n.matchSlash(offset); !ok {
+			return mi, false
+		}
+	}
+	// 停在节点中间的时候，说明这个位置没有注册路由
+	if offset == len(n.path) {
+		mi.n = n.endpoint
+	}
+	return mi, true
+}
+
+func (r *radixRouter) routes() []RouteInfo {
+	res := make([]RouteInfo, 0, 16)
+	for method, root := range r.trees {
+		root.walk(func(n *radixNode) {
+			res = n.endpoint.appendRoute(res, method)
+		})
+	}
+	sortRoutes(res)
+	return res
+}
+
+// radixNode 压缩前缀树的节点
+// 参数节点和通配符节点只会出现在一段开始的位置，也就是 path 以 / 结尾的节点或者根节点下面
+type radixNode struct {
+	// path 静态节点可能包含多段，例如 user/home；
+	// 参数节点是 :id 这种形式，通配符节点是 *
+	path string
+	// indices children 里面每一个子节点 path 的第一个字节
+	indices  string
+	children []*radixNode
+
+	paramChild *radixNode
+	starChild  *radixNode
+
+	// endpoint 在这里结束的路由，没有的时候为 nil
+	endpoint *node
+}
+
+// staticChildOrCreate 插入静态的路径，必要的时候拆分已有的节点
+func (n *radixNode) staticChildOrCreate(path string) *radixNode {
+	for path != "" {
+		idx := strings.IndexByte(n.indices, path[0])
+		if idx < 0 {
+			child := &radixNode{path: path}
+			n.indices += path[:1]
+			n.children = append(n.children, child)
+			return child
+		}
+		child := n.children[idx]
+		l := commonPrefixLen(child.path, path)
+		if l < len(child.path) {
+			// 拆分成公共前缀和剩余部分，剩余部分保留原本的子节点
+			prefix := &radixNode{
+				path:     child.path[:l],
+				indices:  child.path[l : l+1],
+				children: []*radixNode{child},
+			}
+			child.path = child.path[l:]
+			n.children[idx] = prefix
+			child = prefix
+		}
+		n = child
+		path = path[l:]
+	}
+	return n
+}
+
+func (n *radixNode) wildcardChildOrCreate(path string) *radixNode {
+	paramPath := ""
+	if n.paramChild != nil {
+		paramPath = n.paramChild.path
+	}
+	checkWildcard(path, paramPath, n.starChild != nil)
+	if path == "*" {
+		if n.starChild == nil {
+			n.starChild = &radixNode{path: path}
+		}
+		return n.starChild
+	}
+	if n.paramChild == nil {
+		n.paramChild = &radixNode{path: path}
+	}
+	return n.paramChild
+}
+
+// matchStatic 从 n.path[offset] 开始静态匹配 seg，
+// 只有 seg 正好是某个路由里面完整的一段的时候才算命中
+func (n *radixNode) matchStatic(offset int, seg string) (*radixNode, int, bool) {
+	// 路由里面不会有空的段
+	if seg == "" {
+		return nil, 0, false
+	}
+	for seg != "" {
+		if offset == len(n.path) {
+			idx := strings.IndexByte(n.indices, seg[0])
+			if idx < 0 {
+				return nil, 0, false
+			}
+			n, offset = n.children[idx], 0
+		}
+		l := len(n.path) - offset
+		if l > len(seg) {
+			l = len(seg)
+		}
+		if n.path[offset:offset+l] != seg[:l] {
+			return nil, 0, false
+		}
+		offset += l
+		seg = seg[l:]
+	}
+	// 后面紧跟着 / 或者有路由在这里结束，才是完整的一段
+	if offset < len(n.path) {
+		return n, offset, n.path[offset] == '/'
+	}
+	return n, offset, n.endpoint != nil || strings.IndexByte(n.indices, '/') >= 0
+}
+
+// matchSlash 匹配段之间的 /
+func (n *radixNode) matchSlash(offset int) (*radixNode, int, bool) {
+	if offset < len(n.path) {
+		return n, offset + 1, n.path[offset] == '/'
+	}
+	idx := strings.IndexByte(n.indices, '/')
+	if idx < 0 {
+		return nil, 0, false
+	}
+	return n.children[idx], 1, true
+}
+
+// walk 遍历以 n 为根的路由树
+func (n *radixNode) walk(fn func(n *radixNode)) {
+	fn(n)
+	for _, child := range n.children {
+		child.walk(fn)
+	}
+	if n.paramChild != nil {
+		n.paramChild.walk(fn)
+	}
+	if n.starChild != nil {
+		n.starChild.walk(fn)
+	}
+}
+
+func commonPrefixLen(a, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
@@ -1,9 +1,11 @@
 package v9
 
 import (
+	"errors"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"net/http"
+	"net/url"
 	"reflect"
 	"testing"
 )
@@ -125,68 +127,72 @@ func Test_router_AddRoute(t *testing.T) {
 	msg, ok := wantRouter.equal(r)
 	assert.True(t, ok, msg)
 
-	// 非法用例
-	r = newRouter()
+	// 非法用例，两种路由树的规则是一样的
+	for name, newTree := range testRouteTrees {
+		t.Run(name, func(t *testing.T) {
+			r := newTree()
 
-	// 空字符串
-	assert.PanicsWithValue(t, "web: 路由是空字符串", func() {
-		r.addRoute(http.MethodGet, "", mockHandler)
-	})
+			// 空字符串
+			assert.PanicsWithValue(t, "web: 路由是空字符串", func() {
+				r.addRoute(http.MethodGet, "", mockHandler)
+			})
 
-	// 前导没有 /
-	assert.PanicsWithValue(t, "web: 路由必须以 / 开头", func() {
-		r.addRoute(http.MethodGet, "a/b/c", mockHandler)
-	})
+			// 前导没有 /
+			assert.PanicsWithValue(t, "web: 路由必须以 / 开头", func() {
+				r.addRoute(http.MethodGet, "a/b/c", mockHandler)
+			})
 
-	// 后缀有 /
-	assert.PanicsWithValue(t, "web: 路由不能以 / 结尾", func() {
-		r.addRoute(http.MethodGet, "/a/b/c/", mockHandler)
-	})
+			// 后缀有 /
+			assert.PanicsWithValue(t, "web: 路由不能以 / 结尾", func() {
+				r.addRoute(http.MethodGet, "/a/b/c/", mockHandler)
+			})
 
-	// 根节点重复注册
-	r.addRoute(http.MethodGet, "/", mockHandler)
-	assert.PanicsWithValue(t, "web: 路由冲突[/]", func() {
-		r.addRoute(http.MethodGet, "/", mockHandler)
-	})
-	// 普通节点重复注册
-	r.addRoute(http.MethodGet, "/a/b/c", mockHandler)
-	assert.PanicsWithValue(t, "web: 路由冲突[/a/b/c]", func() {
-		r.addRoute(http.MethodGet, "/a/b/c", mockHandler)
-	})
+			// 根节点重复注册
+			r.addRoute(http.MethodGet, "/", mockHandler)
+			assert.PanicsWithValue(t, "web: 路由冲突[/]", func() {
+				r.addRoute(http.MethodGet, "/", mockHandler)
+			})
+			// 普通节点重复注册
+			r.addRoute(http.MethodGet, "/a/b/c", mockHandler)
+			assert.PanicsWithValue(t, "web: 路由冲突[/a/b/c]", func() {
+				r.addRoute(http.MethodGet, "/a/b/c", mockHandler)
+			})
 
-	// 多个 /
-	assert.PanicsWithValue(t, "web: 非法路由。不允许使用 //a/b, /a//b 之类的路由, [/a//b]", func() {
-		r.addRoute(http.MethodGet, "/a//b", mockHandler)
-	})
-	assert.PanicsWithValue(t, "web: 非法路由。不允许使用 //a/b, /a//b 之类的路由, [//a/b]", func() {
-		r.addRoute(http.MethodGet, "//a/b", mockHandler)
-	})
+			// 多个 /
+			assert.PanicsWithValue(t, "web: 非法路由。不允许使用 //a/b, /a//b 之类的路由, [/a//b]", func() {
+				r.addRoute(http.MethodGet, "/a//b", mockHandler)
+			})
+			assert.PanicsWithValue(t, "web: 非法路由。不允许使用 //a/b, /a//b 之类的路由, [//a/b]", func() {
+				r.addRoute(http.MethodGet, "//a/b", mockHandler)
+			})
 
-	// 同时注册通配符路由和参数路由
-	assert.PanicsWithValue(t, "web: 非法路由，已有通配符路由。不允许同时注册通配符路由和参数路由 [:id]", func() {
-		r.addRoute(http.MethodGet, "/a/*", mockHandler)
-		r.addRoute(http.MethodGet, "/a/:id", mockHandler)
-	})
-	assert.PanicsWithValue(t, "web: 非法路由，已有路径参数路由。不允许同时注册通配符路由和参数路由 [*]", func() {
-		r.addRoute(http.MethodGet, "/a/b/:id", mockHandler)
-		r.addRoute(http.MethodGet, "/a/b/*", mockHandler)
-	})
-	r = newRouter()
-	assert.PanicsWithValue(t, "web: 非法路由，已有通配符路由。不允许同时注册通配符路由和参数路由 [:id]", func() {
-		r.addRoute(http.MethodGet, "/*", mockHandler)
-		r.addRoute(http.MethodGet, "/:id", mockHandler)
-	})
-	r = newRouter()
-	assert.PanicsWithValue(t, "web: 非法路由，已有路径参数路由。不允许同时注册通配符路由和参数路由 [*]", func() {
-		r.addRoute(http.MethodGet, "/:id", mockHandler)
-		r.addRoute(http.MethodGet, "/*", mockHandler)
-	})
+			// 同时注册通配符路由和参数路由
+			assert.PanicsWithValue(t, "web: 非法路由，已有通配符路由。不允许同时注册通配符路由和参数路由 [:id]", func() {
+				r.addRoute(http.MethodGet, "/a/*", mockHandler)
+				r.addRoute(http.MethodGet, "/a/:id", mockHandler)
+			})
+			assert.PanicsWithValue(t, "web: 非法路由，已有路径参数路由。不允许同时注册通配符路由和参数路由 [*]", func() {
+				r.addRoute(http.MethodGet, "/a/b/:id", mockHandler)
+				r.addRoute(http.MethodGet, "/a/b/*", mockHandler)
+			})
+			r = newTree()
+			assert.PanicsWithValue(t, "web: 非法路由，已有通配符路由。不允许同时注册通配符路由和参数路由 [:id]", func() {
+				r.addRoute(http.MethodGet, "/*", mockHandler)
+				r.addRoute(http.MethodGet, "/:id", mockHandler)
+			})
+			r = newTree()
+			assert.PanicsWithValue(t, "web: 非法路由，已有路径参数路由。不允许同时注册通配符路由和参数路由 [*]", func() {
+				r.addRoute(http.MethodGet, "/:id", mockHandler)
+				r.addRoute(http.MethodGet, "/*", mockHandler)
+			})
 
-	// 参数冲突
-	assert.PanicsWithValue(t, "web: 路由冲突，参数路由冲突，已有 :id，新注册 :name", func() {
-		r.addRoute(http.MethodGet, "/a/b/c/:id", mockHandler)
-		r.addRoute(http.MethodGet, "/a/b/c/:name", mockHandler)
-	})
+			// 参数冲突
+			assert.PanicsWithValue(t, "web: 路由冲突，参数路由冲突，已有 :id，新注册 :name", func() {
+				r.addRoute(http.MethodGet, "/a/b/c/:id", mockHandler)
+				r.addRoute(http.MethodGet, "/a/b/c/:name", mockHandler)
+			})
+		})
+	}
 }
 
 func (r router) equal(y router) (string, bool) {
@@ -429,23 +435,260 @@ func Test_router_findRoute(t *testing.T) {
 		},
 	}
 
-	r := newRouter()
-	for _, tr := range testRoutes {
-		r.addRoute(tr.method, tr.path, mockHandler)
+	for name, newTree := range testRouteTrees {
+		r := newTree()
+		for _, tr := range testRoutes {
+			r.addRoute(tr.method, tr.path, mockHandler)
+		}
+
+		for _, tc := range testCases {
+			t.Run(name+"/"+tc.name, func(t *testing.T) {
+				mi, found := r.match(tc.method, tc.path)
+				assert.Equal(t, tc.found, found)
+				if !found {
+					return
+				}
+				assert.Equal(t, tc.mi.pathParams, mi.pathParams)
+				wantVal := reflect.ValueOf(tc.mi.n.handler)
+				nVal := reflect.ValueOf(mi.handler())
+				assert.Equal(t, wantVal, nVal)
+			})
+		}
 	}
+}
+
+// testRouteTrees 两种路由树都需要通过同样的测试
+var testRouteTrees = map[string]func() routeTree{
+	"router": func() routeTree {
+		r := newRouter()
+		return &r
+	},
+	"radix": func() routeTree {
+		return newRadixRouter()
+	},
+}
+
+func Test_router_URLFor(t *testing.T) {
+	mockHandler := func(ctx *Context) {}
+	r := newRouter()
+	r.addRoute(http.MethodGet, "/", mockHandler, WithRouteName("home"))
+	r.addRoute(http.MethodGet, "/user/:id", mockHandler, WithRouteName("user.detail"))
+	r.addRoute(http.MethodPost, "/user/:id", mockHandler, WithRouteName("user.detail"))
+	r.addRoute(http.MethodGet, "/order/*", mockHandler, WithRouteName("order"))
+	r.addRoute(http.MethodGet, "/*/abc/*", mockHandler, WithRouteName("stars"))
+	r.addRoute(http.MethodGet, "/param/:id/*", mockHandler, WithRouteName("param.star"))
+	r.addRoute(http.MethodGet, "/anonymous", mockHandler)
+
+	testCases := []struct {
+		name      string
+		routeName string
+		params    map[string]string
+		query     url.Values
 
+		wantURL string
+		wantErr error
+	}{
+		{
+			name:      "root",
+			routeName: "home",
+			query:     url.Values{"page": []string{"2"}},
+			wantURL:   "/?page=2",
+		},
+		{
+			name:      "param",
+			routeName: "user.detail",
+			params:    map[string]string{"id": "123"},
+			wantURL:   "/user/123",
+		},
+		{
+			name:      "param escape",
+			routeName: "user.detail",
+			params:    map[string]string{"id": "a/b c"},
+			query:     url.Values{"tab": []string{"orders & items"}},
+			wantURL:   "/user/a%2Fb%20c?tab=orders+%26+items",
+		},
+		{
+			name:      "star",
+			routeName: "order",
+			params:    map[string]string{"*": "detail"},
+			wantURL:   "/order/detail",
+		},
+		{
+			name:      "star by index",
+			routeName: "order",
+			params:    map[string]string{"*1": "detail"},
+			wantURL:   "/order/detail",
+		},
+		{
+			name:      "stars",
+			routeName: "stars",
+			params:    map[string]string{"*1": "a", "*2": "b"},
+			wantURL:   "/a/abc/b",
+		},
+		{
+			name:      "param and star",
+			routeName: "param.star",
+			params:    map[string]string{"id": "123", "*": "detail"},
+			wantURL:   "/param/123/detail",
+		},
+		{
+			name:      "missing param",
+			routeName: "user.detail",
+			wantErr:   errors.New("web: 路由 /user/:id 缺少参数 id"),
+		},
+		{
+			name:      "empty param",
+			routeName: "user.detail",
+			params:    map[string]string{"id": ""},
+			wantErr:   errors.New("web: 路由 /user/:id 缺少参数 id"),
+		},
+		{
+			name:      "missing star",
+			routeName: "stars",
+			params:    map[string]string{"*1": "a"},
+			wantErr:   errors.New("web: 路由 /*/abc/* 缺少参数 *2"),
+		},
+		{
+			name:      "unknown route",
+			routeName: "unknown",
+			wantErr:   errors.New("web: 找不到路由 unknown"),
+		},
+	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			mi, found := r.findRoute(tc.method, tc.path)
-			assert.Equal(t, tc.found, found)
-			if !found {
-				return
+			res, err := r.URLFor(tc.routeName, tc.params, tc.query)
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.wantURL, res)
+		})
+	}
+
+	// 不同的路由不能使用同一个名字
+	assert.PanicsWithValue(t, "web: 路由名字冲突 home，已有 /，新注册 /home", func() {
+		r.addRoute(http.MethodGet, "/home", mockHandler, WithRouteName("home"))
+	})
+}
+
+func Test_router_matchAllocs(t *testing.T) {
+	for name, newTree := range testRouteTrees {
+		r := newTree()
+		for _, route := range benchRoutes {
+			r.addRoute(route.method, route.path, mockBenchHandler)
+		}
+		// 没有路径参数的时候不需要分配内存
+		for _, path := range []string{"/", "/user/home", "/search/users", "/static/app.js"} {
+			allocs := testing.AllocsPerRun(100, func() {
+				r.match(http.MethodGet, path)
+			})
+			assert.Equal(t, float64(0), allocs, name+path)
+		}
+	}
+}
+
+// Test_radixRouter_sameAsRouter 两种路由树对同样的请求必须得到同样的结果
+func Test_radixRouter_sameAsRouter(t *testing.T) {
+	routes := []string{
+		"/", "/user", "/user/home", "/user/homepage", "/users", "/user/:id",
+		"/user/:id/orders", "/user/:id/orders/:oid", "/order/*", "/order/detail",
+		"/*/abc", "/*/abc/*", "/a:b/c", "/*x/y", "/api/:version/*/config",
+		"/api/v1", "/api/v1/status", "/docs/:lang", "/docs/en/intro",
+	}
+	paths := []string{
+		"/", "//", "/user", "/user/", "/user/home", "/user/hom", "/user/homes",
+		"/user/homepage", "/users", "/userss", "/user/123", "/user/123/orders",
+		"/user/123/orders/456", "/user/123/orders/456/789", "/user/home/orders",
+		"/order", "/order/detail", "/order/delete", "/order/delete/1",
+		"/x/abc", "/x/abc/y", "/x/abd", "/a:b/c", "/a:b", "/*x/y", "/zz/y",
+		"/api/v1", "/api/v1/status", "/api/v2/gw/config", "/api/v1/gw/config",
+		"/docs/en", "/docs/en/intro", "/docs/fr", "/docs/fr/intro",
+		"/user//home", "/user/123//orders", "///", "/%E4%B8%AD/abc",
+	}
+	tree, radix := newRouter(), newRadixRouter()
+	handlers := make(map[string]HandleFunc, len(routes))
+	for _, route := range routes {
+		route := route
+		handlers[route] = func(ctx *Context) { ctx.MatchedRoute = route }
+		tree.addRoute(http.MethodGet, route, handlers[route], WithRouteName(route))
+		radix.addRoute(http.MethodGet, route, handlers[route], WithRouteName(route))
+	}
+
+	for _, path := range paths {
+		t.Run(path, func(t *testing.T) {
+			want, wantFound := tree.match(http.MethodGet, path)
+			got, found := radix.match(http.MethodGet, path)
+			assert.Equal(t, wantFound, found)
+			assert.Equal(t, want.pathParams, got.pathParams)
+			assert.Equal(t, reflect.ValueOf(want.handler()), reflect.ValueOf(got.handler()))
+			if want.handler() != nil {
+				assert.Equal(t, want.n.route, got.n.route)
 			}
-			assert.Equal(t, tc.mi.pathParams, mi.pathParams)
-			n := mi.n
-			wantVal := reflect.ValueOf(tc.mi.n.handler)
-			nVal := reflect.ValueOf(n.handler)
-			assert.Equal(t, wantVal, nVal)
 		})
 	}
+	assert.Equal(t, tree.routes(), radix.routes())
+}
+
+// benchRoutes 模拟一个中等规模的 API
+var benchRoutes = []struct {
+	method string
+	path   string
+}{
+	{http.MethodGet, "/"},
+	{http.MethodGet, "/user"},
+	{http.MethodGet, "/user/home"},
+	{http.MethodGet, "/user/profile"},
+	{http.MethodGet, "/user/:id"},
+	{http.MethodGet, "/user/:id/orders"},
+	{http.MethodGet, "/user/:id/orders/:oid"},
+	{http.MethodPost, "/user"},
+	{http.MethodPost, "/user/:id"},
+	{http.MethodGet, "/orders"},
+	{http.MethodGet, "/orders/:oid"},
+	{http.MethodGet, "/orders/:oid/items"},
+	{http.MethodGet, "/repos/:owner/:repo"},
+	{http.MethodGet, "/repos/:owner/:repo/branches"},
+	{http.MethodGet, "/repos/:owner/:repo/branches/:branch"},
+	{http.MethodGet, "/repos/:owner/:repo/commits"},
+	{http.MethodGet, "/repos/:owner/:repo/commits/:sha"},
+	{http.MethodGet, "/repos/:owner/:repo/issues"},
+	{http.MethodGet, "/repos/:owner/:repo/issues/:number"},
+	{http.MethodGet, "/repos/:owner/:repo/pulls"},
+	{http.MethodGet, "/repos/:owner/:repo/pulls/:number"},
+	{http.MethodGet, "/search/repositories"},
+	{http.MethodGet, "/search/users"},
+	{http.MethodGet, "/search/issues"},
+	{http.MethodGet, "/static/*"},
+	{http.MethodGet, "/api/v1/status"},
+	{http.MethodGet, "/api/v1/metrics"},
+	{http.MethodGet, "/api/v1/*/config"},
+}
+
+var benchPaths = []struct {
+	name string
+	path string
+}{
+	{name: "root", path: "/"},
+	{name: "static", path: "/user/home"},
+	{name: "param", path: "/user/123/orders/456"},
+	{name: "deep param", path: "/repos/go-tour/web/pulls/42"},
+	{name: "wildcard", path: "/api/v1/gateway/config"},
+	{name: "not found", path: "/user/123/orders/456/detail"},
+}
+
+func mockBenchHandler(ctx *Context) {}
+
+// Benchmark_router_match 对比两种路由树
+func Benchmark_router_match(b *testing.B) {
+	for _, name := range []string{"router", "radix"} {
+		r := testRouteTrees[name]()
+		for _, route := range benchRoutes {
+			r.addRoute(route.method, route.path, mockBenchHandler)
+		}
+		for _, bp := range benchPaths {
+			b.Run(name+"/"+bp.name, func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					r.match(http.MethodGet, bp.path)
+				}
+			})
+		}
+	}
 }
@@ -1,8 +1,14 @@
 package v9
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
 	"log"
 	"net/http"
+	"net/url"
+	"sync"
 )
 
 type HandleFunc func(ctx *Context)
@@ -16,7 +22,7 @@ type Server interface {
 
 	// addRoute 注册一个路由
 	// method 是 HTTP 方法
-	addRoute(method string, path string, handler HandleFunc)
+	addRoute(method string, path string, handler HandleFunc, opts ...RouteOption)
 	// 我们并不采取这种设计方案
 	// addRoute(method string, path string, handlers... HandleFunc)
 }
@@ -27,19 +33,30 @@ var _ Server = &HTTPServer{}
 type ServerOption func(server *HTTPServer)
 
 type HTTPServer struct {
-	router
+	routeTree
 	mdls      []Middleware
 	tplEngine TemplateEngine
+
+	// chain 组装好的 Middleware 链，在 Use 的时候重新组装，
+	// 所以 Use 必须在 Start 之前调用
+	chain HandleFunc
+	// ctxPool 复用 Context
+	ctxPool sync.Pool
 }
 
 func NewHTTPServer(opts ...ServerOption) *HTTPServer {
+	r := newRouter()
 	s := &HTTPServer{
-		router: newRouter(),
+		routeTree: &r,
+	}
+	s.ctxPool.New = func() any {
+		return &Context{}
 	}
 
 	for _, opt := range opts {
 		opt(s)
 	}
+	s.chain = s.buildChain()
 
 	return s
 }
@@ -50,12 +67,21 @@ func ServerWithTemplateEngine(engine TemplateEngine) ServerOption {
 	}
 }
 
+// ServerWithRadixRouter 使用压缩前缀树实现的路由树，
+// 匹配规则和默认的路由树一样，路由很多的时候匹配更快
+func ServerWithRadixRouter() ServerOption {
+	return func(server *HTTPServer) {
+		server.routeTree = newRadixRouter()
+	}
+}
+
 func (s *HTTPServer) Use(mdls ...Middleware) {
 	if s.mdls == nil {
 		s.mdls = mdls
-		return
+	} else {
+		s.mdls = append(s.mdls, mdls...)
 	}
-	s.mdls = append(s.mdls, mdls...)
+	s.chain = s.buildChain()
 }
 
 // UseV1 会执行路由匹配，只有匹配上了的 mdls 才会生效
@@ -65,13 +91,32 @@ func (s *HTTPServer) UseV1(path string, mdls ...Middleware) {
 }
 
 // ServeHTTP HTTPServer 处理请求的入口
+// Context 会被复用，所以请求处理完之后不能再使用 Context，
+// 例如在 goroutine 里面使用的时候，需要先把用到的数据复制出来
 func (s *HTTPServer) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
-	ctx := &Context{
-		Req:       request,
-		Resp:      writer,
-		tplEngine: s.tplEngine,
+	ctx, _ := s.ctxPool.Get().(*Context)
+	if ctx == nil {
+		// 没有通过 NewHTTPServer 创建
+		ctx = &Context{}
+	}
+	ctx.Req = request
+	ctx.Resp = writer
+	ctx.tplEngine = s.tplEngine
+	// 在执行 Middleware 之前就完成路由匹配，
+	// 这样 Middleware 里面也能拿到 MatchedRoute 和 PathParams
+	s.route(ctx)
+	root := s.chain
+	if root == nil {
+		root = s.buildChain()
 	}
-	// 最后一个应该是 HTTPServer 执行路由匹配，执行用户代码
+	root(ctx)
+	ctx.reset()
+	s.ctxPool.Put(ctx)
+}
+
+// buildChain 组装 Middleware 链
+func (s *HTTPServer) buildChain() HandleFunc {
+	// 最后一个应该是 HTTPServer 执行用户代码
 	root := s.serve
 	// 从后往前组装
 	for i := len(s.mdls) - 1; i >= 0; i-- {
@@ -86,8 +131,7 @@ func (s *HTTPServer) ServeHTTP(writer http.ResponseWriter, request *http.Request
 			s.flashResp(ctx)
 		}
 	}
-	root = m(root)
-	root(ctx)
+	return m(root)
 }
 
 // Start 启动服务器
@@ -95,29 +139,101 @@ func (s *HTTPServer) Start(addr string) error {
 	return http.ListenAndServe(addr, s)
 }
 
-func (s *HTTPServer) Post(path string, handler HandleFunc) {
-	s.addRoute(http.MethodPost, path, handler)
+func (s *HTTPServer) Post(path string, handler HandleFunc, opts ...RouteOption) {
+	s.addRoute(http.MethodPost, path, handler, opts...)
 }
 
-func (s *HTTPServer) Get(path string, handler HandleFunc) {
-	s.addRoute(http.MethodGet, path, handler)
+func (s *HTTPServer) Get(path string, handler HandleFunc, opts ...RouteOption) {
+	s.addRoute(http.MethodGet, path, handler, opts...)
+}
+
+func (s *HTTPServer) Head(path string, handler HandleFunc, opts ...RouteOption) {
+	s.addRoute(http.MethodHead, path, handler, opts...)
+}
+
+func (s *HTTPServer) Patch(path string, handler HandleFunc, opts ...RouteOption) {
+	s.addRoute(http.MethodPatch, path, handler, opts...)
+}
+
+func (s *HTTPServer) Delete(path string, handler HandleFunc, opts ...RouteOption) {
+	s.addRoute(http.MethodDelete, path, handler, opts...)
+}
+
+// Routes 返回所有注册的路由，以及作用在它们上面的 Middleware，可以用于启动的时候打印日志
+func (s *HTTPServer) Routes() []RouteInfo {
+	res := s.routes()
+	mdls := make([]string, 0, len(s.mdls))
+	for _, mdl := range s.mdls {
+		mdls = append(mdls, funcName(mdl))
+	}
+	for i := range res {
+		res[i].Middlewares = mdls
+	}
+	return res
+}
+
+// TemplateFuncs 实现了 TemplateFuncProvider，提供了 urlFor 模板方法，例如
+// {{ urlFor "user.detail" "id" .ID "tab" "orders" }}
+// 参数是成对的，路由里面的参数用于替换路径，其余的作为查询参数
+func (s *HTTPServer) TemplateFuncs(ctx context.Context) template.FuncMap {
+	return template.FuncMap{
+		"urlFor": s.urlForPairs,
+	}
+}
+
+func (s *HTTPServer) urlForPairs(name string, pairs ...any) (string, error) {
+	if len(pairs)%2 != 0 {
+		return "", errors.New("web: urlFor 的参数必须是成对的")
+	}
+	route, ok := s.routeOf(name)
+	if !ok {
+		return "", fmt.Errorf("web: 找不到路由 %s", name)
+	}
+	routeParams := routeParams(route)
+	params := make(map[string]string, len(pairs)/2)
+	query := url.Values{}
+	for i := 0; i < len(pairs); i += 2 {
+		key, val := fmt.Sprint(pairs[i]), fmt.Sprint(pairs[i+1])
+		if _, ok = routeParams[key]; ok {
+			params[key] = val
+			continue
+		}
+		query.Add(key, val)
+	}
+	return s.URLFor(name, params, query)
+}
+
+// route 匹配路由，并且把结果记录在 ctx 上面
+func (s *HTTPServer) route(ctx *Context) {
+	ctx.routedMethod, ctx.routedPath = ctx.Req.Method, ctx.Req.URL.Path
+	ctx.PathParams, ctx.MatchedRoute, ctx.handler = nil, "", nil
+	if mi, ok := s.match(ctx.routedMethod, ctx.routedPath); ok && mi.handler() != nil {
+		ctx.PathParams = mi.pathParams
+		ctx.MatchedRoute = mi.n.route
+		ctx.handler = mi.n.handler
+	}
 }
 
 func (s *HTTPServer) serve(ctx *Context) {
-	mi, ok := s.findRoute(ctx.Req.Method, ctx.Req.URL.Path)
-	if !ok || mi.n == nil || mi.n.handler == nil {
+	// Middleware 改写了请求的方法或者路径，例如去掉版本前缀，那么按照改写之后的请求重新匹配
+	if ctx.Req.Method != ctx.routedMethod || ctx.Req.URL.Path != ctx.routedPath {
+		s.route(ctx)
+	}
+	if ctx.handler == nil {
 		ctx.RespStatusCode = 404
 		return
 	}
-	ctx.PathParams = mi.pathParams
-	ctx.MatchedRoute = mi.n.route
-	mi.n.handler(ctx)
+	ctx.handler(ctx)
 }
 
 func (s *HTTPServer) flashResp(ctx *Context) {
 	if ctx.RespStatusCode > 0 {
 		ctx.Resp.WriteHeader(ctx.RespStatusCode)
 	}
+	// 304 之类的响应是不允许有响应体的，即便写入空数据也会返回错误
+	if len(ctx.RespData) == 0 {
+		return
+	}
 	_, err := ctx.Resp.Write(ctx.RespData)
 	if err != nil {
 		log.Fatalln("回写响应失败", err)
@@ -54,7 +54,12 @@ func TestServer(t *testing.T) {
 }
 
 func TestServerWithRenderEngine(t *testing.T) {
-	tpl, err := template.ParseGlob("testdata/tpls/*.gohtml")
+	tpl, err := template.New("").Funcs(template.FuncMap{
+		// 真实场景下由 csrf 中间件提供
+		"csrfField": func() template.HTML { return "" },
+		// 真实场景下由 session.TemplateFuncs 提供
+		"flashes": func(category string) []string { return nil },
+	}).ParseGlob("testdata/tpls/*.gohtml")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1 +1,305 @@
 package v9
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTTPServer_Routes(t *testing.T) {
+	s := NewHTTPServer()
+	s.Use(testMiddleware)
+	s.Get("/user/:id", testUserHandler, WithRouteName("user.detail"))
+	s.Post("/user/:id", testUserHandler)
+	s.Get("/", func(ctx *Context) {})
+
+	routes := s.Routes()
+	require.Len(t, routes, 3)
+	assert.Equal(t, RouteInfo{
+		Method:      http.MethodGet,
+		Path:        "/",
+		Handler:     "github.com/go-tour/web/v9.TestHTTPServer_Routes.func1",
+		Middlewares: []string{"github.com/go-tour/web/v9.testMiddleware"},
+	}, routes[0])
+	assert.Equal(t, RouteInfo{
+		Method:      http.MethodGet,
+		Path:        "/user/:id",
+		Name:        "user.detail",
+		Handler:     "github.com/go-tour/web/v9.testUserHandler",
+		Middlewares: []string{"github.com/go-tour/web/v9.testMiddleware"},
+	}, routes[1])
+	assert.Equal(t, http.MethodPost, routes[2].Method)
+	assert.Equal(t, "", routes[2].Name)
+}
+
+func TestHTTPServer_TemplateFuncs(t *testing.T) {
+	s := NewHTTPServer()
+	s.Get("/user/:id", testUserHandler, WithRouteName("user.detail"))
+	testCases := []struct {
+		name    string
+		tpl     string
+		wantRes string
+		wantErr bool
+	}{
+		{
+			name:    "params and query",
+			tpl:     `<a href="{{ urlFor "user.detail" "id" 123 "tab" "orders" }}">`,
+			wantRes: `<a href="/user/123?tab=orders">`,
+		},
+		{
+			name:    "missing param",
+			tpl:     `{{ urlFor "user.detail" "tab" "orders" }}`,
+			wantErr: true,
+		},
+		{
+			name:    "odd pairs",
+			tpl:     `{{ urlFor "user.detail" "id" }}`,
+			wantErr: true,
+		},
+		{
+			name:    "unknown route",
+			tpl:     `{{ urlFor "unknown" }}`,
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tpl, err := template.New(tc.name).Funcs(DefaultTemplateFuncs()).Parse(tc.tpl)
+			require.NoError(t, err)
+			engine := &GoTemplateEngine{T: tpl}
+			engine.RegisterFuncProvider(s.TemplateFuncs)
+			res, err := engine.Render(context.Background(), tc.name, nil)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantRes, string(res))
+		})
+	}
+
+	// 没有注册的时候，模板能解析，但是渲染会出错
+	tpl, err := template.New("page").Funcs(DefaultTemplateFuncs()).Parse(`{{ urlFor "user.detail" "id" 1 }}`)
+	require.NoError(t, err)
+	_, err = (&GoTemplateEngine{T: tpl}).Render(context.Background(), "page", nil)
+	assert.Error(t, err)
+}
+
+func testMiddleware(next HandleFunc) HandleFunc {
+	return next
+}
+
+func testUserHandler(ctx *Context) {}
+
+func TestHTTPServer_URLFor(t *testing.T) {
+	s := NewHTTPServer()
+	s.Get("/user/:id", func(ctx *Context) {
+		// 在 handler 里面生成 URL
+		u, err := s.URLFor("user.orders", map[string]string{"id": ctx.PathParams["id"]}, nil)
+		require.NoError(t, err)
+		ctx.RespData = []byte(u)
+	}, WithRouteName("user.detail"))
+	s.Get("/user/:id/orders", func(ctx *Context) {}, WithRouteName("user.orders"))
+
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/user/123", nil))
+	assert.Equal(t, "/user/123/orders", recorder.Body.String())
+}
+
+// TestHTTPServer_ServeHTTP_routeOrder 路由在 Middleware 之前匹配，
+// Middleware 改写了请求之后按照新的请求重新匹配
+func TestHTTPServer_ServeHTTP_routeOrder(t *testing.T) {
+	s := NewHTTPServer()
+	var seen []string
+	s.Use(func(next HandleFunc) HandleFunc {
+		return func(ctx *Context) {
+			seen = append(seen, ctx.MatchedRoute)
+			// 去掉版本前缀
+			if path := ctx.Req.URL.Path; strings.HasPrefix(path, "/v1/") {
+				ctx.Req.URL.Path = path[len("/v1"):]
+			}
+			// 通过 _method 覆盖请求的方法
+			if method := ctx.Req.URL.Query().Get("_method"); method != "" {
+				ctx.Req.Method = method
+			}
+			next(ctx)
+		}
+	})
+	handler := func(ctx *Context) {
+		ctx.RespData = []byte(ctx.Req.Method + " " + ctx.MatchedRoute + " " + ctx.PathParams["id"])
+	}
+	s.Get("/user/:id", handler)
+	s.Delete("/user/:id", handler)
+	s.Get("/v1/legacy", handler)
+
+	testCases := []struct {
+		name   string
+		method string
+		path   string
+
+		wantSeen string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "no rewrite",
+			method:   http.MethodGet,
+			path:     "/user/123",
+			wantSeen: "/user/:id",
+			wantCode: http.StatusOK,
+			wantBody: "GET /user/:id 123",
+		},
+		{
+			// Middleware 看到的是改写之前的匹配结果
+			name:     "rewrite path",
+			method:   http.MethodGet,
+			path:     "/v1/user/123",
+			wantSeen: "",
+			wantCode: http.StatusOK,
+			wantBody: "GET /user/:id 123",
+		},
+		{
+			name:     "rewrite method",
+			method:   http.MethodPost,
+			path:     "/user/123?_method=DELETE",
+			wantSeen: "",
+			wantCode: http.StatusOK,
+			wantBody: "DELETE /user/:id 123",
+		},
+		{
+			// 改写之后没有对应的路由
+			name:     "rewrite to not found",
+			method:   http.MethodGet,
+			path:     "/v1/legacy",
+			wantSeen: "/v1/legacy",
+			wantCode: http.StatusNotFound,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			seen = seen[:0]
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, httptest.NewRequest(tc.method, tc.path, nil))
+			assert.Equal(t, []string{tc.wantSeen}, seen)
+			assert.Equal(t, tc.wantCode, recorder.Code)
+			assert.Equal(t, tc.wantBody, recorder.Body.String())
+		})
+	}
+}
+
+func TestServerWithRadixRouter(t *testing.T) {
+	s := NewHTTPServer(ServerWithRadixRouter())
+	s.Use(testMiddleware)
+	handler := func(ctx *Context) {
+		ctx.RespStatusCode = http.StatusOK
+		ctx.RespData = []byte(ctx.MatchedRoute + " " + ctx.PathParams["id"])
+	}
+	s.Get("/user/:id", handler, WithRouteName("user.detail"))
+	s.Get("/user/home", handler)
+	s.Get("/order/*", handler)
+
+	testCases := []struct {
+		name string
+		path string
+
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "static",
+			path:     "/user/home",
+			wantCode: http.StatusOK,
+			wantBody: "/user/home ",
+		},
+		{
+			name:     "param",
+			path:     "/user/123",
+			wantCode: http.StatusOK,
+			wantBody: "/user/:id 123",
+		},
+		{
+			name:     "star",
+			path:     "/order/123",
+			wantCode: http.StatusOK,
+			wantBody: "/order/* ",
+		},
+		{
+			name:     "not found",
+			path:     "/order/123/detail",
+			wantCode: http.StatusNotFound,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, tc.path, nil))
+			assert.Equal(t, tc.wantCode, recorder.Code)
+			assert.Equal(t, tc.wantBody, recorder.Body.String())
+		})
+	}
+
+	url, err := s.URLFor("user.detail", map[string]string{"id": "123"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "/user/123", url)
+	routes := s.Routes()
+	require.Len(t, routes, 3)
+	assert.Equal(t, "/order/*", routes[0].Path)
+	assert.Equal(t, []string{"github.com/go-tour/web/v9.testMiddleware"}, routes[0].Middlewares)
+}
+
+// discardResponseWriter 丢弃所有的响应，避免 httptest.ResponseRecorder 的分配影响结果
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (w *discardResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *discardResponseWriter) Write(data []byte) (int, error) {
+	return len(data), nil
+}
+
+func (w *discardResponseWriter) WriteHeader(statusCode int) {}
+
+func BenchmarkHTTPServer_ServeHTTP(b *testing.B) {
+	testCases := []struct {
+		name string
+		opts []ServerOption
+	}{
+		{name: "router"},
+		{name: "radix", opts: []ServerOption{ServerWithRadixRouter()}},
+	}
+	for _, tc := range testCases {
+		s := NewHTTPServer(tc.opts...)
+		// 模拟网关常见的几个 Middleware
+		for i := 0; i < 3; i++ {
+			s.Use(func(next HandleFunc) HandleFunc {
+				return func(ctx *Context) {
+					next(ctx)
+				}
+			})
+		}
+		for _, route := range benchRoutes {
+			s.addRoute(route.method, route.path, func(ctx *Context) {
+				ctx.RespStatusCode = http.StatusOK
+			})
+		}
+		for _, bp := range benchPaths {
+			b.Run(tc.name+"/"+bp.name, func(b *testing.B) {
+				req := httptest.NewRequest(http.MethodGet, bp.path, nil)
+				w := &discardResponseWriter{header: http.Header{}}
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					s.ServeHTTP(w, req)
+				}
+			})
+		}
+	}
+}
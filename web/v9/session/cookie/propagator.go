@@ -22,6 +22,9 @@ func NewPropagator(cookieName string, opts ...PropagatorOption) *Propagator {
 		cookieName: cookieName,
 		cookieOpt:  func(c *http.Cookie) {},
 	}
+	for _, opt := range opts {
+		opt(res)
+	}
 	return res
 }
 
@@ -49,6 +52,8 @@ func (c *Propagator) Remove(writer http.ResponseWriter) error {
 		MaxAge: -1,
 	}
 	c.cookieOpt(cookie)
+	// 防止 cookieOpt 修改了 MaxAge
+	cookie.MaxAge = -1
 	http.SetCookie(writer, cookie)
 	return nil
 }
@@ -0,0 +1,86 @@
+package cookiestore
+
+import (
+	"net/http"
+	"strings"
+)
+
+type PropagatorOption func(p *Propagator)
+
+// WithCookieOption 用于设置 cookie 的 Domain，MaxAge 之类的字段
+func WithCookieOption(opt func(c *http.Cookie)) PropagatorOption {
+	return func(p *Propagator) {
+		p.cookieOpt = opt
+	}
+}
+
+// Propagator 和 Store 配合使用
+// 同一个请求里面 session 的数据可能会被写入多次，所以后面写入的会覆盖前面写入的
+type Propagator struct {
+	cookieName string
+	cookieOpt  func(c *http.Cookie)
+}
+
+// NewPropagator 创建一个 Propagator
+// 默认情况下 cookie 是 HttpOnly 的，并且 SameSite 是 Lax
+func NewPropagator(cookieName string, opts ...PropagatorOption) *Propagator {
+	res := &Propagator{
+		cookieName: cookieName,
+		cookieOpt:  func(c *http.Cookie) {},
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res
+}
+
+func (p *Propagator) Inject(id string, writer http.ResponseWriter) error {
+	cookie := &http.Cookie{
+		Name:     p.cookieName,
+		Value:    id,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	p.cookieOpt(cookie)
+	p.removeSetCookie(writer.Header())
+	http.SetCookie(writer, cookie)
+	return nil
+}
+
+func (p *Propagator) Extract(req *http.Request) (string, error) {
+	cookie, err := req.Cookie(p.cookieName)
+	if err != nil {
+		return "", err
+	}
+	return cookie.Value, nil
+}
+
+func (p *Propagator) Remove(writer http.ResponseWriter) error {
+	cookie := &http.Cookie{
+		Name:   p.cookieName,
+		Path:   "/",
+		MaxAge: -1,
+	}
+	p.cookieOpt(cookie)
+	cookie.MaxAge = -1
+	p.removeSetCookie(writer.Header())
+	http.SetCookie(writer, cookie)
+	return nil
+}
+
+// removeSetCookie 删除前面已经设置的同名 cookie
+func (p *Propagator) removeSetCookie(header http.Header) {
+	vals := header.Values("Set-Cookie")
+	if len(vals) == 0 {
+		return
+	}
+	prefix := p.cookieName + "="
+	res := make([]string, 0, len(vals))
+	for _, val := range vals {
+		if !strings.HasPrefix(val, prefix) {
+			res = append(res, val)
+		}
+	}
+	header["Set-Cookie"] = res
+}
@@ -0,0 +1,113 @@
+package cookiestore
+
+import (
+	"context"
+	"github.com/go-tour/web/v9/session"
+	"sync"
+	"time"
+)
+
+var _ session.Flusher = &cookieSession{}
+
+type cookieSession struct {
+	store     *Store
+	mutex     sync.RWMutex
+	id        string
+	data      map[string]any
+	expireAt  time.Time
+	createdAt time.Time
+	// token 加密之后的数据，也就是放进 cookie 里面的值
+	token string
+	dirty bool
+}
+
+func (s *cookieSession) Get(ctx context.Context, key string) (any, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	val, ok := s.data[key]
+	if !ok {
+		return nil, session.ErrKeyNotFound
+	}
+	return val, nil
+}
+
+func (s *cookieSession) Set(ctx context.Context, key string, val any) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.data[key] = val
+	s.dirty = true
+	return nil
+}
+
+func (s *cookieSession) Delete(ctx context.Context, key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.data[key]; !ok {
+		return nil
+	}
+	delete(s.data, key)
+	s.dirty = true
+	return nil
+}
+
+func (s *cookieSession) Keys(ctx context.Context) ([]string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	res := make([]string, 0, len(s.data))
+	for key := range s.data {
+		res = append(res, key)
+	}
+	return res, nil
+}
+
+func (s *cookieSession) Clear(ctx context.Context) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.data = make(map[string]any)
+	s.dirty = true
+	return nil
+}
+
+// ID 返回的是加密之后的数据，Flush 之后可能会发生变化
+func (s *cookieSession) ID() string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.token
+}
+
+// Flush 数据被修改过，或者有效期已经过半的时候，重新加密数据并且顺延过期时间
+// 数据太大的时候返回 ErrCookieTooLarge，这个时候 ID 不会发生变化
+func (s *cookieSession) Flush(ctx context.Context) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.dirty && time.Until(s.expireAt) > s.store.expiration/2 {
+		return nil
+	}
+	expireAt := time.Now().Add(s.store.expiration)
+	token, err := s.store.encode(payload{
+		ID:        s.id,
+		Data:      s.data,
+		ExpireAt:  expireAt.UnixMilli(),
+		CreatedAt: s.createdAt.UnixMilli(),
+	})
+	if err != nil {
+		return err
+	}
+	s.token = token
+	s.expireAt = expireAt
+	s.dirty = false
+	if s.store.registry == nil {
+		return nil
+	}
+	// 索引里面记录的是最新的 cookie
+	return s.store.registry.Update(ctx, s.id, token, expireAt)
+}
+
+func (s *cookieSession) payload() payload {
+	return payload{
+		ID:        s.id,
+		Data:      s.data,
+		ExpireAt:  s.expireAt.UnixMilli(),
+		CreatedAt: s.createdAt.UnixMilli(),
+	}
+}
@@ -0,0 +1,230 @@
+package cookiestore
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-tour/web/v9/session"
+	"time"
+)
+
+// defaultMaxLength 浏览器一般限制单个 cookie 不能超过 4096 字节，
+// 这里还要给 cookie 的名字和属性留一点空间
+const defaultMaxLength = 3800
+
+var (
+	// ErrCookieTooLarge 加密之后的数据超过了长度限制，这个时候应该减少放进 session 的数据
+	ErrCookieTooLarge = errors.New("cookiestore: session 数据太大，cookie 放不下")
+	// ErrNoKeys 没有提供密钥
+	ErrNoKeys = errors.New("cookiestore: 至少需要一个密钥")
+)
+
+// payload 是加密之前的数据，过期时间也在里面，这样就不需要服务端保存任何状态
+type payload struct {
+	ID       string         `json:"id"`
+	Data     map[string]any `json:"data"`
+	ExpireAt int64          `json:"exp"`
+	// CreatedAt 创建时间，毫秒数，换 ID 的时候不会变化
+	CreatedAt int64 `json:"iat"`
+}
+
+type StoreOption func(s *Store)
+
+// WithMaxLength 设置加密之后的数据的最大长度，默认是 3800
+func WithMaxLength(maxLength int) StoreOption {
+	return func(s *Store) {
+		s.maxLength = maxLength
+	}
+}
+
+// Store 将整个 session 用 AES-GCM 加密之后放在 cookie 里面
+// 对于这个 Store 来说，session 的 ID 就是加密之后的数据，
+// 所以修改了数据之后，需要调用 session.Manager 的 Flush 方法重新写入 cookie，
+// 使用 session.Manager 的 Middleware 的时候会自动调用。
+//
+// 默认服务端不保存任何状态，所以 Remove 和 Rotate 之后，老的 cookie 在过期之前依旧是有效的，
+// 也不支持 session.UserIndex。需要这些功能的时候，通过 WithRegistry 设置一个所有实例共享的 Registry
+type Store struct {
+	// 第一个用于加密，所有的都可以用于解密
+	aeads      []cipher.AEAD
+	expiration time.Duration
+	maxLength  int
+	registry   Registry
+}
+
+var _ session.Store = &Store{}
+
+// NewStore 创建一个 Store 的实例
+// keys 的长度必须是 16，24 或者 32，分别对应 AES-128，AES-192 和 AES-256
+// 轮换密钥的时候，把新的密钥放在最前面，老的密钥保留一段时间，
+// 这样用老的密钥加密的 cookie 依旧可以解密，并且在下一次写入的时候换成新的密钥
+func NewStore(expiration time.Duration, keys [][]byte, opts ...StoreOption) (*Store, error) {
+	if len(keys) == 0 {
+		return nil, ErrNoKeys
+	}
+	res := &Store{
+		aeads:      make([]cipher.AEAD, 0, len(keys)),
+		expiration: expiration,
+		maxLength:  defaultMaxLength,
+	}
+	for _, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("cookiestore: 非法的密钥 %w", err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		res.aeads = append(res.aeads, aead)
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res, nil
+}
+
+func (s *Store) Generate(ctx context.Context, id string) (session.Session, error) {
+	return s.newSession(id, make(map[string]any), time.Now())
+}
+
+// Refresh 只检查 session 是否依旧有效
+// 过期时间是在 Flush 的时候顺延的
+func (s *Store) Refresh(ctx context.Context, id string) error {
+	_, err := s.decode(ctx, id)
+	return err
+}
+
+// Remove 删除 cookie 是 Propagator 的事情，
+// 设置了 Registry 的时候会记录下被删除的 session，在它所有的 cookie 过期之前都会被拒绝
+func (s *Store) Remove(ctx context.Context, id string) error {
+	if s.registry == nil {
+		return nil
+	}
+	p, err := s.decode(ctx, id)
+	if err != nil {
+		// 已经失效了
+		return nil
+	}
+	now := time.Now()
+	return s.registry.Revoke(ctx, p.ID, now, now.Add(s.expiration))
+}
+
+func (s *Store) Get(ctx context.Context, id string) (session.Session, error) {
+	p, err := s.decode(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return &cookieSession{
+		store:     s,
+		id:        p.ID,
+		data:      p.Data,
+		expireAt:  time.UnixMilli(p.ExpireAt),
+		createdAt: time.UnixMilli(p.CreatedAt),
+		token:     id,
+	}, nil
+}
+
+// Rotate 生成一个新的 cookie，设置了 Registry 的时候老的 cookie 在 grace 之后被拒绝，
+// 否则 grace 没有意义，老的 cookie 在过期之前依旧有效
+func (s *Store) Rotate(ctx context.Context, oldID string, newID string, grace time.Duration) (session.Session, error) {
+	p, err := s.decode(ctx, oldID)
+	if err != nil {
+		return nil, err
+	}
+	sess, err := s.newSession(newID, p.Data, time.UnixMilli(p.CreatedAt))
+	if err != nil {
+		return nil, err
+	}
+	if s.registry == nil {
+		return sess, nil
+	}
+	if err = s.registry.Rename(ctx, p.ID, newID, sess.token, sess.expireAt); err != nil {
+		return nil, err
+	}
+	at := time.Now().Add(grace)
+	if err = s.registry.Revoke(ctx, p.ID, at, at.Add(s.expiration)); err != nil {
+		return nil, err
+	}
+	return sess, nil
+}
+
+func (s *Store) newSession(id string, data map[string]any, createdAt time.Time) (*cookieSession, error) {
+	sess := &cookieSession{
+		store:     s,
+		id:        id,
+		data:      data,
+		expireAt:  time.Now().Add(s.expiration),
+		createdAt: createdAt,
+	}
+	token, err := s.encode(sess.payload())
+	if err != nil {
+		return nil, err
+	}
+	sess.token = token
+	return sess, nil
+}
+
+func (s *Store) encode(p payload) (string, error) {
+	plain, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	aead := s.aeads[0]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, plain, nil))
+	if len(token) > s.maxLength {
+		return "", ErrCookieTooLarge
+	}
+	return token, nil
+}
+
+// decode 解密失败，已经过期或者被删除了都返回 session.ErrSessionNotFound
+func (s *Store) decode(ctx context.Context, token string) (payload, error) {
+	var p payload
+	if len(token) > s.maxLength {
+		return p, session.ErrSessionNotFound
+	}
+	bs, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return p, session.ErrSessionNotFound
+	}
+	for _, aead := range s.aeads {
+		if len(bs) < aead.NonceSize() {
+			continue
+		}
+		nonce, cipherText := bs[:aead.NonceSize()], bs[aead.NonceSize():]
+		plain, err := aead.Open(nil, nonce, cipherText, nil)
+		if err != nil {
+			continue
+		}
+		if err = json.Unmarshal(plain, &p); err != nil {
+			return p, session.ErrSessionNotFound
+		}
+		if time.Now().UnixMilli() >= p.ExpireAt {
+			return p, session.ErrSessionNotFound
+		}
+		if s.registry != nil {
+			revoked, err := s.registry.IsRevoked(ctx, p.ID)
+			if err != nil {
+				return p, err
+			}
+			if revoked {
+				return p, session.ErrSessionNotFound
+			}
+		}
+		if p.Data == nil {
+			p.Data = make(map[string]any)
+		}
+		return p, nil
+	}
+	return p, session.ErrSessionNotFound
+}
@@ -0,0 +1,281 @@
+package cookiestore
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	web "github.com/go-tour/web/v9"
+	"github.com/go-tour/web/v9/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewStore(t *testing.T) {
+	testCases := []struct {
+		name    string
+		keys    [][]byte
+		wantErr bool
+	}{
+		{
+			name:    "no keys",
+			wantErr: true,
+		},
+		{
+			name:    "invalid key",
+			keys:    [][]byte{[]byte("short")},
+			wantErr: true,
+		},
+		{
+			name: "aes-128",
+			keys: [][]byte{newKey(t, 16)},
+		},
+		{
+			name: "aes-256",
+			keys: [][]byte{newKey(t, 32), newKey(t, 16)},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewStore(time.Minute, tc.keys)
+			assert.Equal(t, tc.wantErr, err != nil)
+		})
+	}
+}
+
+func TestStore_Get(t *testing.T) {
+	ctx := context.Background()
+	oldKey, newKey := newKey(t, 32), newKey(t, 32)
+	oldStore, err := NewStore(time.Minute, [][]byte{oldKey})
+	require.NoError(t, err)
+	sess, err := oldStore.Generate(ctx, "id")
+	require.NoError(t, err)
+	require.NoError(t, sess.Set(ctx, "user_id", 123))
+	require.NoError(t, sess.(session.Flusher).Flush(ctx))
+	token := sess.ID()
+
+	expired, err := NewStore(time.Millisecond, [][]byte{oldKey})
+	require.NoError(t, err)
+	expiredSess, err := expired.Generate(ctx, "id")
+	require.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+
+	testCases := []struct {
+		name  string
+		keys  [][]byte
+		token string
+
+		wantErr error
+	}{
+		{
+			name:  "same key",
+			keys:  [][]byte{oldKey},
+			token: token,
+		},
+		{
+			name:  "rotated key",
+			keys:  [][]byte{newKey, oldKey},
+			token: token,
+		},
+		{
+			name:    "old key removed",
+			keys:    [][]byte{newKey},
+			token:   token,
+			wantErr: session.ErrSessionNotFound,
+		},
+		{
+			name:    "tampered",
+			keys:    [][]byte{oldKey},
+			token:   tamper(token),
+			wantErr: session.ErrSessionNotFound,
+		},
+		{
+			name:    "not base64",
+			keys:    [][]byte{oldKey},
+			token:   "!!!",
+			wantErr: session.ErrSessionNotFound,
+		},
+		{
+			name:    "expired",
+			keys:    [][]byte{oldKey},
+			token:   expiredSess.ID(),
+			wantErr: session.ErrSessionNotFound,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := NewStore(time.Minute, tc.keys)
+			require.NoError(t, err)
+			sess, err := s.Get(ctx, tc.token)
+			assert.Equal(t, tc.wantErr, err)
+			if err != nil {
+				return
+			}
+			uid, err := session.GetAs[int](ctx, sess, "user_id")
+			require.NoError(t, err)
+			assert.Equal(t, 123, uid)
+		})
+	}
+}
+
+func TestCookieSession_Flush(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewStore(time.Minute, [][]byte{newKey(t, 16)}, WithMaxLength(200))
+	require.NoError(t, err)
+	sess, err := s.Generate(ctx, "id")
+	require.NoError(t, err)
+	token := sess.ID()
+
+	// 没有修改，并且有效期还很长，不需要重新加密
+	require.NoError(t, sess.(session.Flusher).Flush(ctx))
+	assert.Equal(t, token, sess.ID())
+
+	require.NoError(t, sess.Set(ctx, "key", "val"))
+	require.NoError(t, sess.(session.Flusher).Flush(ctx))
+	assert.NotEqual(t, token, sess.ID())
+	token = sess.ID()
+
+	require.NoError(t, sess.Set(ctx, "big", strings.Repeat("a", 200)))
+	assert.Equal(t, ErrCookieTooLarge, sess.(session.Flusher).Flush(ctx))
+	assert.Equal(t, token, sess.ID())
+}
+
+func TestStore_Manager(t *testing.T) {
+	s, err := NewStore(time.Minute, [][]byte{newKey(t, 32)})
+	require.NoError(t, err)
+	m := &session.Manager{
+		Store:      s,
+		Propagator: NewPropagator("sess"),
+		SessCtxKey: "_sess",
+	}
+	server := web.NewHTTPServer()
+	server.Use(func(next web.HandleFunc) web.HandleFunc {
+		return func(ctx *web.Context) {
+			next(ctx)
+			if err := m.Flush(ctx); err != nil {
+				ctx.RespStatusCode = http.StatusInternalServerError
+			}
+		}
+	})
+	server.Post("/login", func(ctx *web.Context) {
+		sess, err := m.InitSession(ctx, "id")
+		require.NoError(t, err)
+		require.NoError(t, sess.Set(ctx.Req.Context(), "user_id", "123"))
+	})
+	server.Get("/profile", func(ctx *web.Context) {
+		sess, err := m.GetSession(ctx)
+		if err != nil {
+			ctx.RespStatusCode = http.StatusUnauthorized
+			return
+		}
+		uid, err := session.GetAs[string](ctx.Req.Context(), sess, "user_id")
+		require.NoError(t, err)
+		ctx.RespData = []byte(uid)
+	})
+	server.Post("/logout", func(ctx *web.Context) {
+		require.NoError(t, m.RemoveSession(ctx))
+	})
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/login", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	cookies := recorder.Result().Cookies()
+	// 同名的 cookie 只会有一个
+	require.Len(t, cookies, 1)
+	assert.True(t, cookies[0].HttpOnly)
+
+	req := httptest.NewRequest(http.MethodGet, "/profile", nil)
+	req.AddCookie(cookies[0])
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	assert.Equal(t, "123", recorder.Body.String())
+	// 没有修改数据，不需要重新写 cookie
+	assert.Len(t, recorder.Result().Cookies(), 0)
+
+	req = httptest.NewRequest(http.MethodPost, "/logout", nil)
+	req.AddCookie(cookies[0])
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	cookies = recorder.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, -1, cookies[0].MaxAge)
+}
+
+func TestStore_Registry(t *testing.T) {
+	ctx := context.Background()
+	key := newKey(t, 32)
+
+	// 没有 Registry 的时候服务端没有状态，Remove 之后 cookie 依旧有效
+	s, err := NewStore(time.Minute, [][]byte{key})
+	require.NoError(t, err)
+	sess, err := s.Generate(ctx, "sess-1")
+	require.NoError(t, err)
+	assert.Equal(t, ErrNoRegistry, s.Bind(ctx, session.SessionInfo{ID: sess.ID(), UserID: "u1"}))
+	_, err = s.Sessions(ctx, "u1")
+	assert.Equal(t, ErrNoRegistry, err)
+	require.NoError(t, s.Remove(ctx, sess.ID()))
+	_, err = s.Get(ctx, sess.ID())
+	assert.NoError(t, err)
+
+	// 两个实例共享同一个 Registry
+	registry := NewMemoryRegistry()
+	s1, err := NewStore(time.Minute, [][]byte{key}, WithRegistry(registry))
+	require.NoError(t, err)
+	s2, err := NewStore(time.Minute, [][]byte{key}, WithRegistry(registry))
+	require.NoError(t, err)
+	sess, err = s1.Generate(ctx, "sess-2")
+	require.NoError(t, err)
+	require.NoError(t, s1.Bind(ctx, session.SessionInfo{ID: sess.ID(), UserID: "u1"}))
+	infos, err := s2.Sessions(ctx, "u1")
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	assert.Equal(t, sess.ID(), infos[0].ID)
+
+	require.NoError(t, s1.Remove(ctx, sess.ID()))
+	_, err = s2.Get(ctx, sess.ID())
+	assert.Equal(t, session.ErrSessionNotFound, err)
+	infos, err = s2.Sessions(ctx, "u1")
+	require.NoError(t, err)
+	assert.Empty(t, infos)
+
+	// Registry 出错的时候不能当作 session 有效
+	s3, err := NewStore(time.Minute, [][]byte{key}, WithRegistry(&failingRegistry{MemoryRegistry: registry}))
+	require.NoError(t, err)
+	sess, err = s3.Generate(ctx, "sess-3")
+	require.NoError(t, err)
+	_, err = s3.Get(ctx, sess.ID())
+	assert.Equal(t, errMockRegistry, err)
+}
+
+var errMockRegistry = errors.New("mock error")
+
+type failingRegistry struct {
+	*MemoryRegistry
+}
+
+func (r *failingRegistry) IsRevoked(ctx context.Context, id string) (bool, error) {
+	return false, errMockRegistry
+}
+
+// tamper 修改中间的一个字符
+func tamper(token string) string {
+	bs := []byte(token)
+	i := len(bs) / 2
+	if bs[i] == 'A' {
+		bs[i] = 'B'
+	} else {
+		bs[i] = 'A'
+	}
+	return string(bs)
+}
+
+func newKey(t *testing.T, size int) []byte {
+	key := make([]byte, size)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	return key
+}
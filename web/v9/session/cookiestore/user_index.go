@@ -0,0 +1,244 @@
+package cookiestore
+
+import (
+	"context"
+	"errors"
+	"github.com/go-tour/web/v9/session"
+	"sync"
+	"time"
+)
+
+// gcInterval MemoryRegistry 清理过期数据的间隔
+const gcInterval = time.Minute
+
+// ErrNoRegistry 没有通过 WithRegistry 设置 Registry，
+// 这个时候服务端没有任何状态，不支持按照用户列出或者踢掉 session
+var ErrNoRegistry = errors.New("cookiestore: 没有设置 Registry，不支持按用户管理 session")
+
+// Registry 保存 cookie session 在服务端仅有的状态：用户到 session 的索引，以及被删除的 session。
+// 这里的 id 都是 payload 里面的 ID，它在 Flush 的时候不会变化。
+// 部署多个实例的时候，所有实例必须共享同一个 Registry，例如基于 redis 实现，
+// 否则 Remove 和 Rotate 只能让一部分实例拒绝老的 cookie
+type Registry interface {
+	// Bind 将 session 绑定到用户上，已经被删除的 session 返回 session.ErrSessionNotFound
+	Bind(ctx context.Context, id string, info session.SessionInfo, expireAt time.Time) error
+	// Touch 更新最后访问时间，没有绑定用户的 session 直接忽略
+	Touch(ctx context.Context, id string, lastSeen time.Time) error
+	// Update 记录 Flush 之后最新的 cookie 和过期时间，没有绑定用户的 session 直接忽略
+	Update(ctx context.Context, id string, token string, expireAt time.Time) error
+	// Rename 换 ID 之后，索引指向新的 session
+	Rename(ctx context.Context, oldID string, newID string, token string, expireAt time.Time) error
+	// Revoke 从 at 开始拒绝这个 session，until 之后它所有的 cookie 都已经过期，不需要再记录
+	Revoke(ctx context.Context, id string, at time.Time, until time.Time) error
+	// IsRevoked 这个 session 现在是否应该被拒绝
+	IsRevoked(ctx context.Context, id string) (bool, error)
+	// Sessions 返回用户所有没有过期的 session，SessionInfo.ID 是最新的 cookie
+	Sessions(ctx context.Context, uid string) ([]session.SessionInfo, error)
+}
+
+// WithRegistry 设置 Registry，设置之后才支持 session.UserIndex，
+// 并且 Remove 和 Rotate 之后老的 cookie 会被拒绝
+func WithRegistry(r Registry) StoreOption {
+	return func(s *Store) {
+		s.registry = r
+	}
+}
+
+func (s *Store) Bind(ctx context.Context, info session.SessionInfo) error {
+	if s.registry == nil {
+		return ErrNoRegistry
+	}
+	p, err := s.decode(ctx, info.ID)
+	if err != nil {
+		return err
+	}
+	info.CreatedAt = time.UnixMilli(p.CreatedAt)
+	return s.registry.Bind(ctx, p.ID, info, time.UnixMilli(p.ExpireAt))
+}
+
+// Touch 失效的 cookie 直接忽略
+func (s *Store) Touch(ctx context.Context, id string, lastSeen time.Time) error {
+	if s.registry == nil {
+		return ErrNoRegistry
+	}
+	p, err := s.decode(ctx, id)
+	if err != nil {
+		return nil
+	}
+	return s.registry.Touch(ctx, p.ID, lastSeen)
+}
+
+func (s *Store) Sessions(ctx context.Context, uid string) ([]session.SessionInfo, error) {
+	if s.registry == nil {
+		return nil, ErrNoRegistry
+	}
+	return s.registry.Sessions(ctx, uid)
+}
+
+var _ Registry = &MemoryRegistry{}
+
+// MemoryRegistry 将 Registry 的数据保存在进程内存里面，重启之后就丢失了，
+// 所以只适合单实例部署或者测试
+type MemoryRegistry struct {
+	mutex sync.RWMutex
+	// uid => session => 元数据，元数据里面的 ID 是最新的 cookie
+	users map[string]map[string]*indexEntry
+	// session => uid
+	owners  map[string]string
+	revoked map[string]revocation
+	nextGC  time.Time
+}
+
+type indexEntry struct {
+	info     session.SessionInfo
+	expireAt time.Time
+}
+
+type revocation struct {
+	at    time.Time
+	until time.Time
+}
+
+func NewMemoryRegistry() *MemoryRegistry {
+	return &MemoryRegistry{
+		users:   make(map[string]map[string]*indexEntry, 16),
+		owners:  make(map[string]string, 16),
+		revoked: make(map[string]revocation, 16),
+	}
+}
+
+func (r *MemoryRegistry) Bind(ctx context.Context, id string, info session.SessionInfo, expireAt time.Time) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	now := time.Now()
+	if r.isRevoked(id, now) {
+		return session.ErrSessionNotFound
+	}
+	r.gc(now)
+	r.unbind(id)
+	entries, ok := r.users[info.UserID]
+	if !ok {
+		entries = make(map[string]*indexEntry, 4)
+		r.users[info.UserID] = entries
+	}
+	entries[id] = &indexEntry{info: info, expireAt: expireAt}
+	r.owners[id] = info.UserID
+	return nil
+}
+
+func (r *MemoryRegistry) Touch(ctx context.Context, id string, lastSeen time.Time) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if e := r.entry(id); e != nil {
+		e.info.LastSeen = lastSeen
+	}
+	return nil
+}
+
+func (r *MemoryRegistry) Update(ctx context.Context, id string, token string, expireAt time.Time) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if e := r.entry(id); e != nil {
+		e.info.ID = token
+		e.expireAt = expireAt
+	}
+	return nil
+}
+
+func (r *MemoryRegistry) Rename(ctx context.Context, oldID string, newID string, token string, expireAt time.Time) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	e := r.entry(oldID)
+	if e == nil {
+		return nil
+	}
+	uid := r.owners[oldID]
+	r.unbind(oldID)
+	e.info.ID = token
+	e.expireAt = expireAt
+	entries, ok := r.users[uid]
+	if !ok {
+		entries = make(map[string]*indexEntry, 4)
+		r.users[uid] = entries
+	}
+	entries[newID] = e
+	r.owners[newID] = uid
+	return nil
+}
+
+func (r *MemoryRegistry) Revoke(ctx context.Context, id string, at time.Time, until time.Time) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.gc(time.Now())
+	r.unbind(id)
+	r.revoked[id] = revocation{at: at, until: until}
+	return nil
+}
+
+func (r *MemoryRegistry) IsRevoked(ctx context.Context, id string) (bool, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.isRevoked(id, time.Now()), nil
+}
+
+func (r *MemoryRegistry) Sessions(ctx context.Context, uid string) ([]session.SessionInfo, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	now := time.Now()
+	res := make([]session.SessionInfo, 0, len(r.users[uid]))
+	for id, e := range r.users[uid] {
+		if !now.Before(e.expireAt) {
+			r.unbind(id)
+			continue
+		}
+		res = append(res, e.info)
+	}
+	return res, nil
+}
+
+// isRevoked 调用者需要持有锁
+func (r *MemoryRegistry) isRevoked(id string, now time.Time) bool {
+	rv, ok := r.revoked[id]
+	return ok && !now.Before(rv.at)
+}
+
+// entry 调用者需要持有锁
+func (r *MemoryRegistry) entry(id string) *indexEntry {
+	uid, ok := r.owners[id]
+	if !ok {
+		return nil
+	}
+	return r.users[uid][id]
+}
+
+// unbind 调用者需要持有锁
+func (r *MemoryRegistry) unbind(id string) {
+	uid, ok := r.owners[id]
+	if !ok {
+		return
+	}
+	delete(r.owners, id)
+	entries := r.users[uid]
+	delete(entries, id)
+	if len(entries) == 0 {
+		delete(r.users, uid)
+	}
+}
+
+// gc 每隔 gcInterval 清理一次过期的数据，调用者需要持有锁
+func (r *MemoryRegistry) gc(now time.Time) {
+	if now.Before(r.nextGC) {
+		return
+	}
+	r.nextGC = now.Add(gcInterval)
+	for id, rv := range r.revoked {
+		if !now.Before(rv.until) {
+			delete(r.revoked, id)
+		}
+	}
+	for id, uid := range r.owners {
+		if !now.Before(r.users[uid][id].expireAt) {
+			r.unbind(id)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"html/template"
+)
+
+// flashKeyPrefix flash 消息在 session 里面的 key 的前缀，后面跟着分类
+const flashKeyPrefix = "_flash_"
+
+// AddFlash 添加一条 flash 消息，它只能被读取一次，一般用于 post/redirect/get，
+// 例如登录失败之后重定向回登录页面，在登录页面上展示错误信息。
+// 只能在 Manager 的 Middleware 后面使用，如果当前请求还没有 session，那么会创建一个
+func AddFlash(ctx context.Context, category string, msg string) error {
+	l, ok := ctx.Value(loaderKey{}).(*loader)
+	if !ok {
+		return ErrSessionNotFound
+	}
+	sess, err := l.loadOrInit()
+	if err != nil {
+		return err
+	}
+	msgs, err := GetAs[[]string](ctx, sess, flashKeyPrefix+category)
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		return err
+	}
+	// 复制一份，避免修改 session 里面原本的切片
+	res := make([]string, 0, len(msgs)+1)
+	res = append(res, msgs...)
+	return sess.Set(ctx, flashKeyPrefix+category, append(res, msg))
+}
+
+// Flashes 返回并且删除某个分类下的 flash 消息
+// 没有 session 或者没有消息的时候返回 nil，其余的错误，例如 Store 不可用，会原样返回
+func Flashes(ctx context.Context, category string) ([]string, error) {
+	sess, err := FromContext(ctx)
+	if IsNoSession(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	key := flashKeyPrefix + category
+	msgs, err := GetAs[[]string](ctx, sess, key)
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return msgs, sess.Delete(ctx, key)
+}
+
+// TemplateFuncs 实现了 web.TemplateFuncProvider，提供了模板方法 flashes，
+// 例如 {{ range flashes "error" }}<p>{{ . }}</p>{{ end }}
+func TemplateFuncs(ctx context.Context) template.FuncMap {
+	return template.FuncMap{
+		"flashes": func(category string) ([]string, error) {
+			return Flashes(ctx, category)
+		},
+	}
+}
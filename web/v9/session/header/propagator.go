@@ -0,0 +1,70 @@
+package header
+
+import (
+	"github.com/go-tour/web/v9/session"
+	"net/http"
+	"strings"
+)
+
+type PropagatorOption func(p *Propagator)
+
+// WithScheme 设置前缀，例如 Authorization: Session xxx 里面的 Session
+// 只影响 Extract，Inject 写回的依旧是 session id 本身
+func WithScheme(scheme string) PropagatorOption {
+	return func(p *Propagator) {
+		p.scheme = scheme
+	}
+}
+
+// WithResponseHeader 设置 Inject 和 Remove 使用的响应头，默认和请求头一样
+// 例如请求头用的是 Authorization，那么响应头可以使用 X-Session-Id
+func WithResponseHeader(name string) PropagatorOption {
+	return func(p *Propagator) {
+		p.respHeader = name
+	}
+}
+
+// Propagator 通过 HTTP header 传递 session id，一般用于 APP 和 API 客户端
+type Propagator struct {
+	header     string
+	respHeader string
+	scheme     string
+}
+
+func NewPropagator(header string, opts ...PropagatorOption) *Propagator {
+	res := &Propagator{
+		header:     header,
+		respHeader: header,
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res
+}
+
+func (p *Propagator) Inject(id string, writer http.ResponseWriter) error {
+	writer.Header().Set(p.respHeader, id)
+	return nil
+}
+
+func (p *Propagator) Extract(req *http.Request) (string, error) {
+	val := strings.TrimSpace(req.Header.Get(p.header))
+	if p.scheme != "" {
+		// scheme 是大小写不敏感的
+		if len(val) <= len(p.scheme) || !strings.EqualFold(val[:len(p.scheme)], p.scheme) ||
+			val[len(p.scheme)] != ' ' {
+			return "", session.ErrIDNotFound
+		}
+		val = strings.TrimSpace(val[len(p.scheme)+1:])
+	}
+	if val == "" {
+		return "", session.ErrIDNotFound
+	}
+	return val, nil
+}
+
+// Remove 将响应头设置为空字符串，客户端看到之后应该删除本地保存的 session id
+func (p *Propagator) Remove(writer http.ResponseWriter) error {
+	writer.Header().Set(p.respHeader, "")
+	return nil
+}
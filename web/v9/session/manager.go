@@ -2,12 +2,28 @@ package session
 
 import (
 	web "github.com/go-tour/web/v9"
+	"github.com/google/uuid"
+	"net/http"
+	"time"
 )
 
 type Manager struct {
 	Store
 	Propagator
 	SessCtxKey string
+	// GenIDFunc 生成 session id，RotateSession 和 AddFlash 的时候会用到
+	// 默认使用 uuid
+	GenIDFunc func() string
+	// RotateGrace 换 ID 之后，老的 ID 还能使用的时间
+	// 默认是 0，也就是老的 ID 立刻失效。如果页面会同时发出多个请求，
+	// 可以设置几秒钟，让那些带着老的 ID 的请求不会失败
+	RotateGrace time.Duration
+	// MaxSessionsPerUser 每个用户最多同时有多少个 session，0 代表不限制
+	// 需要在登录的时候调用 BindUser
+	MaxSessionsPerUser int
+	// ClientIP 用于记录 session 的 IP，默认使用 RemoteAddr
+	// 部署在反向代理后面的时候，可以改为从 X-Forwarded-For 之类的 header 里面读取
+	ClientIP func(req *http.Request) string
 }
 
 // GetSession 将会尝试从 ctx 中拿到 Session，
@@ -39,12 +55,39 @@ func (m *Manager) InitSession(ctx *web.Context, id string) (Session, error) {
 	if err != nil {
 		return nil, err
 	}
-	if err = m.Inject(id, ctx.Resp); err != nil {
+	if err = m.Inject(sess.ID(), ctx.Resp); err != nil {
 		return nil, err
 	}
+	if ctx.UserValues == nil {
+		ctx.UserValues = make(map[string]any, 1)
+	}
+	ctx.UserValues[m.SessCtxKey] = sess
 	return sess, nil
 }
 
+// Flush 将当前请求用到的 Session 的修改写回存储
+// 只有实现了 Flusher 的 Session 才需要，一般在请求结束的时候调用
+func (m *Manager) Flush(ctx *web.Context) error {
+	val, ok := ctx.UserValues[m.SessCtxKey]
+	if !ok {
+		return nil
+	}
+	f, ok := val.(Flusher)
+	if !ok {
+		return nil
+	}
+	sess := val.(Session)
+	id := sess.ID()
+	if err := f.Flush(ctx.Req.Context()); err != nil {
+		return err
+	}
+	// 数据保存在客户端的 Session，ID 就是数据本身，Flush 之后需要重新注入
+	if sess.ID() != id {
+		return m.Inject(sess.ID(), ctx.Resp)
+	}
+	return nil
+}
+
 // RefreshSession 刷新 Session
 func (m *Manager) RefreshSession(ctx *web.Context) (Session, error) {
 	sess, err := m.GetSession(ctx)
@@ -56,6 +99,7 @@ func (m *Manager) RefreshSession(ctx *web.Context) (Session, error) {
 	if err != nil {
 		return nil, err
 	}
+	m.touch(ctx.Req.Context(), sess.ID())
 	// 重新注入 HTTP 里面
 	if err = m.Inject(sess.ID(), ctx.Resp); err != nil {
 		return nil, err
@@ -73,5 +117,40 @@ func (m *Manager) RemoveSession(ctx *web.Context) error {
 	if err != nil {
 		return err
 	}
+	delete(ctx.UserValues, m.SessCtxKey)
 	return m.Propagator.Remove(ctx.Resp)
 }
+
+// RotateSession 给当前的 Session 换一个新的 ID，并且重新注入到 http response 里面
+// 登录成功之后应该调用这个方法，防止 session fixation 攻击
+func (m *Manager) RotateSession(ctx *web.Context) (Session, error) {
+	sess, err := m.GetSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Rotate 复制的是存储里面的数据，所以先把这次请求里面的修改写回去，
+	// 例如登录的时候先设置了用户信息再换 ID
+	if err = m.Flush(ctx); err != nil {
+		return nil, err
+	}
+	sess, err = m.GetSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	newSess, err := m.Rotate(ctx.Req.Context(), sess.ID(), m.genID(), m.RotateGrace)
+	if err != nil {
+		return nil, err
+	}
+	ctx.UserValues[m.SessCtxKey] = newSess
+	if err = m.Inject(newSess.ID(), ctx.Resp); err != nil {
+		return nil, err
+	}
+	return newSess, nil
+}
+
+func (m *Manager) genID() string {
+	if m.GenIDFunc != nil {
+		return m.GenIDFunc()
+	}
+	return uuid.New().String()
+}
@@ -2,76 +2,247 @@ package memory
 
 import (
 	"context"
-	"errors"
 	"github.com/go-tour/web/v9/session"
 	cache "github.com/patrickmn/go-cache"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// ExpirationPolicy 过期策略
+type ExpirationPolicy int
+
+const (
+	// Sliding 每次 Refresh 都会重新计算过期时间，
+	// 也就是说 expiration 是空闲超时时间
+	Sliding ExpirationPolicy = iota
+	// Absolute 过期时间从创建 session 开始计算，Refresh 不会延长过期时间
+	Absolute
+)
+
+type StoreOption func(s *Store)
+
+// WithPolicy 设置过期策略，默认是 Sliding
+func WithPolicy(policy ExpirationPolicy) StoreOption {
+	return func(s *Store) {
+		s.policy = policy
+	}
+}
+
+// WithMaxLifetime 设置 session 最长的存活时间，不管刷新多少次，超过这个时间都会过期
+// 只在 Sliding 策略下有意义，默认不限制
+func WithMaxLifetime(maxLifetime time.Duration) StoreOption {
+	return func(s *Store) {
+		s.maxLifetime = maxLifetime
+	}
+}
+
+// WithCleanupInterval 设置清理过期 session 的间隔，默认是一秒
+// 过期回调是在清理的时候触发的，所以这个间隔也决定了回调的及时性
+func WithCleanupInterval(interval time.Duration) StoreOption {
+	return func(s *Store) {
+		s.cleanupInterval = interval
+	}
+}
+
+// WithExpirationCallback 设置过期回调，只有 session 过期的时候才会触发，
+// 调用 Remove 或者 Rotate 导致的删除不会触发
+func WithExpirationCallback(fn func(id string, sess session.Session)) StoreOption {
+	return func(s *Store) {
+		s.onExpired = fn
+	}
+}
+
+var _ session.Store = &Store{}
+
 type Store struct {
 	// 利用一个内存缓存来帮助我们管理过期时间
-	c          *cache.Cache
-	expiration time.Duration
+	c               *cache.Cache
+	expiration      time.Duration
+	policy          ExpirationPolicy
+	maxLifetime     time.Duration
+	cleanupInterval time.Duration
+	onExpired       func(id string, sess session.Session)
+	index           *userIndex
+	// mutex 保证 Rotate 复制数据和切换 ID 是一个整体
+	mutex sync.Mutex
 }
 
 // NewStore 创建一个 Store 的实例
-// 实际上，这里也可以考虑使用 Option 设计模式，允许用户控制过期检查的间隔
-func NewStore(expiration time.Duration) *Store {
-	return &Store{
-		c: cache.New(expiration, time.Second),
+// expiration 在 Sliding 策略下是空闲超时时间，在 Absolute 策略下是存活时间
+func NewStore(expiration time.Duration, opts ...StoreOption) *Store {
+	res := &Store{
+		expiration:      expiration,
+		cleanupInterval: time.Second,
+		index:           newUserIndex(),
+	}
+	for _, opt := range opts {
+		opt(res)
 	}
+	res.c = cache.New(expiration, res.cleanupInterval)
+	res.c.OnEvicted(res.onEvicted)
+	return res
 }
 
 func (m *Store) Generate(ctx context.Context, id string) (session.Session, error) {
 	sess := &memorySession{
-		id:   id,
-		data: make(map[string]string),
+		id:        id,
+		data:      make(map[string]any),
+		createdAt: time.Now(),
 	}
 	m.c.Set(sess.ID(), sess, m.expiration)
 	return sess, nil
 }
 
 func (m *Store) Refresh(ctx context.Context, id string) error {
-	sess, err := m.Get(ctx, id)
-	if err != nil {
+	val, ok := m.c.Get(id)
+	if !ok {
+		return session.ErrSessionNotFound
+	}
+	if m.policy == Absolute {
 		return nil
 	}
-	m.c.Set(sess.ID(), sess, m.expiration)
+	sess := val.(*memorySession)
+	ttl := m.ttl(sess, time.Now())
+	if ttl <= 0 {
+		// 已经达到了最长存活时间，等着被清理就可以
+		return session.ErrSessionNotFound
+	}
+	m.c.Set(id, sess, ttl)
 	return nil
 }
 
 func (m *Store) Remove(ctx context.Context, id string) error {
+	if val, ok := m.c.Get(id); ok {
+		val.(*memorySession).removed.Store(true)
+	}
 	m.c.Delete(id)
+	m.index.remove(id)
 	return nil
 }
 
+// Rotate 复制数据到 newID 下面。
+// grace 小于等于 0 的时候，oldID 会立刻失效
+func (m *Store) Rotate(ctx context.Context, oldID string, newID string, grace time.Duration) (session.Session, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	val, ok := m.c.Get(oldID)
+	if !ok {
+		return nil, session.ErrSessionNotFound
+	}
+	old := val.(*memorySession)
+	old.mutex.RLock()
+	data := make(map[string]any, len(old.data))
+	for k, v := range old.data {
+		data[k] = v
+	}
+	old.mutex.RUnlock()
+	// 换 ID 并不会重置最长存活时间
+	sess := &memorySession{
+		id:        newID,
+		data:      data,
+		createdAt: old.createdAt,
+	}
+	ttl := m.ttl(sess, time.Now())
+	if ttl <= 0 {
+		return nil, session.ErrSessionNotFound
+	}
+	m.c.Set(newID, sess, ttl)
+	// 老的 session 即便后面过期了，也不应该触发回调
+	old.removed.Store(true)
+	m.index.rename(oldID, newID)
+	if grace <= 0 {
+		m.c.Delete(oldID)
+	} else {
+		m.c.Set(oldID, old, grace)
+	}
+	return sess, nil
+}
+
 func (m *Store) Get(ctx context.Context, id string) (session.Session, error) {
 	sess, ok := m.c.Get(id)
 	if !ok {
-		return nil, errors.New("session not found")
+		return nil, session.ErrSessionNotFound
 	}
 	return sess.(*memorySession), nil
 }
 
+// ttl 计算 session 还能存活多久
+func (m *Store) ttl(sess *memorySession, now time.Time) time.Duration {
+	if m.policy == Absolute {
+		return sess.createdAt.Add(m.expiration).Sub(now)
+	}
+	ttl := m.expiration
+	if m.maxLifetime > 0 {
+		if remain := sess.createdAt.Add(m.maxLifetime).Sub(now); remain < ttl {
+			ttl = remain
+		}
+	}
+	return ttl
+}
+
+func (m *Store) onEvicted(id string, val any) {
+	m.index.remove(id)
+	sess := val.(*memorySession)
+	if m.onExpired == nil || sess.removed.Load() {
+		return
+	}
+	m.onExpired(id, sess)
+}
+
+// memorySession 是并发安全的
+// 注意值并不会被复制，所以如果存放的是指针或者 map 之类的，
+// 那么修改它们需要用户自己保证并发安全
 type memorySession struct {
-	id         string
-	data       map[string]string
-	expiration time.Duration
+	id        string
+	mutex     sync.RWMutex
+	data      map[string]any
+	createdAt time.Time
+	// removed 标记 session 是被主动删除的，而不是过期的
+	removed atomic.Bool
 }
 
-func (m *memorySession) Get(ctx context.Context, key string) (string, error) {
+func (m *memorySession) Get(ctx context.Context, key string) (any, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
 	val, ok := m.data[key]
 	if !ok {
-		return "", errors.New("找不到这个 key")
+		return nil, session.ErrKeyNotFound
 	}
 	return val, nil
 }
 
-func (m *memorySession) Set(ctx context.Context, key string, val string) error {
+func (m *memorySession) Set(ctx context.Context, key string, val any) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 	m.data[key] = val
 	return nil
 }
 
+func (m *memorySession) Delete(ctx context.Context, key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.data, key)
+	return nil
+}
+
+func (m *memorySession) Keys(ctx context.Context) ([]string, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	keys := make([]string, 0, len(m.data))
+	for k := range m.data {
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+func (m *memorySession) Clear(ctx context.Context) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.data = make(map[string]any)
+	return nil
+}
+
 func (m *memorySession) ID() string {
 	return m.id
 }
@@ -0,0 +1,256 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-tour/web/v9/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStore_Rotate(t *testing.T) {
+	testCases := []struct {
+		name  string
+		grace time.Duration
+		// 过了多久之后再去拿老的 session
+		after time.Duration
+
+		wantOld bool
+	}{
+		{
+			name:    "in grace",
+			grace:   time.Minute,
+			wantOld: true,
+		},
+		{
+			name:  "after grace",
+			grace: 10 * time.Millisecond,
+			after: 20 * time.Millisecond,
+		},
+		{
+			name: "no grace",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := NewStore(time.Minute)
+			old, err := s.Generate(ctx, "old")
+			require.NoError(t, err)
+			require.NoError(t, old.Set(ctx, "user_id", "123"))
+
+			sess, err := s.Rotate(ctx, "old", "new", tc.grace)
+			require.NoError(t, err)
+			assert.Equal(t, "new", sess.ID())
+			val, err := sess.Get(ctx, "user_id")
+			require.NoError(t, err)
+			assert.Equal(t, "123", val)
+
+			// 新老 session 的数据互不影响
+			require.NoError(t, sess.Set(ctx, "user_id", "456"))
+			val, err = old.Get(ctx, "user_id")
+			require.NoError(t, err)
+			assert.Equal(t, "123", val)
+
+			time.Sleep(tc.after)
+			_, err = s.Get(ctx, "old")
+			assert.Equal(t, tc.wantOld, err == nil)
+			_, err = s.Get(ctx, "new")
+			assert.NoError(t, err)
+		})
+	}
+
+	_, err := NewStore(time.Minute).Rotate(context.Background(), "not-exist", "new", time.Minute)
+	assert.Error(t, err)
+}
+
+func TestStore_Expiration(t *testing.T) {
+	testCases := []struct {
+		name       string
+		expiration time.Duration
+		opts       []StoreOption
+		// 每隔 interval 刷新一次，一共刷新 refresh 次
+		interval time.Duration
+		refresh  int
+
+		wantFound bool
+	}{
+		{
+			name:       "sliding",
+			expiration: 50 * time.Millisecond,
+			interval:   30 * time.Millisecond,
+			refresh:    3,
+			wantFound:  true,
+		},
+		{
+			name:       "absolute",
+			expiration: 50 * time.Millisecond,
+			opts:       []StoreOption{WithPolicy(Absolute)},
+			interval:   30 * time.Millisecond,
+			refresh:    3,
+		},
+		{
+			name:       "sliding with max lifetime",
+			expiration: 50 * time.Millisecond,
+			opts:       []StoreOption{WithMaxLifetime(70 * time.Millisecond)},
+			interval:   30 * time.Millisecond,
+			refresh:    3,
+		},
+		{
+			name:       "within max lifetime",
+			expiration: 50 * time.Millisecond,
+			opts:       []StoreOption{WithMaxLifetime(time.Minute)},
+			interval:   30 * time.Millisecond,
+			refresh:    3,
+			wantFound:  true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := NewStore(tc.expiration, tc.opts...)
+			_, err := s.Generate(ctx, "id")
+			require.NoError(t, err)
+			for i := 0; i < tc.refresh; i++ {
+				time.Sleep(tc.interval)
+				_ = s.Refresh(ctx, "id")
+			}
+			_, err = s.Get(ctx, "id")
+			if tc.wantFound {
+				assert.NoError(t, err)
+			} else {
+				assert.Equal(t, session.ErrSessionNotFound, err)
+			}
+		})
+	}
+}
+
+func TestStore_Refresh(t *testing.T) {
+	err := NewStore(time.Minute).Refresh(context.Background(), "not-exist")
+	assert.Equal(t, session.ErrSessionNotFound, err)
+}
+
+func TestStore_ExpirationCallback(t *testing.T) {
+	ctx := context.Background()
+	var mutex sync.Mutex
+	expired := make([]string, 0, 4)
+	s := NewStore(30*time.Millisecond,
+		WithCleanupInterval(10*time.Millisecond),
+		WithExpirationCallback(func(id string, sess session.Session) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			expired = append(expired, id)
+		}))
+	for _, id := range []string{"expired", "removed", "rotated"} {
+		_, err := s.Generate(ctx, id)
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.Remove(ctx, "removed"))
+	_, err := s.Rotate(ctx, "rotated", "new", 10*time.Millisecond)
+	require.NoError(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+	mutex.Lock()
+	defer mutex.Unlock()
+	// 主动删除的和换了 ID 的老 session 都不会触发回调
+	assert.ElementsMatch(t, []string{"expired", "new"}, expired)
+}
+
+func TestStore_UserIndex(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore(50*time.Millisecond, WithCleanupInterval(10*time.Millisecond))
+	for _, id := range []string{"a", "b", "c"} {
+		_, err := s.Generate(ctx, id)
+		require.NoError(t, err)
+		require.NoError(t, s.Bind(ctx, session.SessionInfo{ID: id, UserID: "tom"}))
+	}
+	assert.Equal(t, session.ErrSessionNotFound, s.Bind(ctx, session.SessionInfo{ID: "not-exist", UserID: "tom"}))
+
+	// 换 ID 之后索引指向新的 ID
+	_, err := s.Rotate(ctx, "a", "a2", time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, s.Remove(ctx, "b"))
+	require.NoError(t, s.Touch(ctx, "c", time.Unix(100, 0)))
+	infos, err := s.Sessions(ctx, "tom")
+	require.NoError(t, err)
+	ids := make([]string, 0, len(infos))
+	for _, info := range infos {
+		ids = append(ids, info.ID)
+		if info.ID == "c" {
+			assert.Equal(t, time.Unix(100, 0), info.LastSeen)
+		}
+	}
+	assert.ElementsMatch(t, []string{"a2", "c"}, ids)
+
+	// 过期之后也会从索引里面删除
+	time.Sleep(100 * time.Millisecond)
+	infos, err = s.Sessions(ctx, "tom")
+	require.NoError(t, err)
+	assert.Len(t, infos, 0)
+	s.index.mutex.Lock()
+	defer s.index.mutex.Unlock()
+	assert.Len(t, s.index.owners, 0)
+}
+
+func TestMemorySession(t *testing.T) {
+	ctx := context.Background()
+	sess, err := NewStore(time.Minute).Generate(ctx, "id")
+	require.NoError(t, err)
+
+	_, err = sess.Get(ctx, "key1")
+	assert.Equal(t, session.ErrKeyNotFound, err)
+
+	require.NoError(t, sess.Set(ctx, "key1", "val1"))
+	require.NoError(t, sess.Set(ctx, "key2", 2))
+	keys, err := sess.Keys(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"key1", "key2"}, keys)
+
+	require.NoError(t, sess.Delete(ctx, "key1"))
+	_, err = sess.Get(ctx, "key1")
+	assert.Equal(t, session.ErrKeyNotFound, err)
+	val, err := session.GetAs[int](ctx, sess, "key2")
+	require.NoError(t, err)
+	assert.Equal(t, 2, val)
+
+	require.NoError(t, sess.Clear(ctx))
+	keys, err = sess.Keys(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+// TestMemorySession_Concurrent 需要配合 -race 运行
+func TestMemorySession_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore(time.Minute)
+	sess, err := s.Generate(ctx, "id")
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := fmt.Sprintf("key%d", i%3)
+			for j := 0; j < 100; j++ {
+				_ = sess.Set(ctx, key, j)
+				_, _ = sess.Get(ctx, key)
+				_, _ = sess.Keys(ctx)
+				_ = sess.Delete(ctx, key)
+				if j%50 == 0 {
+					_ = sess.Clear(ctx)
+				}
+			}
+		}(i)
+	}
+	// 同时换 ID
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, _ = s.Rotate(ctx, "id", "new-id", time.Minute)
+	}()
+	wg.Wait()
+}
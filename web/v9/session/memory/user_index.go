@@ -0,0 +1,108 @@
+package memory
+
+import (
+	"context"
+	"github.com/go-tour/web/v9/session"
+	"sync"
+	"time"
+)
+
+// userIndex 用户到 session 的索引
+type userIndex struct {
+	mutex sync.Mutex
+	// uid => session id => 元数据
+	users map[string]map[string]*session.SessionInfo
+	// session id => uid
+	owners map[string]string
+}
+
+func newUserIndex() *userIndex {
+	return &userIndex{
+		users:  make(map[string]map[string]*session.SessionInfo, 16),
+		owners: make(map[string]string, 16),
+	}
+}
+
+func (m *Store) Bind(ctx context.Context, info session.SessionInfo) error {
+	val, ok := m.c.Get(info.ID)
+	if !ok {
+		return session.ErrSessionNotFound
+	}
+	info.CreatedAt = val.(*memorySession).createdAt
+	idx := m.index
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+	idx.unbind(info.ID)
+	sessions, ok := idx.users[info.UserID]
+	if !ok {
+		sessions = make(map[string]*session.SessionInfo, 4)
+		idx.users[info.UserID] = sessions
+	}
+	sessions[info.ID] = &info
+	idx.owners[info.ID] = info.UserID
+	return nil
+}
+
+func (m *Store) Touch(ctx context.Context, id string, lastSeen time.Time) error {
+	idx := m.index
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+	uid, ok := idx.owners[id]
+	if !ok {
+		return nil
+	}
+	idx.users[uid][id].LastSeen = lastSeen
+	return nil
+}
+
+func (m *Store) Sessions(ctx context.Context, uid string) ([]session.SessionInfo, error) {
+	idx := m.index
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+	res := make([]session.SessionInfo, 0, len(idx.users[uid]))
+	for id, info := range idx.users[uid] {
+		// 过期了但是还没有被清理的
+		if _, ok := m.c.Get(id); !ok {
+			continue
+		}
+		res = append(res, *info)
+	}
+	return res, nil
+}
+
+// rename 换 ID 的时候，索引也指向新的 ID
+func (idx *userIndex) rename(oldID string, newID string) {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+	uid, ok := idx.owners[oldID]
+	if !ok {
+		return
+	}
+	sessions := idx.users[uid]
+	info := sessions[oldID]
+	delete(sessions, oldID)
+	delete(idx.owners, oldID)
+	info.ID = newID
+	sessions[newID] = info
+	idx.owners[newID] = uid
+}
+
+func (idx *userIndex) remove(id string) {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+	idx.unbind(id)
+}
+
+// unbind 调用者需要持有锁
+func (idx *userIndex) unbind(id string) {
+	uid, ok := idx.owners[id]
+	if !ok {
+		return
+	}
+	delete(idx.owners, id)
+	sessions := idx.users[uid]
+	delete(sessions, id)
+	if len(sessions) == 0 {
+		delete(idx.users, uid)
+	}
+}
@@ -0,0 +1,143 @@
+package session
+
+import (
+	"context"
+	web "github.com/go-tour/web/v9"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+type loaderKey struct{}
+
+type middlewareOptions struct {
+	excludedPaths []string
+	unauthHdl     web.HandleFunc
+}
+
+type MiddlewareOption func(opts *middlewareOptions)
+
+// WithExcludedPaths 这些路径不要求有 session，例如登录页面
+// 以 /* 结尾的代表前缀匹配，例如 /static/*
+// 被排除的路径依旧可以通过 FromContext 拿到 session，只是没有的话也不会被拦下来
+func WithExcludedPaths(paths ...string) MiddlewareOption {
+	return func(opts *middlewareOptions) {
+		opts.excludedPaths = append(opts.excludedPaths, paths...)
+	}
+}
+
+// WithUnauthenticatedHandler 没有 session 的时候执行，默认返回 401
+func WithUnauthenticatedHandler(hdl web.HandleFunc) MiddlewareOption {
+	return func(opts *middlewareOptions) {
+		opts.unauthHdl = hdl
+	}
+}
+
+func (o *middlewareOptions) excluded(path string) bool {
+	for _, p := range o.excludedPaths {
+		if p == path {
+			return true
+		}
+		if strings.HasSuffix(p, "/*") && strings.HasPrefix(path, p[:len(p)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware 返回一个管理 session 生命周期的 Middleware
+// 1. session 是在第一次使用的时候才加载的，并且加载的时候会刷新过期时间
+// 2. 没有被排除的路径要求必须有 session，否则执行 WithUnauthenticatedHandler 设置的 handler
+// 3. 在 handler 执行之后，调用 Flush 将修改写回存储，写回失败会返回 500
+//
+// 在 handler 里面可以通过 FromContext 拿到 session
+func (m *Manager) Middleware(opts ...MiddlewareOption) web.Middleware {
+	options := &middlewareOptions{
+		unauthHdl: func(ctx *web.Context) {
+			ctx.RespStatusCode = http.StatusUnauthorized
+		},
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return func(next web.HandleFunc) web.HandleFunc {
+		return func(ctx *web.Context) {
+			l := &loader{m: m, ctx: ctx}
+			ctx.Req = ctx.Req.WithContext(context.WithValue(ctx.Req.Context(), loaderKey{}, l))
+			if !options.excluded(ctx.Req.URL.Path) {
+				if _, err := l.load(); err != nil {
+					options.unauthHdl(ctx)
+					return
+				}
+			}
+			next(ctx)
+			if err := m.Flush(ctx); err != nil {
+				ctx.RespStatusCode = http.StatusInternalServerError
+				ctx.RespData = []byte("保存 session 失败")
+			}
+		}
+	}
+}
+
+// FromContext 返回当前请求的 session，只能在 Manager 的 Middleware 后面使用
+// ctx 是 web.Context 里面的 Req.Context()
+func FromContext(ctx context.Context) (Session, error) {
+	l, ok := ctx.Value(loaderKey{}).(*loader)
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	return l.load()
+}
+
+// ValueFromContext 返回当前请求的 session 里面 key 对应的值，并且转换为类型 T
+func ValueFromContext[T any](ctx context.Context, key string) (T, error) {
+	sess, err := FromContext(ctx)
+	if err != nil {
+		var t T
+		return t, err
+	}
+	return GetAs[T](ctx, sess, key)
+}
+
+// loader 负责延迟加载 session
+type loader struct {
+	m         *Manager
+	ctx       *web.Context
+	mutex     sync.Mutex
+	refreshed bool
+}
+
+func (l *loader) load() (Session, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	// 每次都通过 GetSession 来拿，这样 InitSession 和 RotateSession 之后拿到的就是新的 session
+	sess, err := l.m.GetSession(l.ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !l.refreshed {
+		if err = l.m.Refresh(l.ctx.Req.Context(), sess.ID()); err != nil {
+			return nil, err
+		}
+		l.m.touch(l.ctx.Req.Context(), sess.ID())
+		l.refreshed = true
+	}
+	return sess, nil
+}
+
+// loadOrInit 没有 session 的时候创建一个新的
+func (l *loader) loadOrInit() (Session, error) {
+	sess, err := l.load()
+	if err == nil {
+		return sess, nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	sess, err = l.m.InitSession(l.ctx, l.m.genID())
+	if err != nil {
+		return nil, err
+	}
+	// 刚创建的不需要刷新
+	l.refreshed = true
+	return sess, nil
+}
@@ -0,0 +1,49 @@
+package session
+
+import (
+	"net/http"
+)
+
+var _ Propagator = &CompositePropagator{}
+
+// CompositePropagator 组合多个 Propagator
+// Extract 的时候按照顺序尝试，第一个拿到 session id 的为准；
+// Inject 和 Remove 的时候则是每一个都会执行。
+// 例如浏览器使用 cookie，而 APP 使用 header，那么可以把两者组合起来
+type CompositePropagator struct {
+	propagators []Propagator
+}
+
+func NewCompositePropagator(propagators ...Propagator) *CompositePropagator {
+	return &CompositePropagator{
+		propagators: propagators,
+	}
+}
+
+func (c *CompositePropagator) Inject(id string, writer http.ResponseWriter) error {
+	for _, p := range c.propagators {
+		if err := p.Inject(id, writer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *CompositePropagator) Extract(req *http.Request) (string, error) {
+	for _, p := range c.propagators {
+		id, err := p.Extract(req)
+		if err == nil && id != "" {
+			return id, nil
+		}
+	}
+	return "", ErrIDNotFound
+}
+
+func (c *CompositePropagator) Remove(writer http.ResponseWriter) error {
+	for _, p := range c.propagators {
+		if err := p.Remove(writer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
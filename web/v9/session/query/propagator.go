@@ -0,0 +1,36 @@
+package query
+
+import (
+	"github.com/go-tour/web/v9/session"
+	"net/http"
+)
+
+// Propagator 从查询参数里面读取 session id，例如 /download?sid=xxx
+// 服务端没有办法把 session id 写进客户端后续请求的 URL，
+// 所以 Inject 和 Remove 什么也不做，一般会和其它的 Propagator 组合使用。
+// 注意 URL 很容易出现在日志和 Referer 里面，只应该用在没有办法设置 header 的场景
+type Propagator struct {
+	paramName string
+}
+
+func NewPropagator(paramName string) *Propagator {
+	return &Propagator{
+		paramName: paramName,
+	}
+}
+
+func (p *Propagator) Inject(id string, writer http.ResponseWriter) error {
+	return nil
+}
+
+func (p *Propagator) Extract(req *http.Request) (string, error) {
+	val := req.URL.Query().Get(p.paramName)
+	if val == "" {
+		return "", session.ErrIDNotFound
+	}
+	return val, nil
+}
+
+func (p *Propagator) Remove(writer http.ResponseWriter) error {
+	return nil
+}
@@ -0,0 +1,95 @@
+package sqlstore
+
+import (
+	"context"
+	"encoding/json"
+	orm "github.com/go-tour/orm/v16"
+	"github.com/go-tour/web/v9/session"
+	"sync"
+)
+
+var _ session.Flusher = &sqlSession{}
+
+// sqlSession 所有的修改都只发生在内存里面，调用 Flush 的时候才会写回数据库
+// 写回的时候会覆盖整个 data，所以同一个 session 的并发请求，后写回的会覆盖先写回的
+type sqlSession struct {
+	id    string
+	db    *orm.DB
+	mutex sync.RWMutex
+	data  map[string]any
+	dirty bool
+}
+
+func (s *sqlSession) Get(ctx context.Context, key string) (any, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	val, ok := s.data[key]
+	if !ok {
+		return nil, session.ErrKeyNotFound
+	}
+	return val, nil
+}
+
+func (s *sqlSession) Set(ctx context.Context, key string, val any) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.data[key] = val
+	s.dirty = true
+	return nil
+}
+
+func (s *sqlSession) Delete(ctx context.Context, key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.data[key]; !ok {
+		return nil
+	}
+	delete(s.data, key)
+	s.dirty = true
+	return nil
+}
+
+func (s *sqlSession) Keys(ctx context.Context) ([]string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	res := make([]string, 0, len(s.data))
+	for key := range s.data {
+		res = append(res, key)
+	}
+	return res, nil
+}
+
+func (s *sqlSession) Clear(ctx context.Context) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.data = make(map[string]any)
+	s.dirty = true
+	return nil
+}
+
+func (s *sqlSession) ID() string {
+	return s.id
+}
+
+// Flush 如果数据被修改过，就写回数据库
+func (s *sqlSession) Flush(ctx context.Context) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.dirty {
+		return nil
+	}
+	bs, err := json.Marshal(s.data)
+	if err != nil {
+		return err
+	}
+	err = orm.NewUpdater[sessionRow](s.db).
+		Update(&sessionRow{Data: bs}).
+		Set(orm.C("Data")).
+		Where(orm.C("Id").EQ(s.id)).
+		Exec(ctx).Err()
+	if err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
@@ -0,0 +1,238 @@
+package sqlstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	orm "github.com/go-tour/orm/v16"
+	"github.com/go-tour/web/v9/session"
+	"sync"
+	"time"
+)
+
+const (
+	defaultGCInterval = time.Minute
+	deleteSQL         = "DELETE FROM `sessions` WHERE `id`=?;"
+	gcSQL             = "DELETE FROM `sessions` WHERE `expire_at`<?;"
+)
+
+// sessionRow 对应的表结构，以 SQLite3 为例：
+//
+//	CREATE TABLE `sessions` (
+//	  `id` VARCHAR(128) PRIMARY KEY,
+//	  `data` BLOB,
+//	  `expire_at` BIGINT NOT NULL,
+//	  `user_id` VARCHAR(128) NOT NULL DEFAULT '',
+//	  `created_at` BIGINT NOT NULL DEFAULT 0,
+//	  `last_seen` BIGINT NOT NULL DEFAULT 0,
+//	  `ip` VARCHAR(64) NOT NULL DEFAULT '',
+//	  `user_agent` VARCHAR(512) NOT NULL DEFAULT ''
+//	);
+//	CREATE INDEX `idx_expire_at` ON `sessions` (`expire_at`);
+//	CREATE INDEX `idx_user_id` ON `sessions` (`user_id`);
+type sessionRow struct {
+	Id   string
+	Data []byte
+	// ExpireAt 过期时间，毫秒数
+	ExpireAt int64
+	// UserId 绑定的用户，没有绑定的时候是空字符串
+	UserId string
+	// CreatedAt 和 LastSeen 也都是毫秒数
+	CreatedAt int64
+	LastSeen  int64
+	Ip        string
+	UserAgent string
+}
+
+func (sessionRow) TableName() string {
+	return "sessions"
+}
+
+type StoreOption func(s *Store)
+
+// WithGCInterval 设置清理过期 session 的间隔，默认是一分钟
+// 小于等于 0 代表不在后台清理，那么需要用户自己定时调用 GC
+func WithGCInterval(interval time.Duration) StoreOption {
+	return func(s *Store) {
+		s.gcInterval = interval
+	}
+}
+
+var _ session.Store = &Store{}
+
+// Store 将 session 保存在数据库里面
+// Session 上的修改并不会立刻写回数据库，而是要等到调用 Flush，
+// 使用 session.Manager 的 Middleware 的时候，会在请求结束的时候自动调用 Flush
+type Store struct {
+	db         *orm.DB
+	expiration time.Duration
+	gcInterval time.Duration
+	closeOnce  sync.Once
+	closeCh    chan struct{}
+}
+
+// NewStore 创建一个 Store 的实例
+// 如果开启了后台清理，那么不再使用的时候需要调用 Close
+func NewStore(db *orm.DB, expiration time.Duration, opts ...StoreOption) *Store {
+	res := &Store{
+		db:         db,
+		expiration: expiration,
+		gcInterval: defaultGCInterval,
+		closeCh:    make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	if res.gcInterval > 0 {
+		go res.gcLoop()
+	}
+	return res
+}
+
+func (s *Store) Generate(ctx context.Context, id string) (session.Session, error) {
+	sess := &sqlSession{
+		id:   id,
+		db:   s.db,
+		data: make(map[string]any),
+	}
+	// 同一个 id 可能还有过期了但是没有被清理掉的数据，所以这里用 upsert
+	err := s.upsert(ctx, orm.NewInserter[sessionRow](s.db), &sessionRow{
+		Id:        id,
+		Data:      []byte("{}"),
+		ExpireAt:  s.expireAt(),
+		CreatedAt: time.Now().UnixMilli(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sess, nil
+}
+
+func (s *Store) Refresh(ctx context.Context, id string) error {
+	now := time.Now().UnixMilli()
+	affected, err := orm.NewUpdater[sessionRow](s.db).
+		Set(orm.Assign("ExpireAt", s.expireAt())).
+		Where(orm.C("Id").EQ(id).And(orm.C("ExpireAt").GT(now))).
+		Exec(ctx).RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return session.ErrSessionNotFound
+	}
+	return nil
+}
+
+func (s *Store) Remove(ctx context.Context, id string) error {
+	return orm.RawQuery[sessionRow](s.db, deleteSQL, id).Exec(ctx).Err()
+}
+
+func (s *Store) Get(ctx context.Context, id string) (session.Session, error) {
+	row, err := s.get(ctx, orm.NewSelector[sessionRow](s.db), id)
+	if err != nil {
+		return nil, err
+	}
+	return s.newSession(row)
+}
+
+// Rotate 在一个事务里面完成复制数据和修改老 session 的过期时间
+// 绑定的用户和创建时间也会被复制过去，老的 session 不再出现在用户的索引里面
+func (s *Store) Rotate(ctx context.Context, oldID string, newID string, grace time.Duration) (session.Session, error) {
+	var sess *sqlSession
+	err := s.db.DoTx(ctx, func(ctx context.Context, tx *orm.Tx) error {
+		row, err := s.get(ctx, orm.NewSelector[sessionRow](tx), oldID)
+		if err != nil {
+			return err
+		}
+		row.Id = newID
+		row.ExpireAt = s.expireAt()
+		if err = s.upsert(ctx, orm.NewInserter[sessionRow](tx), row); err != nil {
+			return err
+		}
+		if grace <= 0 {
+			err = orm.RawQuery[sessionRow](tx, deleteSQL, oldID).Exec(ctx).Err()
+		} else {
+			err = orm.NewUpdater[sessionRow](tx).
+				Set(orm.Assign("ExpireAt", time.Now().Add(grace).UnixMilli()), orm.Assign("UserId", "")).
+				Where(orm.C("Id").EQ(oldID)).
+				Exec(ctx).Err()
+		}
+		if err != nil {
+			return err
+		}
+		sess, err = s.newSession(row)
+		return err
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return sess, nil
+}
+
+// GC 删除所有已经过期的 session
+func (s *Store) GC(ctx context.Context) error {
+	return orm.RawQuery[sessionRow](s.db, gcSQL, time.Now().UnixMilli()).Exec(ctx).Err()
+}
+
+// Close 停止后台清理
+func (s *Store) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closeCh)
+	})
+	return nil
+}
+
+func (s *Store) gcLoop() {
+	ticker := time.NewTicker(s.gcInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), s.gcInterval)
+			// 清理失败了也没关系，下一次还会再清理
+			_ = s.GC(ctx)
+			cancel()
+		case <-s.closeCh:
+			return
+		}
+	}
+}
+
+// get 和 upsert 接收构造好的 Selector 和 Inserter，这样在事务内外都可以使用
+func (s *Store) get(ctx context.Context, sel *orm.Selector[sessionRow], id string) (*sessionRow, error) {
+	row, err := sel.
+		Where(orm.C("Id").EQ(id).And(orm.C("ExpireAt").GT(time.Now().UnixMilli()))).
+		Get(ctx)
+	if errors.Is(err, orm.ErrNoRows) {
+		return nil, session.ErrSessionNotFound
+	}
+	return row, err
+}
+
+// upsert 冲突的时候覆盖除了 Id 以外所有的列
+func (s *Store) upsert(ctx context.Context, i *orm.Inserter[sessionRow], row *sessionRow) error {
+	return i.
+		Values(row).
+		OnDuplicateKey().ConflictColumns("Id").
+		Update(orm.C("Data"), orm.C("ExpireAt"), orm.C("UserId"),
+			orm.C("CreatedAt"), orm.C("LastSeen"), orm.C("Ip"), orm.C("UserAgent")).
+		Exec(ctx).Err()
+}
+
+func (s *Store) newSession(row *sessionRow) (*sqlSession, error) {
+	data := make(map[string]any)
+	if len(row.Data) > 0 {
+		if err := json.Unmarshal(row.Data, &data); err != nil {
+			return nil, err
+		}
+	}
+	return &sqlSession{
+		id:   row.Id,
+		db:   s.db,
+		data: data,
+	}, nil
+}
+
+func (s *Store) expireAt() int64 {
+	return time.Now().Add(s.expiration).UnixMilli()
+}
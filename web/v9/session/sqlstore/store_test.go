@@ -0,0 +1,302 @@
+package sqlstore
+
+import (
+	"context"
+	"fmt"
+	orm "github.com/go-tour/orm/v16"
+	web "github.com/go-tour/web/v9"
+	"github.com/go-tour/web/v9/session"
+	"github.com/go-tour/web/v9/session/cookie"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const createSQL = `
+CREATE TABLE IF NOT EXISTS sessions(
+    id VARCHAR(128) PRIMARY KEY,
+    data BLOB,
+    expire_at BIGINT NOT NULL,
+    user_id VARCHAR(128) NOT NULL DEFAULT '',
+    created_at BIGINT NOT NULL DEFAULT 0,
+    last_seen BIGINT NOT NULL DEFAULT 0,
+    ip VARCHAR(64) NOT NULL DEFAULT '',
+    user_agent VARCHAR(512) NOT NULL DEFAULT ''
+)
+`
+
+func TestStore_Flush(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore(memoryDB(t), time.Minute, WithGCInterval(0))
+	sess, err := s.Generate(ctx, "id")
+	require.NoError(t, err)
+	require.NoError(t, sess.Set(ctx, "user_id", 123))
+	require.NoError(t, sess.Set(ctx, "name", "Tom"))
+
+	// 还没有写回去
+	sess2, err := s.Get(ctx, "id")
+	require.NoError(t, err)
+	_, err = sess2.Get(ctx, "user_id")
+	assert.Equal(t, session.ErrKeyNotFound, err)
+
+	require.NoError(t, sess.(session.Flusher).Flush(ctx))
+	sess2, err = s.Get(ctx, "id")
+	require.NoError(t, err)
+	uid, err := session.GetAs[int](ctx, sess2, "user_id")
+	require.NoError(t, err)
+	assert.Equal(t, 123, uid)
+	name, err := session.GetAs[string](ctx, sess2, "name")
+	require.NoError(t, err)
+	assert.Equal(t, "Tom", name)
+
+	require.NoError(t, sess2.Delete(ctx, "name"))
+	require.NoError(t, sess2.(session.Flusher).Flush(ctx))
+	sess3, err := s.Get(ctx, "id")
+	require.NoError(t, err)
+	keys, err := sess3.Keys(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user_id"}, keys)
+}
+
+func TestStore_Expiration(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore(memoryDB(t), 100*time.Millisecond, WithGCInterval(0))
+	_, err := s.Generate(ctx, "id")
+	require.NoError(t, err)
+
+	time.Sleep(60 * time.Millisecond)
+	require.NoError(t, s.Refresh(ctx, "id"))
+	time.Sleep(60 * time.Millisecond)
+	_, err = s.Get(ctx, "id")
+	require.NoError(t, err)
+
+	time.Sleep(120 * time.Millisecond)
+	_, err = s.Get(ctx, "id")
+	assert.Equal(t, session.ErrSessionNotFound, err)
+	assert.Equal(t, session.ErrSessionNotFound, s.Refresh(ctx, "id"))
+
+	// 过期了但是没有清理的 id 可以重新生成
+	_, err = s.Generate(ctx, "id")
+	require.NoError(t, err)
+	_, err = s.Get(ctx, "id")
+	assert.NoError(t, err)
+}
+
+func TestStore_Remove(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore(memoryDB(t), time.Minute, WithGCInterval(0))
+	_, err := s.Generate(ctx, "id")
+	require.NoError(t, err)
+	require.NoError(t, s.Remove(ctx, "id"))
+	_, err = s.Get(ctx, "id")
+	assert.Equal(t, session.ErrSessionNotFound, err)
+}
+
+func TestStore_Rotate(t *testing.T) {
+	testCases := []struct {
+		name  string
+		grace time.Duration
+		after time.Duration
+
+		wantOld bool
+	}{
+		{
+			name:    "in grace",
+			grace:   time.Minute,
+			wantOld: true,
+		},
+		{
+			name:  "after grace",
+			grace: 10 * time.Millisecond,
+			after: 20 * time.Millisecond,
+		},
+		{
+			name: "no grace",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := NewStore(memoryDB(t), time.Minute, WithGCInterval(0))
+			old, err := s.Generate(ctx, "old")
+			require.NoError(t, err)
+			require.NoError(t, old.Set(ctx, "user_id", "123"))
+			require.NoError(t, old.(session.Flusher).Flush(ctx))
+
+			sess, err := s.Rotate(ctx, "old", "new", tc.grace)
+			require.NoError(t, err)
+			assert.Equal(t, "new", sess.ID())
+			val, err := sess.Get(ctx, "user_id")
+			require.NoError(t, err)
+			assert.Equal(t, "123", val)
+
+			time.Sleep(tc.after)
+			_, err = s.Get(ctx, "old")
+			assert.Equal(t, tc.wantOld, err == nil)
+			_, err = s.Get(ctx, "new")
+			assert.NoError(t, err)
+		})
+	}
+
+	s := NewStore(memoryDB(t), time.Minute, WithGCInterval(0))
+	_, err := s.Rotate(context.Background(), "not-exist", "new", time.Minute)
+	assert.Equal(t, session.ErrSessionNotFound, err)
+}
+
+func TestStore_GC(t *testing.T) {
+	ctx := context.Background()
+	db := memoryDB(t)
+	s := NewStore(db, 10*time.Millisecond, WithGCInterval(20*time.Millisecond))
+	defer s.Close()
+	_, err := s.Generate(ctx, "id")
+	require.NoError(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+	_, err = orm.RawQuery[sessionRow](db, "SELECT * FROM `sessions` WHERE `id`=?;", "id").Get(ctx)
+	assert.Equal(t, orm.ErrNoRows, err)
+}
+
+func TestStore_UserIndex(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore(memoryDB(t), 100*time.Millisecond, WithGCInterval(0))
+	for _, id := range []string{"a", "b", "c"} {
+		_, err := s.Generate(ctx, id)
+		require.NoError(t, err)
+		require.NoError(t, s.Bind(ctx, session.SessionInfo{ID: id, UserID: "tom", IP: "192.0.2.1"}))
+	}
+	assert.Equal(t, session.ErrSessionNotFound, s.Bind(ctx, session.SessionInfo{ID: "not-exist", UserID: "tom"}))
+	before, err := s.Sessions(ctx, "tom")
+	require.NoError(t, err)
+	require.Len(t, before, 3)
+	var createdAt time.Time
+	for _, info := range before {
+		if info.ID == "a" {
+			createdAt = info.CreatedAt
+		}
+	}
+	assert.False(t, createdAt.IsZero())
+
+	// 换 ID 之后索引指向新的 ID，创建时间不变
+	time.Sleep(2 * time.Millisecond)
+	_, err = s.Rotate(ctx, "a", "a2", time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, s.Remove(ctx, "b"))
+	require.NoError(t, s.Touch(ctx, "c", time.UnixMilli(100)))
+	infos, err := s.Sessions(ctx, "tom")
+	require.NoError(t, err)
+	ids := make([]string, 0, len(infos))
+	for _, info := range infos {
+		ids = append(ids, info.ID)
+		assert.Equal(t, "tom", info.UserID)
+		assert.Equal(t, "192.0.2.1", info.IP)
+		switch info.ID {
+		case "a2":
+			assert.Equal(t, createdAt, info.CreatedAt)
+		case "c":
+			assert.Equal(t, time.UnixMilli(100), info.LastSeen)
+		}
+	}
+	assert.ElementsMatch(t, []string{"a2", "c"}, ids)
+
+	// 过期了就不再出现
+	time.Sleep(120 * time.Millisecond)
+	infos, err = s.Sessions(ctx, "tom")
+	require.NoError(t, err)
+	assert.Len(t, infos, 0)
+}
+
+// TestManager_Flush 在请求结束的时候写回 session
+func TestManager_Flush(t *testing.T) {
+	s := NewStore(memoryDB(t), time.Minute, WithGCInterval(0))
+	m := &session.Manager{
+		Store:      s,
+		Propagator: cookie.NewPropagator("sessid"),
+		SessCtxKey: "_sess",
+	}
+	server := web.NewHTTPServer()
+	server.Use(func(next web.HandleFunc) web.HandleFunc {
+		return func(ctx *web.Context) {
+			next(ctx)
+			if err := m.Flush(ctx); err != nil {
+				ctx.RespStatusCode = http.StatusInternalServerError
+			}
+		}
+	})
+	server.Post("/login", func(ctx *web.Context) {
+		sess, err := m.InitSession(ctx, "id")
+		require.NoError(t, err)
+		require.NoError(t, sess.Set(ctx.Req.Context(), "user_id", "123"))
+	})
+	server.Get("/profile", func(ctx *web.Context) {
+		sess, err := m.GetSession(ctx)
+		require.NoError(t, err)
+		uid, err := session.GetAs[string](ctx.Req.Context(), sess, "user_id")
+		require.NoError(t, err)
+		ctx.RespData = []byte(uid)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/login", nil)
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/profile", nil)
+	for _, c := range recorder.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	assert.Equal(t, "123", recorder.Body.String())
+}
+
+// TestManager_RotateSession 换 ID 之前设置的值不能丢失
+func TestManager_RotateSession(t *testing.T) {
+	s := NewStore(memoryDB(t), time.Minute, WithGCInterval(0))
+	m := &session.Manager{
+		Store:      s,
+		Propagator: cookie.NewPropagator("sessid"),
+		SessCtxKey: "_sess",
+		GenIDFunc: func() string {
+			return "new"
+		},
+	}
+	ctx := context.Background()
+	_, err := s.Generate(ctx, "old")
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/login", nil)
+	req.AddCookie(&http.Cookie{Name: "sessid", Value: "old"})
+	webCtx := &web.Context{Req: req, Resp: httptest.NewRecorder()}
+	sess, err := m.GetSession(webCtx)
+	require.NoError(t, err)
+	require.NoError(t, sess.Set(ctx, "user_id", "123"))
+
+	newSess, err := m.RotateSession(webCtx)
+	require.NoError(t, err)
+	assert.Equal(t, "new", newSess.ID())
+
+	// 直接从数据库读，而不是使用内存里面的 Session
+	stored, err := s.Get(ctx, "new")
+	require.NoError(t, err)
+	uid, err := session.GetAs[string](ctx, stored, "user_id")
+	require.NoError(t, err)
+	assert.Equal(t, "123", uid)
+}
+
+// memoryDB 每个测试都使用独立的 sqlite3 内存数据库
+func memoryDB(t *testing.T) *orm.DB {
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	db, err := orm.Open("sqlite3", fmt.Sprintf("file:%s.db?cache=shared&mode=memory", name),
+		orm.DBWithDialect(orm.SQLite3))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	require.NoError(t, orm.RawQuery[any](db, createSQL).Exec(context.Background()).Err())
+	return db
+}
@@ -0,0 +1,57 @@
+package sqlstore
+
+import (
+	"context"
+	orm "github.com/go-tour/orm/v16"
+	"github.com/go-tour/web/v9/session"
+	"time"
+)
+
+// Bind 用户信息直接保存在 sessions 表里面，
+// 所以 Remove，GC 和 Rotate 的时候索引自然也跟着变化
+func (s *Store) Bind(ctx context.Context, info session.SessionInfo) error {
+	affected, err := orm.NewUpdater[sessionRow](s.db).
+		Set(orm.Assign("UserId", info.UserID),
+			orm.Assign("LastSeen", info.LastSeen.UnixMilli()),
+			orm.Assign("Ip", info.IP),
+			orm.Assign("UserAgent", info.UserAgent)).
+		Where(orm.C("Id").EQ(info.ID).And(orm.C("ExpireAt").GT(time.Now().UnixMilli()))).
+		Exec(ctx).RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return session.ErrSessionNotFound
+	}
+	return nil
+}
+
+func (s *Store) Touch(ctx context.Context, id string, lastSeen time.Time) error {
+	return orm.NewUpdater[sessionRow](s.db).
+		Set(orm.Assign("LastSeen", lastSeen.UnixMilli())).
+		Where(orm.C("Id").EQ(id)).
+		Exec(ctx).Err()
+}
+
+func (s *Store) Sessions(ctx context.Context, uid string) ([]session.SessionInfo, error) {
+	rows, err := orm.NewSelector[sessionRow](s.db).
+		Select(orm.C("Id"), orm.C("UserId"), orm.C("CreatedAt"),
+			orm.C("LastSeen"), orm.C("Ip"), orm.C("UserAgent")).
+		Where(orm.C("UserId").EQ(uid).And(orm.C("ExpireAt").GT(time.Now().UnixMilli()))).
+		GetMulti(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]session.SessionInfo, 0, len(rows))
+	for _, row := range rows {
+		res = append(res, session.SessionInfo{
+			ID:        row.Id,
+			UserID:    row.UserId,
+			CreatedAt: time.UnixMilli(row.CreatedAt),
+			LastSeen:  time.UnixMilli(row.LastSeen),
+			IP:        row.Ip,
+			UserAgent: row.UserAgent,
+		})
+	}
+	return res, nil
+}
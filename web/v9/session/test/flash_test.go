@@ -0,0 +1,193 @@
+package test
+
+import (
+	"context"
+	"errors"
+	web "github.com/go-tour/web/v9"
+	"github.com/go-tour/web/v9/session"
+	"github.com/go-tour/web/v9/session/cookie"
+	"github.com/go-tour/web/v9/session/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestFlash 登录失败之后重定向回登录页面，展示错误信息
+func TestFlash(t *testing.T) {
+	m := &session.Manager{
+		SessCtxKey: "_sess",
+		Store:      memory.NewStore(30 * time.Minute),
+		Propagator: cookie.NewPropagator("sessid"),
+	}
+	engine := &web.GoTemplateEngine{}
+	engine.RegisterFuncProvider(session.TemplateFuncs)
+	engine.RegisterFuncProvider(func(ctx context.Context) template.FuncMap {
+		return template.FuncMap{
+			"csrfField": func() template.HTML { return "" },
+		}
+	})
+	require.NoError(t, engine.LoadFromGlob("../../testdata/tpls/*.gohtml"))
+
+	s := web.NewHTTPServer(web.ServerWithTemplateEngine(engine))
+	s.Use(m.Middleware(session.WithExcludedPaths("/login")))
+	s.Get("/login", func(ctx *web.Context) {
+		_ = ctx.Render("login.gohtml", nil)
+	})
+	s.Post("/login", func(ctx *web.Context) {
+		pwd, _ := ctx.FormValue("password").String()
+		if pwd != "123456" {
+			require.NoError(t, session.AddFlash(ctx.Req.Context(), "error", "invalid password"))
+			ctx.Resp.Header().Set("Location", "/login")
+			ctx.RespStatusCode = http.StatusSeeOther
+			return
+		}
+		ctx.RespData = []byte("ok")
+	})
+
+	form := url.Values{"email": {"a@b.com"}, "password": {"wrong"}}
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusSeeOther, recorder.Code)
+	// 原本没有 session，AddFlash 会创建一个
+	cookies := recorder.Result().Cookies()
+	require.Len(t, cookies, 1)
+
+	// 只有重定向之后的第一次能看到
+	wants := []bool{true, false}
+	for _, want := range wants {
+		req = httptest.NewRequest(http.MethodGet, "/login", nil)
+		req.AddCookie(cookies[0])
+		recorder = httptest.NewRecorder()
+		s.ServeHTTP(recorder, req)
+		require.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, want, strings.Contains(recorder.Body.String(), "invalid password"))
+	}
+
+	// 没有 session 的时候也能正常渲染
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/login", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
+
+func TestFlashes(t *testing.T) {
+	m := &session.Manager{
+		SessCtxKey: "_sess",
+		Store:      memory.NewStore(30 * time.Minute),
+		Propagator: cookie.NewPropagator("sessid"),
+	}
+	s := web.NewHTTPServer()
+	s.Use(m.Middleware(session.WithExcludedPaths("/add")))
+	s.Post("/add", func(ctx *web.Context) {
+		c := ctx.Req.Context()
+		require.NoError(t, session.AddFlash(c, "info", "a"))
+		require.NoError(t, session.AddFlash(c, "info", "b"))
+		require.NoError(t, session.AddFlash(c, "error", "c"))
+	})
+	s.Get("/show", func(ctx *web.Context) {
+		c := ctx.Req.Context()
+		info, err := session.Flashes(c, "info")
+		require.NoError(t, err)
+		errs, err := session.Flashes(c, "error")
+		require.NoError(t, err)
+		ctx.RespData = []byte(strings.Join(info, ",") + "|" + strings.Join(errs, ","))
+	})
+
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/add", nil))
+	cookies := recorder.Result().Cookies()
+	require.Len(t, cookies, 1)
+
+	wants := []string{"a,b|c", "|"}
+	for _, want := range wants {
+		req := httptest.NewRequest(http.MethodGet, "/show", nil)
+		req.AddCookie(cookies[0])
+		recorder = httptest.NewRecorder()
+		s.ServeHTTP(recorder, req)
+		assert.Equal(t, want, recorder.Body.String())
+	}
+
+	// 没有经过 Middleware
+	assert.Equal(t, session.ErrSessionNotFound, session.AddFlash(context.Background(), "info", "a"))
+}
+
+func TestFlashes_StoreError(t *testing.T) {
+	store := &failingStore{Store: memory.NewStore(30 * time.Minute)}
+	m := &session.Manager{
+		SessCtxKey: "_sess",
+		Store:      store,
+		Propagator: cookie.NewPropagator("sessid"),
+	}
+	s := web.NewHTTPServer()
+	s.Use(m.Middleware(session.WithExcludedPaths("/add", "/show")))
+	s.Post("/add", func(ctx *web.Context) {
+		require.NoError(t, session.AddFlash(ctx.Req.Context(), "info", "a"))
+	})
+	s.Get("/show", func(ctx *web.Context) {
+		_, err := session.Flashes(ctx.Req.Context(), "info")
+		if err != nil {
+			ctx.RespStatusCode = http.StatusInternalServerError
+		}
+	})
+
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/add", nil))
+	cookies := recorder.Result().Cookies()
+	require.Len(t, cookies, 1)
+
+	// Store 出错的时候不能当作没有消息
+	store.err = errors.New("mock error")
+	req := httptest.NewRequest(http.MethodGet, "/show", nil)
+	req.AddCookie(cookies[0])
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+
+	// 没有 cookie 的时候依旧是没有消息
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/show", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
+
+func TestAddFlash_Copy(t *testing.T) {
+	m := &session.Manager{
+		SessCtxKey: "_sess",
+		Store:      memory.NewStore(30 * time.Minute),
+		Propagator: cookie.NewPropagator("sessid"),
+	}
+	// 切片还有空余的容量，直接 append 会写进原本的底层数组
+	msgs := make([]string, 1, 4)
+	msgs[0] = "a"
+	s := web.NewHTTPServer()
+	s.Use(m.Middleware(session.WithExcludedPaths("/add")))
+	s.Post("/add", func(ctx *web.Context) {
+		c := ctx.Req.Context()
+		sess, err := m.InitSession(ctx, "sess-1")
+		require.NoError(t, err)
+		require.NoError(t, sess.Set(c, "_flash_info", msgs))
+		require.NoError(t, session.AddFlash(c, "info", "b"))
+		require.NoError(t, session.AddFlash(c, "info", "c"))
+	})
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/add", nil))
+	assert.Equal(t, []string{"a", "", ""}, msgs[:3])
+}
+
+// failingStore err 不为 nil 的时候 Get 返回 err
+type failingStore struct {
+	session.Store
+	err error
+}
+
+func (s *failingStore) Get(ctx context.Context, id string) (session.Session, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.Store.Get(ctx, id)
+}
@@ -1,12 +1,17 @@
 package test
 
 import (
+	"context"
+	"fmt"
 	web "github.com/go-tour/web/v9"
 	"github.com/go-tour/web/v9/session"
 	"github.com/go-tour/web/v9/session/cookie"
 	"github.com/go-tour/web/v9/session/memory"
 	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 )
@@ -39,12 +44,11 @@ func TestManager(t *testing.T) {
 		}
 	})
 	s.Get("/resource", func(ctx *web.Context) {
-		sess, err := m.GetSession(ctx)
+		val, err := session.ValueFromContext[string](ctx.Req.Context(), "mykey")
 		if err != nil {
 			ctx.RespStatusCode = http.StatusInternalServerError
 			return
 		}
-		val, err := sess.Get(ctx.Req.Context(), "mykey")
 		ctx.RespData = []byte(val)
 	})
 
@@ -52,22 +56,165 @@ func TestManager(t *testing.T) {
 		_ = m.RemoveSession(ctx)
 	})
 
-	s.Use(func(next web.HandleFunc) web.HandleFunc {
-		return func(ctx *web.Context) {
-			// 执行校验
-			if ctx.Req.URL.Path != "/login" {
-				sess, err := m.GetSession(ctx)
-				// 不管发生了什么错误，对于用户我们都是返回未授权
-				if err != nil {
-					ctx.RespStatusCode = http.StatusUnauthorized
-					return
-				}
-				ctx.UserValues["sess"] = sess
-				_ = m.Refresh(ctx.Req.Context(), sess.ID())
-			}
-			next(ctx)
+	// 除了登录，其它的请求都要求有 session
+	s.Use(m.Middleware(session.WithExcludedPaths("/login")))
+
+	s.Start(":8081")
+}
+
+func TestManager_RotateSession(t *testing.T) {
+	m := &session.Manager{
+		SessCtxKey: "_sess",
+		Store:      memory.NewStore(30 * time.Minute),
+		Propagator: cookie.NewPropagator("sessid"),
+		GenIDFunc: func() string {
+			return "new-id"
+		},
+	}
+	s := web.NewHTTPServer()
+	s.Post("/login", func(ctx *web.Context) {
+		sess, err := m.RotateSession(ctx)
+		if err != nil {
+			ctx.RespStatusCode = http.StatusUnauthorized
+			return
 		}
+		// 后续拿到的都是新的 session
+		cached, _ := m.GetSession(ctx)
+		ctx.RespData = []byte(sess.ID() + "," + cached.ID())
 	})
 
-	s.Start(":8081")
+	old, err := m.Generate(context.Background(), "old-id")
+	require.NoError(t, err)
+	require.NoError(t, old.Set(context.Background(), "cart", "book"))
+
+	req := httptest.NewRequest(http.MethodPost, "/login", nil)
+	req.AddCookie(&http.Cookie{Name: "sessid", Value: "old-id"})
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, req)
+	assert.Equal(t, "new-id,new-id", recorder.Body.String())
+	cookies := recorder.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "new-id", cookies[0].Value)
+
+	sess, err := m.Get(context.Background(), "new-id")
+	require.NoError(t, err)
+	val, err := sess.Get(context.Background(), "cart")
+	require.NoError(t, err)
+	assert.Equal(t, "book", val)
+	// 默认老的 ID 立刻失效
+	_, err = m.Get(context.Background(), "old-id")
+	assert.Equal(t, session.ErrSessionNotFound, err)
+
+	// 设置了宽限期，老的 ID 在宽限期内依旧可以用
+	m.RotateGrace = time.Minute
+	m.GenIDFunc = func() string {
+		return "grace-new-id"
+	}
+	_, err = m.Generate(context.Background(), "grace-old-id")
+	require.NoError(t, err)
+	req = httptest.NewRequest(http.MethodPost, "/login", nil)
+	req.AddCookie(&http.Cookie{Name: "sessid", Value: "grace-old-id"})
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, req)
+	assert.Equal(t, "grace-new-id,grace-new-id", recorder.Body.String())
+	_, err = m.Get(context.Background(), "grace-old-id")
+	assert.NoError(t, err)
+
+	// 没有 session 的时候无法换 ID
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/login", nil))
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+}
+
+func TestManager_Middleware(t *testing.T) {
+	m := &session.Manager{
+		SessCtxKey: "_sess",
+		Store:      memory.NewStore(30 * time.Minute),
+		Propagator: cookie.NewPropagator("sessid"),
+	}
+	s := web.NewHTTPServer()
+	s.Use(m.Middleware(
+		session.WithExcludedPaths("/login", "/public/*"),
+		session.WithUnauthenticatedHandler(func(ctx *web.Context) {
+			ctx.RespStatusCode = http.StatusFound
+			ctx.Resp.Header().Set("Location", "/login")
+		})))
+	s.Post("/login", func(ctx *web.Context) {
+		sess, err := m.InitSession(ctx, "id")
+		require.NoError(t, err)
+		require.NoError(t, sess.Set(ctx.Req.Context(), "user_id", "123"))
+	})
+	s.Get("/public/index", func(ctx *web.Context) {
+		// 被排除的路径，没有 session 也可以访问
+		_, err := session.FromContext(ctx.Req.Context())
+		ctx.RespData = []byte(fmt.Sprint(err == nil))
+	})
+	s.Get("/profile", func(ctx *web.Context) {
+		uid, err := session.ValueFromContext[string](ctx.Req.Context(), "user_id")
+		require.NoError(t, err)
+		ctx.RespData = []byte(uid)
+	})
+
+	testCases := []struct {
+		name     string
+		method   string
+		path     string
+		withSess bool
+
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "unauthenticated",
+			method:   http.MethodGet,
+			path:     "/profile",
+			wantCode: http.StatusFound,
+		},
+		{
+			name:     "excluded without session",
+			method:   http.MethodGet,
+			path:     "/public/index",
+			wantCode: http.StatusOK,
+			wantBody: "false",
+		},
+		{
+			name:     "excluded with session",
+			method:   http.MethodGet,
+			path:     "/public/index",
+			withSess: true,
+			wantCode: http.StatusOK,
+			wantBody: "true",
+		},
+		{
+			name:     "authenticated",
+			method:   http.MethodGet,
+			path:     "/profile",
+			withSess: true,
+			wantCode: http.StatusOK,
+			wantBody: "123",
+		},
+	}
+
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/login", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	cookies := recorder.Result().Cookies()
+	require.Len(t, cookies, 1)
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			if tc.withSess {
+				req.AddCookie(cookies[0])
+			}
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, req)
+			assert.Equal(t, tc.wantCode, recorder.Code)
+			assert.Equal(t, tc.wantBody, recorder.Body.String())
+		})
+	}
+
+	// 没有经过 Middleware 的时候拿不到 session
+	_, err := session.FromContext(context.Background())
+	assert.Equal(t, session.ErrSessionNotFound, err)
 }
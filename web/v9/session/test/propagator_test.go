@@ -0,0 +1,170 @@
+package test
+
+import (
+	"github.com/go-tour/web/v9/session"
+	"github.com/go-tour/web/v9/session/cookie"
+	"github.com/go-tour/web/v9/session/header"
+	"github.com/go-tour/web/v9/session/query"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCookiePropagator(t *testing.T) {
+	p := cookie.NewPropagator("sessid", cookie.WithCookieOption(func(c *http.Cookie) {
+		c.HttpOnly = true
+		c.MaxAge = 3600
+	}))
+	recorder := httptest.NewRecorder()
+	require.NoError(t, p.Inject("123", recorder))
+	cookies := recorder.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.True(t, cookies[0].HttpOnly)
+	assert.Equal(t, 3600, cookies[0].MaxAge)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(cookies[0])
+	id, err := p.Extract(req)
+	require.NoError(t, err)
+	assert.Equal(t, "123", id)
+
+	recorder = httptest.NewRecorder()
+	require.NoError(t, p.Remove(recorder))
+	cookies = recorder.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, -1, cookies[0].MaxAge)
+}
+
+func TestHeaderPropagator_Extract(t *testing.T) {
+	testCases := []struct {
+		name   string
+		p      *header.Propagator
+		header string
+		val    string
+
+		wantID  string
+		wantErr error
+	}{
+		{
+			name:   "plain",
+			p:      header.NewPropagator("X-Session-Id"),
+			header: "X-Session-Id",
+			val:    "123",
+			wantID: "123",
+		},
+		{
+			name:    "missing",
+			p:       header.NewPropagator("X-Session-Id"),
+			wantErr: session.ErrIDNotFound,
+		},
+		{
+			name:   "scheme",
+			p:      header.NewPropagator("Authorization", header.WithScheme("Session")),
+			header: "Authorization",
+			val:    "session  123",
+			wantID: "123",
+		},
+		{
+			name:    "wrong scheme",
+			p:       header.NewPropagator("Authorization", header.WithScheme("Session")),
+			header:  "Authorization",
+			val:     "Bearer 123",
+			wantErr: session.ErrIDNotFound,
+		},
+		{
+			name:    "scheme only",
+			p:       header.NewPropagator("Authorization", header.WithScheme("Session")),
+			header:  "Authorization",
+			val:     "Session",
+			wantErr: session.ErrIDNotFound,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.header != "" {
+				req.Header.Set(tc.header, tc.val)
+			}
+			id, err := tc.p.Extract(req)
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.wantID, id)
+		})
+	}
+}
+
+func TestHeaderPropagator_Inject(t *testing.T) {
+	p := header.NewPropagator("Authorization", header.WithScheme("Session"),
+		header.WithResponseHeader("X-Session-Id"))
+	recorder := httptest.NewRecorder()
+	require.NoError(t, p.Inject("123", recorder))
+	assert.Equal(t, "123", recorder.Header().Get("X-Session-Id"))
+	require.NoError(t, p.Remove(recorder))
+	assert.Equal(t, []string{""}, recorder.Header().Values("X-Session-Id"))
+}
+
+func TestCompositePropagator(t *testing.T) {
+	p := session.NewCompositePropagator(
+		header.NewPropagator("X-Session-Id"),
+		query.NewPropagator("sid"),
+		cookie.NewPropagator("sessid"),
+	)
+	testCases := []struct {
+		name   string
+		req    func() *http.Request
+		wantID string
+
+		wantErr error
+	}{
+		{
+			name: "header first",
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodGet, "/?sid=query", nil)
+				req.Header.Set("X-Session-Id", "header")
+				req.AddCookie(&http.Cookie{Name: "sessid", Value: "cookie"})
+				return req
+			},
+			wantID: "header",
+		},
+		{
+			name: "query",
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodGet, "/?sid=query", nil)
+				req.AddCookie(&http.Cookie{Name: "sessid", Value: "cookie"})
+				return req
+			},
+			wantID: "query",
+		},
+		{
+			name: "cookie",
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				req.AddCookie(&http.Cookie{Name: "sessid", Value: "cookie"})
+				return req
+			},
+			wantID: "cookie",
+		},
+		{
+			name: "none",
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodGet, "/", nil)
+			},
+			wantErr: session.ErrIDNotFound,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			id, err := p.Extract(tc.req())
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.wantID, id)
+		})
+	}
+
+	recorder := httptest.NewRecorder()
+	require.NoError(t, p.Inject("123", recorder))
+	assert.Equal(t, "123", recorder.Header().Get("X-Session-Id"))
+	cookies := recorder.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "123", cookies[0].Value)
+}
@@ -0,0 +1,48 @@
+package test
+
+import (
+	"context"
+	"github.com/go-tour/web/v9/session"
+	"github.com/go-tour/web/v9/session/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+type user struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+func TestGetAs(t *testing.T) {
+	ctx := context.Background()
+	sess, err := memory.NewStore(time.Minute).Generate(ctx, "id")
+	require.NoError(t, err)
+	require.NoError(t, sess.Set(ctx, "name", "Tom"))
+	require.NoError(t, sess.Set(ctx, "user", user{ID: 1, Name: "Tom"}))
+	// 模拟持久化之后类型发生了变化
+	require.NoError(t, sess.Set(ctx, "age", float64(18)))
+	require.NoError(t, sess.Set(ctx, "profile", map[string]any{"id": float64(2), "name": "Jerry"}))
+
+	name, err := session.GetAs[string](ctx, sess, "name")
+	require.NoError(t, err)
+	assert.Equal(t, "Tom", name)
+
+	u, err := session.GetAs[user](ctx, sess, "user")
+	require.NoError(t, err)
+	assert.Equal(t, user{ID: 1, Name: "Tom"}, u)
+
+	age, err := session.GetAs[int](ctx, sess, "age")
+	require.NoError(t, err)
+	assert.Equal(t, 18, age)
+
+	u, err = session.GetAs[user](ctx, sess, "profile")
+	require.NoError(t, err)
+	assert.Equal(t, user{ID: 2, Name: "Jerry"}, u)
+
+	_, err = session.GetAs[int](ctx, sess, "name")
+	assert.Error(t, err)
+	_, err = session.GetAs[string](ctx, sess, "not-exist")
+	assert.Equal(t, session.ErrKeyNotFound, err)
+}
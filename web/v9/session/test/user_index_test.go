@@ -0,0 +1,169 @@
+package test
+
+import (
+	"context"
+	web "github.com/go-tour/web/v9"
+	"github.com/go-tour/web/v9/session"
+	"github.com/go-tour/web/v9/session/cookie"
+	"github.com/go-tour/web/v9/session/cookiestore"
+	"github.com/go-tour/web/v9/session/memory"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestManager_UserSessions(t *testing.T) {
+	for _, tc := range userIndexStores(t) {
+		t.Run(tc.name, func(t *testing.T) {
+			m := &session.Manager{
+				SessCtxKey:         "_sess",
+				Store:              tc.store,
+				Propagator:         tc.propagator,
+				MaxSessionsPerUser: 2,
+			}
+			s := web.NewHTTPServer()
+			s.Use(m.Middleware(session.WithExcludedPaths("/login")))
+			s.Post("/login", func(ctx *web.Context) {
+				_, err := m.InitSession(ctx, uuid.New().String())
+				require.NoError(t, err)
+				require.NoError(t, m.BindUser(ctx, "tom"))
+			})
+			s.Get("/profile", func(ctx *web.Context) {})
+			s.Post("/logout/others", func(ctx *web.Context) {
+				sess, err := session.FromContext(ctx.Req.Context())
+				require.NoError(t, err)
+				require.NoError(t, m.RevokeAllSessions(ctx.Req.Context(), "tom", sess.ID()))
+			})
+
+			login := func(ua string) *http.Cookie {
+				req := httptest.NewRequest(http.MethodPost, "/login", nil)
+				req.Header.Set("User-Agent", ua)
+				recorder := httptest.NewRecorder()
+				s.ServeHTTP(recorder, req)
+				require.Equal(t, http.StatusOK, recorder.Code)
+				cookies := recorder.Result().Cookies()
+				require.Len(t, cookies, 1)
+				return cookies[0]
+			}
+			access := func(method string, path string, c *http.Cookie) int {
+				req := httptest.NewRequest(method, path, nil)
+				req.AddCookie(c)
+				recorder := httptest.NewRecorder()
+				s.ServeHTTP(recorder, req)
+				return recorder.Code
+			}
+
+			phone := login("phone")
+			time.Sleep(time.Millisecond)
+			laptop := login("laptop")
+			time.Sleep(time.Millisecond)
+			// 超过了上限，最老的 phone 被踢掉
+			tablet := login("tablet")
+			assert.Equal(t, http.StatusUnauthorized, access(http.MethodGet, "/profile", phone))
+
+			infos, err := m.ListSessions(context.Background(), "tom")
+			require.NoError(t, err)
+			require.Len(t, infos, 2)
+			assert.Equal(t, laptop.Value, infos[0].ID)
+			assert.Equal(t, "laptop", infos[0].UserAgent)
+			assert.Equal(t, "192.0.2.1", infos[0].IP)
+			assert.Equal(t, tablet.Value, infos[1].ID)
+			assert.Equal(t, "tom", infos[1].UserID)
+
+			// 访问会更新最后访问时间
+			lastSeen := infos[0].LastSeen
+			time.Sleep(time.Millisecond)
+			assert.Equal(t, http.StatusOK, access(http.MethodGet, "/profile", laptop))
+			infos, err = m.ListSessions(context.Background(), "tom")
+			require.NoError(t, err)
+			assert.True(t, infos[0].LastSeen.After(lastSeen))
+
+			// 不是这个用户的 session
+			assert.Equal(t, session.ErrSessionNotFound,
+				m.RevokeSession(context.Background(), "jerry", laptop.Value))
+
+			// 退出其它所有设备
+			assert.Equal(t, http.StatusOK, access(http.MethodPost, "/logout/others", tablet))
+			assert.Equal(t, http.StatusUnauthorized, access(http.MethodGet, "/profile", laptop))
+			assert.Equal(t, http.StatusOK, access(http.MethodGet, "/profile", tablet))
+
+			require.NoError(t, m.RevokeSession(context.Background(), "tom", tablet.Value))
+			assert.Equal(t, http.StatusUnauthorized, access(http.MethodGet, "/profile", tablet))
+			infos, err = m.ListSessions(context.Background(), "tom")
+			require.NoError(t, err)
+			assert.Len(t, infos, 0)
+		})
+	}
+}
+
+// TestManager_BindUser 登录之前就已经有 session 了，那么当前的 session 可能是最老的
+func TestManager_BindUser(t *testing.T) {
+	for _, tc := range userIndexStores(t) {
+		t.Run(tc.name, func(t *testing.T) {
+			m := &session.Manager{
+				SessCtxKey:         "_sess",
+				Store:              tc.store,
+				Propagator:         tc.propagator,
+				MaxSessionsPerUser: 1,
+			}
+			newCtx := func(id string) *web.Context {
+				sess, err := m.Generate(context.Background(), id)
+				require.NoError(t, err)
+				req := httptest.NewRequest(http.MethodPost, "/login", nil)
+				recorder := httptest.NewRecorder()
+				require.NoError(t, m.Inject(sess.ID(), recorder))
+				req.AddCookie(recorder.Result().Cookies()[0])
+				return &web.Context{Req: req, Resp: httptest.NewRecorder()}
+			}
+
+			oldest := newCtx("oldest")
+			oldestSess, err := m.GetSession(oldest)
+			require.NoError(t, err)
+			time.Sleep(2 * time.Millisecond)
+			other := newCtx("other")
+			otherSess, err := m.GetSession(other)
+			require.NoError(t, err)
+			require.NoError(t, m.BindUser(other, "tom"))
+			time.Sleep(2 * time.Millisecond)
+			bindAt := time.Now()
+			require.NoError(t, m.BindUser(oldest, "tom"))
+
+			infos, err := m.ListSessions(context.Background(), "tom")
+			require.NoError(t, err)
+			require.Len(t, infos, 1)
+			assert.Equal(t, oldestSess.ID(), infos[0].ID)
+			// 创建时间而不是绑定的时间
+			assert.True(t, infos[0].CreatedAt.Before(bindAt))
+			_, err = m.Get(context.Background(), otherSess.ID())
+			assert.Equal(t, session.ErrSessionNotFound, err)
+		})
+	}
+}
+
+type userIndexStore struct {
+	name       string
+	store      session.Store
+	propagator session.Propagator
+}
+
+func userIndexStores(t *testing.T) []userIndexStore {
+	cs, err := cookiestore.NewStore(30*time.Minute, [][]byte{[]byte("0123456789abcdef")},
+		cookiestore.WithRegistry(cookiestore.NewMemoryRegistry()))
+	require.NoError(t, err)
+	return []userIndexStore{
+		{
+			name:       "memory",
+			store:      memory.NewStore(30 * time.Minute),
+			propagator: cookie.NewPropagator("sessid"),
+		},
+		{
+			name:       "cookie",
+			store:      cs,
+			propagator: cookiestore.NewPropagator("sessid"),
+		},
+	}
+}
@@ -2,15 +2,70 @@ package session
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"net/http"
+	"time"
 )
 
+var (
+	// ErrKeyNotFound Session 里面没有这个 key
+	ErrKeyNotFound = errors.New("session: 找不到这个 key")
+	// ErrSessionNotFound Session 不存在，或者已经过期了
+	ErrSessionNotFound = errors.New("session: 找不到 session")
+	// ErrIDNotFound 请求里面没有带上 session id
+	ErrIDNotFound = errors.New("session: 请求里面没有 session id")
+)
+
+// IsNoSession 判断 err 是不是代表请求没有 session，
+// 也就是没有带上 session id，或者 session 已经过期了。
+// 其余的错误，例如 Store 不可用，返回 false
+func IsNoSession(err error) bool {
+	return errors.Is(err, ErrSessionNotFound) || errors.Is(err, ErrIDNotFound) ||
+		errors.Is(err, http.ErrNoCookie)
+}
+
+// Session 的实现必须是并发安全的，因为同一个用户的多个请求可能会同时操作同一个 Session
 type Session interface {
-	Get(ctx context.Context, key string) (string, error)
-	Set(ctx context.Context, key string, val string) error
+	// Get 返回 key 对应的值，找不到的时候返回 ErrKeyNotFound
+	// 如果想要拿到具体的类型，可以使用 GetAs
+	Get(ctx context.Context, key string) (any, error)
+	// Set 设置值。持久化的 Store 会序列化 val，所以 val 应该是可以被序列化的
+	Set(ctx context.Context, key string, val any) error
+	Delete(ctx context.Context, key string) error
+	Keys(ctx context.Context) ([]string, error)
+	// Clear 删除所有的值，但是 Session 本身依旧有效
+	Clear(ctx context.Context) error
 	ID() string
 }
 
+// Flusher 由那些延迟写回的 Session 实现，
+// 例如数据库的 Session 只有在请求结束的时候才会把修改写回去
+type Flusher interface {
+	// Flush 将修改写回存储，没有修改的话什么也不做
+	Flush(ctx context.Context) error
+}
+
+// GetAs 返回 key 对应的值，并且转换为类型 T
+// 持久化的 Store 在序列化之后，值的类型可能会发生变化，例如 int 变成 float64，
+// 这种时候会借助 JSON 来转换一下
+func GetAs[T any](ctx context.Context, sess Session, key string) (T, error) {
+	var res T
+	val, err := sess.Get(ctx, key)
+	if err != nil {
+		return res, err
+	}
+	if t, ok := val.(T); ok {
+		return t, nil
+	}
+	bs, err := json.Marshal(val)
+	if err != nil {
+		return res, err
+	}
+	err = json.Unmarshal(bs, &res)
+	return res, err
+}
+
 // Store 管理 Session
 // 从设计的角度来说，Generate 方法和 Refresh 在处理 Session 过期时间上有点关系
 // 也就是说，如果 Generate 设计为接收一个 expiration 参数，
@@ -20,12 +75,17 @@ type Store interface {
 	// Generate 生成一个 session
 	Generate(ctx context.Context, id string) (Session, error)
 	// Refresh 这种设计是一直用同一个 id 的
-	// 如果想支持 Refresh 换 ID，那么可以重新生成一个，并移除原有的
-	// 又或者 Refresh(ctx context.Context, id string) (Session, error)
-	// 其中返回的是一个新的 Session
+	// 如果需要换 ID，那么使用 Rotate
 	Refresh(ctx context.Context, id string) error
 	Remove(ctx context.Context, id string) error
 	Get(ctx context.Context, id string) (Session, error)
+	// Rotate 换 ID，用于防止 session fixation 攻击，例如登录成功之后就应该换一个 ID
+	// 实现需要将 oldID 的数据复制到 newID 下面，并且返回新的 Session。
+	// grace 小于等于 0 的时候 oldID 立刻失效，否则在 grace 之后才失效，
+	// 这样那些已经带着 oldID 发出来的并发请求依旧能够正常处理
+	Rotate(ctx context.Context, oldID string, newID string, grace time.Duration) (Session, error)
+	// UserIndex 用户到 session 的索引，Manager 依赖它来列出，限制和踢掉某个用户的 session
+	UserIndex
 }
 
 type Propagator interface {
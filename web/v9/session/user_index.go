@@ -0,0 +1,134 @@
+package session
+
+import (
+	"context"
+	web "github.com/go-tour/web/v9"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// SessionInfo session 的元数据
+type SessionInfo struct {
+	ID     string
+	UserID string
+	// CreatedAt session 的创建时间，而不是绑定用户的时间，换 ID 也不会改变
+	CreatedAt time.Time
+	LastSeen  time.Time
+	IP        string
+	UserAgent string
+}
+
+// UserIndex 维护用户到 session 的索引，是 Store 的一部分
+// 实现需要保证 Remove 和过期的 session 不会再出现在 Sessions 里面，
+// 并且 Rotate 之后索引指向新的 ID
+type UserIndex interface {
+	// Bind 将 session 绑定到用户上，一个 session 只能属于一个用户
+	// info 里面的 CreatedAt 会被忽略，由 Store 填充为 session 真正的创建时间
+	Bind(ctx context.Context, info SessionInfo) error
+	// Touch 更新最后访问时间，没有绑定用户的 session 直接忽略
+	Touch(ctx context.Context, id string, lastSeen time.Time) error
+	// Sessions 返回用户所有有效的 session
+	Sessions(ctx context.Context, uid string) ([]SessionInfo, error)
+}
+
+// BindUser 将当前的 session 绑定到用户上，一般在登录成功之后调用
+// 如果设置了 MaxSessionsPerUser，那么超出的部分会按照创建时间从老到新被踢掉
+func (m *Manager) BindUser(ctx *web.Context, uid string) error {
+	sess, err := m.GetSession(ctx)
+	if err != nil {
+		return err
+	}
+	c := ctx.Req.Context()
+	err = m.Bind(c, SessionInfo{
+		ID:        sess.ID(),
+		UserID:    uid,
+		LastSeen:  time.Now(),
+		IP:        m.clientIP(ctx.Req),
+		UserAgent: ctx.Req.UserAgent(),
+	})
+	if err != nil || m.MaxSessionsPerUser <= 0 {
+		return err
+	}
+	infos, err := m.ListSessions(c, uid)
+	if err != nil {
+		return err
+	}
+	// 当前的 session 不会被踢掉，但是依旧占用一个名额
+	excess := len(infos) - m.MaxSessionsPerUser
+	for i := 0; i < len(infos) && excess > 0; i++ {
+		if infos[i].ID == sess.ID() {
+			continue
+		}
+		if err = m.Store.Remove(c, infos[i].ID); err != nil {
+			return err
+		}
+		excess--
+	}
+	return nil
+}
+
+// ListSessions 返回用户所有的 session，按照创建时间从老到新排序
+func (m *Manager) ListSessions(ctx context.Context, uid string) ([]SessionInfo, error) {
+	infos, err := m.Sessions(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].CreatedAt.Before(infos[j].CreatedAt)
+	})
+	return infos, nil
+}
+
+// RevokeSession 踢掉用户的某个 session
+// 如果这个 session 不属于这个用户，返回 ErrSessionNotFound
+func (m *Manager) RevokeSession(ctx context.Context, uid string, id string) error {
+	infos, err := m.ListSessions(ctx, uid)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if info.ID == id {
+			return m.Store.Remove(ctx, id)
+		}
+	}
+	return ErrSessionNotFound
+}
+
+// RevokeAllSessions 踢掉用户所有的 session，除了 except 里面的
+// 例如“退出其它所有设备”，那么 except 就是当前的 session id
+func (m *Manager) RevokeAllSessions(ctx context.Context, uid string, except ...string) error {
+	infos, err := m.ListSessions(ctx, uid)
+	if err != nil {
+		return err
+	}
+outer:
+	for _, info := range infos {
+		for _, id := range except {
+			if info.ID == id {
+				continue outer
+			}
+		}
+		if err = m.Store.Remove(ctx, info.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// touch 更新最后访问时间，失败了也不影响正常的请求
+func (m *Manager) touch(ctx context.Context, id string) {
+	_ = m.Touch(ctx, id, time.Now())
+}
+
+func (m *Manager) clientIP(req *http.Request) string {
+	if m.ClientIP != nil {
+		return m.ClientIP(req)
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
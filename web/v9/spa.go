@@ -0,0 +1,179 @@
+package v9
+
+import (
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+type SPAHandlerOption func(h *SPAHandler)
+
+// SPAHandler 托管打包好的单页应用，例如 embed.FS 里面的 React 应用
+// 1. 存在的文件直接返回，带 hash 的文件名会加上一年的缓存
+// 2. 不存在的文件：看起来像静态资源（有扩展名）或者是 API 的路径返回 404，
+// 其它的都返回 index.html，交给前端路由处理
+// 3. index.html 不缓存，可以通过 TemplateEngine 注入运行时的配置
+type SPAHandler struct {
+	fs          fs.FS
+	static      *StaticResourceHandler
+	pathPrefix  string
+	index       string
+	apiPrefixes []string
+	hashed      func(name string) bool
+
+	tplName string
+	config  func(ctx *Context) any
+}
+
+// NewSPAHandler pathPrefix 是单页应用挂载的路径，例如 /admin
+func NewSPAHandler(fsys fs.FS, pathPrefix string, opts ...SPAHandlerOption) *SPAHandler {
+	pathPrefix = strings.TrimSuffix(pathPrefix, "/")
+	res := &SPAHandler{
+		fs:         fsys,
+		static:     NewStaticResourceHandlerFS(fsys, pathPrefix),
+		pathPrefix: pathPrefix,
+		index:      "index.html",
+		hashed:     isHashedAsset,
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res
+}
+
+// WithSPAIndex 入口文件，默认是 index.html
+func WithSPAIndex(name string) SPAHandlerOption {
+	return func(h *SPAHandler) {
+		h.index = name
+	}
+}
+
+// WithSPAAPIPrefixes 这些前缀下的路径不会返回 index.html，例如 /api
+// 前缀是完整的请求路径，不需要去掉 pathPrefix
+func WithSPAAPIPrefixes(prefixes ...string) SPAHandlerOption {
+	return func(h *SPAHandler) {
+		h.apiPrefixes = append(h.apiPrefixes, prefixes...)
+	}
+}
+
+// WithSPAHashedAsset 判断文件名里面是否带有内容 hash，带有的会被长期缓存
+// 默认认为最后一段 . 或者 - 后面，至少 8 位并且带有数字的字母数字组合是 hash，
+// 例如 main.3f4a2b1c.js 和 index-4sOnSDnx.js
+func WithSPAHashedAsset(fn func(name string) bool) SPAHandlerOption {
+	return func(h *SPAHandler) {
+		h.hashed = fn
+	}
+}
+
+// WithSPAConfig 通过 TemplateEngine 渲染 index.html，config 的返回值就是渲染的数据
+// tplName 是 index.html 在 TemplateEngine 里面的名字，例如：
+//
+//	<script>window.__CONFIG__ = {{ . }}</script>
+func WithSPAConfig(tplName string, config func(ctx *Context) any) SPAHandlerOption {
+	return func(h *SPAHandler) {
+		h.tplName = tplName
+		h.config = config
+	}
+}
+
+// Handle 可以注册在具体的路由上。
+// 因为通配符只能匹配一段，所以要支持多段的前端路由，需要使用 Middleware
+func (h *SPAHandler) Handle(ctx *Context) {
+	name := h.static.fileName(ctx.Req.URL.Path)
+	if name != h.index {
+		if info, err := fs.Stat(h.fs, name); err == nil && !info.IsDir() {
+			if h.hashed(name) {
+				ctx.SetCacheControl(CacheControl{
+					Public:    true,
+					MaxAge:    365 * 24 * time.Hour,
+					Immutable: true,
+				})
+			}
+			h.static.serveFile(ctx, name, info)
+			return
+		}
+		// 不存在的静态资源和 API 不能返回 index.html，不然前端拿到的是一个 HTML 页面
+		if h.isAPI(ctx.Req.URL.Path) || (name != "." && path.Ext(name) != "") {
+			ctx.RespStatusCode = http.StatusNotFound
+			return
+		}
+	}
+	h.serveIndex(ctx)
+}
+
+// Middleware 接管 pathPrefix 下面所有没有命中路由的 GET 和 HEAD 请求
+func (h *SPAHandler) Middleware() Middleware {
+	return func(next HandleFunc) HandleFunc {
+		return func(ctx *Context) {
+			if ctx.MatchedRoute != "" ||
+				(ctx.Req.Method != http.MethodGet && ctx.Req.Method != http.MethodHead) ||
+				!h.mounted(ctx.Req.URL.Path) {
+				next(ctx)
+				return
+			}
+			h.Handle(ctx)
+		}
+	}
+}
+
+func (h *SPAHandler) serveIndex(ctx *Context) {
+	// 入口文件必须每次都校验，否则发版之后用户拿到的还是旧的
+	ctx.SetCacheControl(CacheControl{NoCache: true})
+	if h.config == nil {
+		info, err := fs.Stat(h.fs, h.index)
+		if err != nil {
+			h.static.respError(ctx, err)
+			return
+		}
+		h.static.serveFile(ctx, h.index, info)
+		return
+	}
+	if err := ctx.Render(h.tplName, h.config(ctx)); err != nil {
+		ctx.RespData = []byte("服务器错误")
+		return
+	}
+	ctx.Resp.Header().Set("Content-Type", "text/html; charset=utf-8")
+}
+
+func (h *SPAHandler) mounted(urlPath string) bool {
+	return h.pathPrefix == "" || urlPath == h.pathPrefix ||
+		strings.HasPrefix(urlPath, h.pathPrefix+"/")
+}
+
+func (h *SPAHandler) isAPI(urlPath string) bool {
+	for _, prefix := range h.apiPrefixes {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if urlPath == prefix || strings.HasPrefix(urlPath, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// isHashedAsset 判断文件名的最后一段是不是 hash，
+// 例如 main.3f4a2b1c.js 里面的 3f4a2b1c，index-4sOnSDnx.js 里面的 4sOnSDnx
+func isHashedAsset(name string) bool {
+	base := path.Base(name)
+	base = strings.TrimSuffix(base, path.Ext(base))
+	idx := strings.LastIndexAny(base, ".-")
+	if idx < 0 {
+		return false
+	}
+	hash := base[idx+1:]
+	if len(hash) < 8 {
+		return false
+	}
+	hasDigit := false
+	for _, c := range hash {
+		switch {
+		case '0' <= c && c <= '9':
+			hasDigit = true
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', c == '_':
+		default:
+			return false
+		}
+	}
+	return hasDigit
+}
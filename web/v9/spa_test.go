@@ -0,0 +1,186 @@
+package v9
+
+import (
+	"embed"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+//go:embed testdata/spa
+var spaFS embed.FS
+
+func TestSPAHandler(t *testing.T) {
+	dist, err := fs.Sub(spaFS, "testdata/spa")
+	require.NoError(t, err)
+	h := NewSPAHandler(dist, "/admin", WithSPAAPIPrefixes("/admin/api"))
+	s := NewHTTPServer()
+	s.Use(h.Middleware())
+	s.Get("/admin/api/users", func(ctx *Context) {
+		_ = ctx.RespJSONOK([]string{"tom"})
+	})
+	s.Get("/hello", func(ctx *Context) {
+		ctx.RespData = []byte("hello")
+	})
+
+	testCases := []struct {
+		name   string
+		method string
+		path   string
+
+		wantCode         int
+		wantBody         string
+		wantCacheControl string
+		wantType         string
+	}{
+		{
+			name:             "index",
+			path:             "/admin/",
+			wantCode:         http.StatusOK,
+			wantCacheControl: "no-cache",
+			wantType:         "text/html; charset=utf-8",
+		},
+		{
+			name:             "mount point",
+			path:             "/admin",
+			wantCode:         http.StatusOK,
+			wantCacheControl: "no-cache",
+			wantType:         "text/html; charset=utf-8",
+		},
+		{
+			name:             "index.html",
+			path:             "/admin/index.html",
+			wantCode:         http.StatusOK,
+			wantCacheControl: "no-cache",
+			wantType:         "text/html; charset=utf-8",
+		},
+		{
+			// 多段的前端路由
+			name:             "client route",
+			path:             "/admin/users/123/edit",
+			wantCode:         http.StatusOK,
+			wantCacheControl: "no-cache",
+			wantType:         "text/html; charset=utf-8",
+		},
+		{
+			name:             "hashed asset",
+			path:             "/admin/assets/index-4sOnSDnx.js",
+			wantCode:         http.StatusOK,
+			wantBody:         "console.log('admin')\n",
+			wantCacheControl: "public, max-age=31536000, immutable",
+			wantType:         "text/javascript; charset=utf-8",
+		},
+		{
+			name:     "asset",
+			path:     "/admin/robots.txt",
+			wantCode: http.StatusOK,
+			wantBody: "User-agent: *\nDisallow: /\n",
+			wantType: "text/plain; charset=utf-8",
+		},
+		{
+			name:     "missing asset",
+			path:     "/admin/assets/missing-12345678.js",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "missing api",
+			path:     "/admin/api/orders",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "api",
+			path:     "/admin/api/users",
+			wantCode: http.StatusOK,
+			wantBody: `["tom"]`,
+		},
+		{
+			name:     "not mounted",
+			path:     "/other/page",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "other route",
+			path:     "/hello",
+			wantCode: http.StatusOK,
+			wantBody: "hello",
+		},
+		{
+			name:     "post",
+			method:   http.MethodPost,
+			path:     "/admin/users",
+			wantCode: http.StatusNotFound,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			method := tc.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, httptest.NewRequest(method, tc.path, nil))
+			assert.Equal(t, tc.wantCode, recorder.Code)
+			if tc.wantBody != "" {
+				assert.Equal(t, tc.wantBody, recorder.Body.String())
+			}
+			if tc.wantType == "text/html; charset=utf-8" {
+				assert.Contains(t, recorder.Body.String(), `<div id="root"></div>`)
+			}
+			assert.Equal(t, tc.wantCacheControl, recorder.Header().Get("Cache-Control"))
+			if tc.wantType != "" {
+				assert.Equal(t, tc.wantType, recorder.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestSPAHandler_Config(t *testing.T) {
+	dist, err := fs.Sub(spaFS, "testdata/spa")
+	require.NoError(t, err)
+	engine := &GoTemplateEngine{}
+	require.NoError(t, engine.LoadFromFS(dist, "index.html"))
+	type config struct {
+		APIBase string `json:"apiBase"`
+		User    string `json:"user"`
+	}
+	h := NewSPAHandler(dist, "/admin", WithSPAConfig("index.html", func(ctx *Context) any {
+		return config{APIBase: "/admin/api", User: ctx.Req.Header.Get("X-User")}
+	}))
+	s := NewHTTPServer(ServerWithTemplateEngine(engine))
+	s.Use(h.Middleware())
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/settings", nil)
+	// 注入的数据需要被转义，不能跳出 script
+	req.Header.Set("X-User", "</script><script>alert(1)")
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "no-cache", recorder.Header().Get("Cache-Control"))
+	assert.Equal(t, "text/html; charset=utf-8", recorder.Header().Get("Content-Type"))
+	body := recorder.Body.String()
+	assert.Contains(t, body,
+		`window.__CONFIG__ = {"apiBase":"/admin/api","user":"\u003c/script\u003e\u003cscript\u003ealert(1)"}`)
+}
+
+func Test_isHashedAsset(t *testing.T) {
+	testCases := []struct {
+		name string
+		want bool
+	}{
+		{name: "static/js/main.3f4a2b1c.js", want: true},
+		{name: "assets/index-4sOnSDnx.js", want: true},
+		{name: "main.3f4a2b1c.chunk.css", want: false},
+		{name: "jquery-validation.js", want: false},
+		{name: "favicon.ico", want: false},
+		{name: "react-dom.production.min.js", want: false},
+		{name: "index.html", want: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, isHashedAsset(tc.name))
+		})
+	}
+}
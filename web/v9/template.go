@@ -3,8 +3,11 @@ package v9
 import (
 	"bytes"
 	"context"
+	"errors"
 	"html/template"
 	"io/fs"
+	"sync"
+	"time"
 )
 
 type TemplateEngine interface {
@@ -13,16 +16,65 @@ type TemplateEngine interface {
 	Render(ctx context.Context, tplName string, data any) ([]byte, error)
 }
 
+// LayoutTemplateEngine 支持在渲染的时候指定布局
+type LayoutTemplateEngine interface {
+	TemplateEngine
+	// RenderLayout 使用 layout 渲染页面，layout 为空代表不使用布局
+	RenderLayout(ctx context.Context, tplName string, layout string, data any) ([]byte, error)
+}
+
+// TemplateFuncProvider 根据渲染时的 context 生成模板方法
+// 主要用于 CSRF token 这种每个请求都不同的数据。
+// 注意它会被传入 context.Background() 调用一次，用于在解析模板之前注册方法名
+type TemplateFuncProvider func(ctx context.Context) template.FuncMap
+
 type GoTemplateEngine struct {
 	T *template.Template
 	// 也可以考虑设计为 map[string]*template.Template
 	// 但是其实没太大必要，因为 template.Template 本身就提供了按名索引的功能
+
+	funcProviders []TemplateFuncProvider
+	// clones 用于绑定请求方法的 T 的副本，渲染完之后放回去复用
+	clones sync.Pool
+}
+
+// templateClone base 变了说明重新加载过模板，这个副本就不能用了
+type templateClone struct {
+	base *template.Template
+	t    *template.Template
+}
+
+// RegisterFuncProvider 注册一个 TemplateFuncProvider
+// 应该在加载模板之前调用，否则模板里面使用的方法会因为未定义而解析失败
+func (g *GoTemplateEngine) RegisterFuncProvider(p TemplateFuncProvider) {
+	g.funcProviders = append(g.funcProviders, p)
+	if g.T != nil {
+		g.T.Funcs(p(context.Background()))
+	}
 }
 
 func (g *GoTemplateEngine) Render(ctx context.Context,
 	tplName string, data any) ([]byte, error) {
 	res := &bytes.Buffer{}
-	err := g.T.ExecuteTemplate(res, tplName, data)
+	if len(g.funcProviders) == 0 {
+		err := g.T.ExecuteTemplate(res, tplName, data)
+		return res.Bytes(), err
+	}
+	// html/template 执行过之后就不能再 Clone 了，所以 g.T 本身永远不执行。
+	// 副本同一时间只会被一个请求使用，执行之前重新绑定这次请求的方法
+	c, _ := g.clones.Get().(*templateClone)
+	if c == nil || c.base != g.T {
+		t, err := g.T.Clone()
+		if err != nil {
+			return nil, err
+		}
+		c = &templateClone{base: g.T, t: t}
+	}
+	for _, p := range g.funcProviders {
+		c.t.Funcs(p(ctx))
+	}
+	err := c.t.ExecuteTemplate(res, tplName, data)
+	g.clones.Put(c)
 	return res.Bytes(), err
 }
 
@@ -30,18 +82,57 @@ func (g *GoTemplateEngine) Render(ctx context.Context,
 
 func (g *GoTemplateEngine) LoadFromGlob(pattern string) error {
 	var err error
-	g.T, err = template.ParseGlob(pattern)
+	g.T, err = g.newTemplate().ParseGlob(pattern)
 	return err
 }
 
 func (g *GoTemplateEngine) LoadFromFiles(filenames ...string) error {
 	var err error
-	g.T, err = template.ParseFiles(filenames...)
+	g.T, err = g.newTemplate().ParseFiles(filenames...)
 	return err
 }
 
 func (g *GoTemplateEngine) LoadFromFS(fs fs.FS, patterns ...string) error {
 	var err error
-	g.T, err = template.ParseFS(fs, patterns...)
+	g.T, err = g.newTemplate().ParseFS(fs, patterns...)
 	return err
 }
+
+// newTemplate 创建一个注册了所有模板方法的空模板
+func (g *GoTemplateEngine) newTemplate() *template.Template {
+	t := template.New("").Funcs(DefaultTemplateFuncs())
+	for _, p := range g.funcProviders {
+		t.Funcs(p(context.Background()))
+	}
+	return t
+}
+
+// DefaultTemplateFuncs 模板引擎默认注册的方法
+// - formatDate 格式化时间，例如 {{ formatDate .CreatedAt "2006-01-02" }}，零值输出空字符串
+// - csrfToken、csrfField 和 cspNonce 只是占位，让模板在没有注册对应的
+// TemplateFuncProvider 的时候也能解析，真正的值由 csrf 和 secure 中间件提供
+// - urlFor 同样只是占位，需要注册 HTTPServer.TemplateFuncs，根据路由的名字生成 URL
+func DefaultTemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"formatDate": formatDate,
+		"csrfToken": func() string {
+			return ""
+		},
+		"csrfField": func() template.HTML {
+			return ""
+		},
+		"cspNonce": func() string {
+			return ""
+		},
+		"urlFor": func(name string, pairs ...any) (string, error) {
+			return "", errors.New("web: 没有注册 HTTPServer.TemplateFuncs")
+		},
+	}
+}
+
+func formatDate(t time.Time, layout string) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(layout)
+}